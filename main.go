@@ -47,20 +47,117 @@ func cli() *cobra.Command {
 	var noBuiltInFlag bool
 	var strictFlag bool
 	var warnMissingPackagesFlag bool
+	var mirrors []string
+	var fromRegistries []string
+	var tagCatalogFile string
+	var tagPolicy string
+	var fipsFlag bool
+	var splitRuntimeFlag bool
+	var fixNonrootFlag bool
+	var lockfile string
+	var digestsPath string
+	var pin bool
+	var check bool
+	var bumpPolicy string
 
 	// Default log level is info
 	var level = slag.Level(slog.LevelInfo)
 
+	// setup configures logging and returns the context for the command
+	setup := func(cmd *cobra.Command) (context.Context, *clog.Logger) {
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: &level})))
+		log := clog.New(slog.Default().Handler())
+		return clog.WithLogger(cmd.Context(), log), log
+	}
+
+	// convert parses and converts a Dockerfile with the options given as flags
+	convert := func(ctx context.Context, log *clog.Logger, raw []byte) (*dfc.Dockerfile, error) {
+		// Use dfc2 to parse the Dockerfile
+		dockerfile, err := dfc.ParseDockerfile(ctx, raw)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse dockerfile: %w", err)
+		}
+
+		// Setup conversion options
+		opts := dfc.Options{
+			Organization:        org,
+			Registry:            registry,
+			Update:              updateFlag,
+			NoBuiltIn:           noBuiltInFlag,
+			Strict:              strictFlag,
+			WarnMissingPackages: warnMissingPackagesFlag,
+			Mirrors:             mirrors,
+			TagPolicy:           dfc.TagPolicy(tagPolicy),
+			FIPS:                fipsFlag,
+			SplitRuntimeStage:   splitRuntimeFlag,
+			FixNonroot:          fixNonrootFlag,
+			FromRegistries:      fromRegistries,
+		}
+
+		switch opts.TagPolicy {
+		case dfc.TagPolicyNextNewer, dfc.TagPolicyLatest:
+		default:
+			return nil, fmt.Errorf("unknown tag policy %q, use %s or %s", tagPolicy, dfc.TagPolicyNextNewer, dfc.TagPolicyLatest)
+		}
+
+		// If a tag catalog is provided, check the converted tags against it
+		if tagCatalogFile != "" {
+			catalog, err := dfc.NewFileTagCatalog(tagCatalogFile)
+			if err != nil {
+				return nil, err
+			}
+			opts.TagCatalog = catalog
+		}
+
+		// If custom mappings file is provided, load it as ExtraMappings
+		if mappingsFile != "" {
+			log.Info("Loading custom mappings file", "file", mappingsFile)
+			mappingsBytes, err := os.ReadFile(mappingsFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading mappings file %s: %w", mappingsFile, err)
+			}
+
+			var extraMappings dfc.MappingsConfig
+			if err := yaml.Unmarshal(mappingsBytes, &extraMappings); err != nil {
+				return nil, fmt.Errorf("unmarshalling package mappings: %w", err)
+			}
+
+			opts.ExtraMappings = extraMappings
+		}
+
+		// If --no-builtin flag is used without --mappings, warn the user
+		if noBuiltInFlag && mappingsFile == "" {
+			log.Warn("Using --no-builtin without --mappings will use default conversion logic without any package/image mappings")
+		}
+
+		// Convert the Dockerfile
+		convertedDockerfile, err := dockerfile.Convert(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("converting dockerfile: %w", err)
+		}
+
+		// Report any problems found during conversion
+		for _, diag := range convertedDockerfile.Diagnostics {
+			switch diag.Severity {
+			case dfc.SeverityError:
+				log.Error(diag.Message, "line", diag.Line, "code", diag.Code)
+			case dfc.SeverityWarning:
+				log.Warn(diag.Message, "line", diag.Line, "code", diag.Code)
+			default:
+				log.Info(diag.Message, "line", diag.Line, "code", diag.Code)
+			}
+		}
+
+		return convertedDockerfile, nil
+	}
+
 	cmd := &cobra.Command{
 		Use:     "dfc",
 		Example: "dfc <path_to_dockerfile>",
 		Args:    cobra.MaximumNArgs(1),
 		Version: dfc.Version(),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Setup logging
-			slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: &level})))
-			log := clog.New(slog.Default().Handler())
-			ctx := clog.WithLogger(cmd.Context(), log)
+			ctx, log := setup(cmd)
 
 			// If update flag is set but no args, just update and exit
 			if updateFlag && len(args) == 0 {
@@ -81,66 +178,15 @@ func cli() *cobra.Command {
 				return fmt.Errorf("requires at least 1 arg(s), only received 0")
 			}
 
-			// Allow for piping into the CLI if first arg is "-"
-			input := cmd.InOrStdin()
-			isFile := args[0] != "-"
-			var path string
-			if isFile {
-				path = args[0]
-				file, err := os.Open(filepath.Clean(path))
-				if err != nil {
-					return fmt.Errorf("failed open file: %s: %w", path, err)
-				}
-				defer file.Close()
-				input = file
-			}
-			buf := new(bytes.Buffer)
-			if _, err := buf.ReadFrom(input); err != nil {
-				return fmt.Errorf("failed to read input: %w", err)
-			}
-			raw := buf.Bytes()
-
-			// Use dfc2 to parse the Dockerfile
-			dockerfile, err := dfc.ParseDockerfile(ctx, raw)
+			path, raw, err := readInput(cmd, args[0])
 			if err != nil {
-				return fmt.Errorf("unable to parse dockerfile: %w", err)
-			}
-
-			// Setup conversion options
-			opts := dfc.Options{
-				Organization:        org,
-				Registry:            registry,
-				Update:              updateFlag,
-				NoBuiltIn:           noBuiltInFlag,
-				Strict:              strictFlag,
-				WarnMissingPackages: warnMissingPackagesFlag,
+				return err
 			}
+			isFile := path != ""
 
-			// If custom mappings file is provided, load it as ExtraMappings
-			if mappingsFile != "" {
-				log.Info("Loading custom mappings file", "file", mappingsFile)
-				mappingsBytes, err := os.ReadFile(mappingsFile)
-				if err != nil {
-					return fmt.Errorf("reading mappings file %s: %w", mappingsFile, err)
-				}
-
-				var extraMappings dfc.MappingsConfig
-				if err := yaml.Unmarshal(mappingsBytes, &extraMappings); err != nil {
-					return fmt.Errorf("unmarshalling package mappings: %w", err)
-				}
-
-				opts.ExtraMappings = extraMappings
-			}
-
-			// If --no-builtin flag is used without --mappings, warn the user
-			if noBuiltInFlag && mappingsFile == "" {
-				log.Warn("Using --no-builtin without --mappings will use default conversion logic without any package/image mappings")
-			}
-
-			// Convert the Dockerfile
-			convertedDockerfile, err := dockerfile.Convert(ctx, opts)
+			convertedDockerfile, err := convert(ctx, log, raw)
 			if err != nil {
-				return fmt.Errorf("converting dockerfile: %w", err)
+				return err
 			}
 
 			// Output the Dockerfile as JSON
@@ -193,16 +239,205 @@ func cli() *cobra.Command {
 		},
 	}
 
-	cmd.Flags().StringVar(&org, "org", dfc.DefaultOrg, "the organization for cgr.dev/<org>/<image> (defaults to ORG)")
-	cmd.Flags().StringVar(&registry, "registry", "", "an alternate registry and root namepace (e.g. r.example.com/cg-mirror)")
+	lockCmd := &cobra.Command{
+		Use:     "lock",
+		Short:   "Resolve the images of the converted Dockerfile to digests and write them to a lockfile",
+		Example: "dfc lock <path_to_dockerfile>",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, log := setup(cmd)
+
+			path, raw, err := readInput(cmd, args[0])
+			if err != nil {
+				return err
+			}
+
+			convertedDockerfile, err := convert(ctx, log, raw)
+			if err != nil {
+				return err
+			}
+
+			resolver, err := digestResolver(digestsPath)
+			if err != nil {
+				return err
+			}
+
+			// By default the lockfile is next to the Dockerfile
+			if lockfile == "" {
+				lockfile = dfc.LockfileName
+				if path != "" {
+					lockfile = filepath.Join(filepath.Dir(path), dfc.LockfileName)
+				}
+			}
+
+			var lock *dfc.Lockfile
+			if check {
+				// Verify the existing lockfile
+				if lock, err = dfc.ReadLockfile(lockfile); err != nil {
+					return err
+				}
+				if err := dfc.CheckLockfile(ctx, convertedDockerfile, lock, resolver); err != nil {
+					return fmt.Errorf("%s is out of date, run dfc lock to update it: %w", lockfile, err)
+				}
+				log.Info("Lockfile is up to date", "path", lockfile)
+			} else {
+				if lock, err = dfc.GenerateLockfile(ctx, convertedDockerfile, resolver); err != nil {
+					return err
+				}
+				if err := dfc.WriteLockfile(lockfile, lock); err != nil {
+					return err
+				}
+				log.Info("Wrote lockfile", "path", lockfile, "images", len(lock.Images))
+			}
+
+			// Print the converted Dockerfile with the images pinned to their digests
+			if pin {
+				convertedDockerfile.PinDigests(lock)
+				fmt.Print(convertedDockerfile.String())
+			}
+
+			return nil
+		},
+	}
+	lockCmd.Flags().StringVar(&lockfile, "lockfile", "", "path to the lockfile (defaults to dfc.lock next to the Dockerfile)")
+	lockCmd.Flags().StringVar(&digestsPath, "digests", "", "resolve digests offline from a JSON file of image to digest, or from an OCI layout directory")
+	lockCmd.Flags().BoolVar(&pin, "pin", false, "print the converted Dockerfile with images pinned to their digests (image:tag@sha256:...)")
+	lockCmd.Flags().BoolVar(&check, "check", false, "verify that the lockfile covers the converted Dockerfile and is up to date instead of writing it")
+	cmd.AddCommand(lockCmd)
+
+	bumpCmd := &cobra.Command{
+		Use:     "bump",
+		Short:   "Move the Chainguard images of a converted Dockerfile to newer tags, rewriting it in place",
+		Example: "dfc bump --tag-catalog=tags.yaml <path_to_dockerfile>",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, log := setup(cmd)
+
+			path, raw, err := readInput(cmd, args[0])
+			if err != nil {
+				return err
+			}
+
+			opts := dfc.BumpOptions{
+				Policy:     dfc.BumpPolicy(bumpPolicy),
+				Registries: fromRegistries,
+			}
+			switch opts.Policy {
+			case dfc.BumpPolicyPatch, dfc.BumpPolicyMinor, dfc.BumpPolicyMajor:
+			default:
+				return fmt.Errorf("unknown bump policy %q, use %s, %s or %s", bumpPolicy, dfc.BumpPolicyPatch, dfc.BumpPolicyMinor, dfc.BumpPolicyMajor)
+			}
+
+			// Without a tag catalog file, list the tags of the repository of each image
+			if tagCatalogFile != "" {
+				catalog, err := dfc.NewFileTagCatalog(tagCatalogFile)
+				if err != nil {
+					return err
+				}
+				opts.Catalog = catalog
+			} else {
+				opts.Catalog = &dfc.RegistryTagCatalog{UserAgent: fmt.Sprintf("dfc/%s", dfc.Version())}
+			}
+			if registry != "" {
+				opts.Registries = append(opts.Registries, registry)
+			}
+
+			result, bumped, err := dfc.Bump(ctx, raw, opts)
+			if err != nil {
+				return fmt.Errorf("bumping dockerfile: %w", err)
+			}
+			for _, b := range bumped {
+				log.Info("Bumped image", "line", b.Line, "from", b.From, "to", b.To)
+			}
+
+			// Print to stdout when reading stdin
+			if path == "" {
+				fmt.Print(string(result))
+				return nil
+			}
+			if len(bumped) == 0 {
+				return nil
+			}
+			fileInfo, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("getting file info for %s: %w", path, err)
+			}
+			if err := os.WriteFile(path, result, fileInfo.Mode().Perm()); err != nil {
+				return fmt.Errorf("overwriting %s: %w", path, err)
+			}
+			return nil
+		},
+	}
+	bumpCmd.Flags().StringVar(&bumpPolicy, "policy", string(dfc.BumpPolicyMinor), "how far tags may move: patch (3.12.1 to 3.12.4), minor (3.11 to 3.12) or major (20 to 22)")
+	cmd.AddCommand(bumpCmd)
+
+	// Flags shared by all commands
+	cmd.PersistentFlags().StringVar(&org, "org", dfc.DefaultOrg, "the organization for cgr.dev/<org>/<image> (defaults to ORG)")
+	cmd.PersistentFlags().StringVar(&registry, "registry", "", "an alternate registry and root namepace (e.g. r.example.com/cg-mirror)")
+	cmd.PersistentFlags().StringVarP(&mappingsFile, "mappings", "m", "", "path to a custom package mappings YAML file (instead of the default)")
+	cmd.PersistentFlags().Var(&level, "log-level", "log level (e.g. debug, info, warn, error)")
+
+	// Flags that choose the converted images, used by the conversion and by lock
+	imageFlags := func(c *cobra.Command) {
+		c.Flags().BoolVar(&noBuiltInFlag, "no-builtin", false, "skip built-in package/image mappings, still apply default conversion logic")
+		c.Flags().StringVar(&tagPolicy, "tag-policy", string(dfc.TagPolicyNextNewer), "version used when a converted tag is not in the tag catalog: next-newer or latest")
+		c.Flags().StringArrayVar(&mirrors, "mirror", nil, "a registry mirror or proxy prefix to strip before matching images, may contain wildcards (e.g. artifactory.example.com/dockerhub-*), repeatable")
+		c.Flags().BoolVar(&fipsFlag, "fips", false, "convert to FIPS images and packages, reporting those without a FIPS equivalent")
+		c.Flags().BoolVar(&splitRuntimeFlag, "split-runtime", false, "split a final stage that needs a -dev image into a -dev builder stage and a minimal runtime stage")
+	}
+	imageFlags(cmd)
+	imageFlags(lockCmd)
+
+	// Flags of the conversion, lock and bump
+	for _, c := range []*cobra.Command{cmd, lockCmd, bumpCmd} {
+		c.Flags().StringVar(&tagCatalogFile, "tag-catalog", "", "path to a YAML or JSON file listing the published tags of each image, converted tags that are not published are replaced")
+		c.Flags().StringArrayVar(&fromRegistries, "from-registry", nil, "the registry and root namespace of previously converted images to move to --registry or --org (images under cgr.dev always are), repeatable")
+	}
+
+	// Flags of the conversion only
 	cmd.Flags().BoolVarP(&inPlace, "in-place", "i", false, "modified the Dockerfile in place (vs. stdout), saving original in a .bak file")
 	cmd.Flags().BoolVarP(&j, "json", "j", false, "print dockerfile as json (before conversion)")
-	cmd.Flags().StringVarP(&mappingsFile, "mappings", "m", "", "path to a custom package mappings YAML file (instead of the default)")
 	cmd.Flags().BoolVar(&updateFlag, "update", false, "check for and apply available updates")
-	cmd.Flags().BoolVar(&noBuiltInFlag, "no-builtin", false, "skip built-in package/image mappings, still apply default conversion logic")
-	cmd.Flags().Var(&level, "log-level", "log level (e.g. debug, info, warn, error)")
 	cmd.Flags().BoolVar(&strictFlag, "strict", false, "when true, fail if any package is unknown")
 	cmd.Flags().BoolVar(&warnMissingPackagesFlag, "warn-missing-packages", false, "when true, warn about missing package mappings")
+	cmd.Flags().BoolVar(&fixNonrootFlag, "fix-nonroot", false, "fix the non-root issues of the final stage where it is safe: add --chown to COPY and ADD into the WORKDIR or a VOLUME")
 
 	return cmd
 }
+
+// readInput reads the Dockerfile at path, or stdin if path is "-". The returned path is empty for stdin.
+func readInput(cmd *cobra.Command, path string) (string, []byte, error) {
+	// Allow for piping into the CLI if first arg is "-"
+	input := cmd.InOrStdin()
+	if path != "-" {
+		file, err := os.Open(filepath.Clean(path))
+		if err != nil {
+			return "", nil, fmt.Errorf("failed open file: %s: %w", path, err)
+		}
+		defer file.Close()
+		input = file
+	} else {
+		path = ""
+	}
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(input); err != nil {
+		return "", nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	return path, buf.Bytes(), nil
+}
+
+// digestResolver returns the resolver for image digests: the registries, or the given JSON
+// file or OCI layout directory for offline use
+func digestResolver(path string) (dfc.DigestResolver, error) {
+	if path == "" {
+		return &dfc.RegistryDigestResolver{UserAgent: fmt.Sprintf("dfc/%s", dfc.Version())}, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading digests: %w", err)
+	}
+	if info.IsDir() {
+		return dfc.NewOCILayoutDigestResolver(path)
+	}
+	return dfc.NewDigestFileResolver(path)
+}
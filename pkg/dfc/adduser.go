@@ -9,10 +9,37 @@ import (
 	"strings"
 )
 
-// ConvertUserAddToAddUser converts a useradd command to the equivalent adduser command
+// ConvertUserAddToAddUser converts a useradd command to the equivalent adduser command.
+// Supplementary groups (-G) cannot be expressed with adduser and are dropped,
+// use ConvertUserAddToBusybox to also add the user to them.
 func ConvertUserAddToAddUser(part *ShellPart) *ShellPart {
+	result, _, _ := convertUserAdd(part)
+	return result
+}
+
+// ConvertUserAddToBusybox converts a useradd command to the equivalent adduser command,
+// followed by an "addgroup <user> <group>" command for each supplementary group (-G)
+func ConvertUserAddToBusybox(part *ShellPart) []*ShellPart {
+	result, username, groups := convertUserAdd(part)
+	if len(groups) == 0 || username == "" {
+		return []*ShellPart{result}
+	}
+
+	parts := []*ShellPart{result}
+	for _, group := range groups {
+		parts = append(parts, &ShellPart{
+			Command: CommandAddGroup,
+			Args:    []string{username, group},
+		})
+	}
+	return chainShellParts(part, parts)
+}
+
+// convertUserAdd converts a useradd command to adduser and returns the user name
+// and supplementary groups it found along the way
+func convertUserAdd(part *ShellPart) (*ShellPart, string, []string) {
 	if part.Command != CommandUserAdd {
-		return part
+		return part, "", nil
 	}
 
 	// Create a new shell part with the same extra content and delimiter
@@ -26,6 +53,7 @@ func ConvertUserAddToAddUser(part *ShellPart) *ShellPart {
 	var resultArgs []string
 	var username string
 	var hasUsername bool
+	var groups []string
 	i := 0
 
 	// Process arguments
@@ -40,8 +68,23 @@ func ConvertUserAddToAddUser(part *ShellPart) *ShellPart {
 			continue
 		}
 
+		// Supplementary groups are added separately with addgroup
+		if value, ok := strings.CutPrefix(arg, "--groups="); ok {
+			groups = append(groups, splitGroupList(value)...)
+			i++
+			continue
+		}
+
 		// Process options
 		switch arg {
+		case "-G", "--groups":
+			if i+1 < len(part.Args) {
+				groups = append(groups, splitGroupList(part.Args[i+1])...)
+				i += 2
+			} else {
+				i++
+			}
+
 		// Options that are simply removed (create home is default in adduser)
 		case "-m", "--create-home":
 			i++
@@ -127,7 +170,7 @@ func ConvertUserAddToAddUser(part *ShellPart) *ShellPart {
 	}
 
 	result.Args = resultArgs
-	return result
+	return result, username, groups
 }
 
 // ConvertGroupAddToAddGroup converts a groupadd command to the equivalent addgroup command
@@ -205,3 +248,330 @@ func ConvertGroupAddToAddGroup(part *ShellPart) *ShellPart {
 	result.Args = resultArgs
 	return result
 }
+
+// ConvertUserModToBusybox converts a usermod command to busybox equivalents:
+// supplementary groups (-G, with or without -a) become "addgroup <user> <group>" commands,
+// a login shell change (-s) edits /etc/passwd with sed and -L/-U become passwd -l/-u.
+// Since addgroup can only add memberships, -G without -a does not remove the user from
+// other groups. Commands using any other option are returned unchanged.
+func ConvertUserModToBusybox(part *ShellPart) []*ShellPart {
+	if part.Command != CommandUserMod {
+		return []*ShellPart{part}
+	}
+
+	var username, shell, lock string
+	var groups []string
+	args := expandShortOptions(part.Args, "Gs")
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch name {
+		case "-a", "--append":
+			// addgroup always appends
+		case "-G", "--groups", "-s", "--shell":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return []*ShellPart{part}
+				}
+				value = args[i+1]
+				i++
+			}
+			if name == "-s" || name == "--shell" {
+				shell = value
+			} else {
+				groups = append(groups, splitGroupList(value)...)
+			}
+		case "-L", "--lock":
+			lock = "-l"
+		case "-U", "--unlock":
+			lock = "-u"
+		default:
+			if strings.HasPrefix(arg, "-") || username != "" {
+				// Not supported by busybox, leave the command as is
+				return []*ShellPart{part}
+			}
+			username = arg
+		}
+	}
+
+	if username == "" || (len(groups) == 0 && shell == "" && lock == "") {
+		return []*ShellPart{part}
+	}
+
+	var parts []*ShellPart
+	for _, group := range groups {
+		parts = append(parts, &ShellPart{
+			Command: CommandAddGroup,
+			Args:    []string{username, group},
+		})
+	}
+	if shell != "" {
+		shellPart := changeShellPart(username, shell)
+		if shellPart == nil {
+			return []*ShellPart{part}
+		}
+		parts = append(parts, shellPart)
+	}
+	if lock != "" {
+		parts = append(parts, &ShellPart{
+			Command: CommandPasswd,
+			Args:    []string{lock, username},
+		})
+	}
+
+	return chainShellParts(part, parts)
+}
+
+// ConvertGPasswdToBusybox converts gpasswd group membership changes to busybox equivalents:
+// -a becomes "addgroup <user> <group>", -d becomes "delgroup <user> <group>" and -M adds
+// each listed member with addgroup. Commands using any other option are returned unchanged.
+func ConvertGPasswdToBusybox(part *ShellPart) []*ShellPart {
+	if part.Command != CommandGPasswd {
+		return []*ShellPart{part}
+	}
+
+	var group string
+	var add, remove []string
+	args := expandShortOptions(part.Args, "adM")
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch name {
+		case "-a", "--add", "-d", "--delete", "-M", "--members":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return []*ShellPart{part}
+				}
+				value = args[i+1]
+				i++
+			}
+			switch name {
+			case "-a", "--add":
+				add = append(add, value)
+			case "-d", "--delete":
+				remove = append(remove, value)
+			default:
+				add = append(add, splitGroupList(value)...)
+			}
+		default:
+			if strings.HasPrefix(arg, "-") || group != "" {
+				// Not supported by busybox, leave the command as is
+				return []*ShellPart{part}
+			}
+			group = arg
+		}
+	}
+
+	if group == "" || len(add)+len(remove) == 0 {
+		return []*ShellPart{part}
+	}
+
+	var parts []*ShellPart
+	for _, user := range add {
+		parts = append(parts, &ShellPart{
+			Command: CommandAddGroup,
+			Args:    []string{user, group},
+		})
+	}
+	for _, user := range remove {
+		parts = append(parts, &ShellPart{
+			Command: CommandDelGroup,
+			Args:    []string{user, group},
+		})
+	}
+
+	return chainShellParts(part, parts)
+}
+
+// ConvertChPasswdToBusybox converts the long options of a chpasswd command to the short
+// options understood by busybox chpasswd. Options without a busybox equivalent that only
+// tune the hashing (--sha-rounds) are dropped, anything else leaves the command unchanged.
+func ConvertChPasswdToBusybox(part *ShellPart) *ShellPart {
+	if part.Command != CommandChPasswd {
+		return part
+	}
+
+	var resultArgs []string
+	args := expandShortOptions(part.Args, "cs")
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch name {
+		case "-e", "--encrypted":
+			resultArgs = append(resultArgs, "-e")
+		case "-m", "--md5":
+			resultArgs = append(resultArgs, "-m")
+		case "-c", "--crypt-method", "-s", "--sha-rounds":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return part
+				}
+				value = args[i+1]
+				i++
+			}
+			if name == "-c" || name == "--crypt-method" {
+				resultArgs = append(resultArgs, "-c", strings.ToLower(value))
+			}
+		default:
+			// Not supported by busybox, leave the command as is
+			return part
+		}
+	}
+
+	return &ShellPart{
+		ExtraPre:  part.ExtraPre,
+		Command:   CommandChPasswd,
+		Args:      resultArgs,
+		Delimiter: part.Delimiter,
+	}
+}
+
+// ConvertPasswdToBusybox converts the long options of a passwd command (--delete, --lock,
+// --unlock) to the short options understood by busybox passwd. Commands using options
+// busybox does not support (password aging, --stdin, ...) are returned unchanged.
+func ConvertPasswdToBusybox(part *ShellPart) *ShellPart {
+	if part.Command != CommandPasswd {
+		return part
+	}
+
+	var resultArgs []string
+	for _, arg := range expandShortOptions(part.Args, "") {
+		switch arg {
+		case "-d", "--delete":
+			resultArgs = append(resultArgs, "-d")
+		case "-l", "--lock":
+			resultArgs = append(resultArgs, "-l")
+		case "-u", "--unlock":
+			resultArgs = append(resultArgs, "-u")
+		default:
+			if strings.HasPrefix(arg, "-") {
+				// Not supported by busybox, leave the command as is
+				return part
+			}
+			resultArgs = append(resultArgs, arg)
+		}
+	}
+
+	return &ShellPart{
+		ExtraPre:  part.ExtraPre,
+		Command:   CommandPasswd,
+		Args:      resultArgs,
+		Delimiter: part.Delimiter,
+	}
+}
+
+// ConvertChshToBusybox converts "chsh -s <shell> <user>", which busybox does not provide,
+// into a sed command that changes the login shell of the user in /etc/passwd
+func ConvertChshToBusybox(part *ShellPart) *ShellPart {
+	if part.Command != CommandChsh {
+		return part
+	}
+
+	var username, shell string
+	args := expandShortOptions(part.Args, "s")
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch name {
+		case "-s", "--shell":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return part
+				}
+				value = args[i+1]
+				i++
+			}
+			shell = value
+		default:
+			if strings.HasPrefix(arg, "-") || username != "" {
+				// Not supported, leave the command as is
+				return part
+			}
+			username = arg
+		}
+	}
+
+	if username == "" || shell == "" {
+		return part
+	}
+
+	result := changeShellPart(username, shell)
+	if result == nil {
+		return part
+	}
+	result.ExtraPre = part.ExtraPre
+	result.Delimiter = part.Delimiter
+	return result
+}
+
+// changeShellPart returns a sed command that sets the login shell of a user in /etc/passwd,
+// or nil if the user or shell cannot be safely embedded in the sed expression
+func changeShellPart(username, shell string) *ShellPart {
+	username = unquote(username)
+	shell = unquote(shell)
+	for _, value := range []string{username, shell} {
+		if value == "" || strings.ContainsAny(value, "\"'|\\`") {
+			return nil
+		}
+	}
+
+	return &ShellPart{
+		Command: "sed",
+		Args:    []string{"-i", `"s|^\(` + username + `:.*:\)[^:]*$|\1` + shell + `|"`, "/etc/passwd"},
+	}
+}
+
+// unquote removes matching single or double quotes surrounding a shell word
+func unquote(word string) string {
+	if len(word) >= 2 && (word[0] == '"' || word[0] == '\'') && word[len(word)-1] == word[0] {
+		return word[1 : len(word)-1]
+	}
+	return word
+}
+
+// splitGroupList splits a comma separated list of groups or users
+func splitGroupList(list string) []string {
+	var groups []string
+	for _, group := range strings.Split(unquote(list), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// expandShortOptions splits bundled short options ("-aG" -> "-a", "-G"). Options listed in
+// withValue take a value, which may be attached to the option ("-Gdocker" -> "-G", "docker").
+func expandShortOptions(args []string, withValue string) []string {
+	var expanded []string
+	for _, arg := range args {
+		if len(arg) <= 2 || arg[0] != '-' || arg[1] == '-' {
+			expanded = append(expanded, arg)
+			continue
+		}
+		for j := 1; j < len(arg); j++ {
+			expanded = append(expanded, "-"+string(arg[j]))
+			if strings.IndexByte(withValue, arg[j]) != -1 {
+				if j+1 < len(arg) {
+					expanded = append(expanded, arg[j+1:])
+				}
+				break
+			}
+		}
+	}
+	return expanded
+}
+
+// chainShellParts joins the parts that replace the original shell part with "&&",
+// keeping the environment prefix and delimiter of the original
+func chainShellParts(original *ShellPart, parts []*ShellPart) []*ShellPart {
+	if len(parts) == 0 {
+		return []*ShellPart{original}
+	}
+	parts[0].ExtraPre = original.ExtraPre
+	for _, part := range parts[:len(parts)-1] {
+		part.Delimiter = "&&"
+	}
+	parts[len(parts)-1].Delimiter = original.Delimiter
+	return parts
+}
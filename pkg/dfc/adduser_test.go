@@ -6,7 +6,11 @@ SPDX-License-Identifier: Apache-2.0
 package dfc
 
 import (
+	"context"
+	"strings"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestConvertUserAddToAddUser(t *testing.T) {
@@ -468,3 +472,260 @@ func TestConvertGroupAddToAddGroup(t *testing.T) {
 		})
 	}
 }
+
+func TestConvertUserManagementToBusybox(t *testing.T) {
+	single := func(convert CommandConverter) MultiCommandConverter {
+		return func(part *ShellPart) []*ShellPart {
+			return []*ShellPart{convert(part)}
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		convert  MultiCommandConverter
+		input    *ShellPart
+		expected []*ShellPart
+	}{
+		{
+			name:    "useradd with supplementary groups",
+			convert: ConvertUserAddToBusybox,
+			input: &ShellPart{
+				ExtraPre:  "FOO=bar",
+				Command:   CommandUserAdd,
+				Args:      []string{"-m", "-G", "sudo,docker", "app"},
+				Delimiter: "&&",
+			},
+			expected: []*ShellPart{
+				{ExtraPre: "FOO=bar", Command: CommandAddUser, Args: []string{"app"}, Delimiter: "&&"},
+				{Command: CommandAddGroup, Args: []string{"app", "sudo"}, Delimiter: "&&"},
+				{Command: CommandAddGroup, Args: []string{"app", "docker"}, Delimiter: "&&"},
+			},
+		},
+		{
+			name:    "useradd with --groups=",
+			convert: ConvertUserAddToBusybox,
+			input: &ShellPart{
+				Command: CommandUserAdd,
+				Args:    []string{"--groups=wheel", "-u", "1001", "app"},
+			},
+			expected: []*ShellPart{
+				{Command: CommandAddUser, Args: []string{"--uid", "1001", "app"}, Delimiter: "&&"},
+				{Command: CommandAddGroup, Args: []string{"app", "wheel"}},
+			},
+		},
+		{
+			name:    "useradd without supplementary groups",
+			convert: ConvertUserAddToBusybox,
+			input: &ShellPart{
+				Command: CommandUserAdd,
+				Args:    []string{"-r", "app"},
+			},
+			expected: []*ShellPart{
+				{Command: CommandAddUser, Args: []string{"--system", "app"}},
+			},
+		},
+		{
+			name:    "usermod append groups bundled",
+			convert: ConvertUserModToBusybox,
+			input: &ShellPart{
+				Command: CommandUserMod,
+				Args:    []string{"-aG", "docker,wheel", "app"},
+			},
+			expected: []*ShellPart{
+				{Command: CommandAddGroup, Args: []string{"app", "docker"}, Delimiter: "&&"},
+				{Command: CommandAddGroup, Args: []string{"app", "wheel"}},
+			},
+		},
+		{
+			name:    "usermod long options with shell and lock",
+			convert: ConvertUserModToBusybox,
+			input: &ShellPart{
+				Command:   CommandUserMod,
+				Args:      []string{"--append", "--groups=audio", "--shell", "/bin/zsh", "-L", "app"},
+				Delimiter: ";",
+			},
+			expected: []*ShellPart{
+				{Command: CommandAddGroup, Args: []string{"app", "audio"}, Delimiter: "&&"},
+				{Command: "sed", Args: []string{"-i", `"s|^\(app:.*:\)[^:]*$|\1/bin/zsh|"`, "/etc/passwd"}, Delimiter: "&&"},
+				{Command: CommandPasswd, Args: []string{"-l", "app"}, Delimiter: ";"},
+			},
+		},
+		{
+			name:    "usermod with unsupported option is unchanged",
+			convert: ConvertUserModToBusybox,
+			input: &ShellPart{
+				Command: CommandUserMod,
+				Args:    []string{"-u", "2000", "app"},
+			},
+			expected: []*ShellPart{
+				{Command: CommandUserMod, Args: []string{"-u", "2000", "app"}},
+			},
+		},
+		{
+			name:    "gpasswd add and members",
+			convert: ConvertGPasswdToBusybox,
+			input: &ShellPart{
+				Command: CommandGPasswd,
+				Args:    []string{"-a", "app", "docker"},
+			},
+			expected: []*ShellPart{
+				{Command: CommandAddGroup, Args: []string{"app", "docker"}},
+			},
+		},
+		{
+			name:    "gpasswd delete",
+			convert: ConvertGPasswdToBusybox,
+			input: &ShellPart{
+				Command: CommandGPasswd,
+				Args:    []string{"--delete", "app", "sudo"},
+			},
+			expected: []*ShellPart{
+				{Command: CommandDelGroup, Args: []string{"app", "sudo"}},
+			},
+		},
+		{
+			name:    "gpasswd members list",
+			convert: ConvertGPasswdToBusybox,
+			input: &ShellPart{
+				Command: CommandGPasswd,
+				Args:    []string{"-M", "alice,bob", "devs"},
+			},
+			expected: []*ShellPart{
+				{Command: CommandAddGroup, Args: []string{"alice", "devs"}, Delimiter: "&&"},
+				{Command: CommandAddGroup, Args: []string{"bob", "devs"}},
+			},
+		},
+		{
+			name:    "gpasswd set administrators is unchanged",
+			convert: ConvertGPasswdToBusybox,
+			input: &ShellPart{
+				Command: CommandGPasswd,
+				Args:    []string{"-A", "app", "devs"},
+			},
+			expected: []*ShellPart{
+				{Command: CommandGPasswd, Args: []string{"-A", "app", "devs"}},
+			},
+		},
+		{
+			name:    "chpasswd long options",
+			convert: single(ConvertChPasswdToBusybox),
+			input: &ShellPart{
+				Command: CommandChPasswd,
+				Args:    []string{"--crypt-method", "SHA512", "--sha-rounds=5000"},
+			},
+			expected: []*ShellPart{
+				{Command: CommandChPasswd, Args: []string{"-c", "sha512"}},
+			},
+		},
+		{
+			name:    "chpasswd with root directory is unchanged",
+			convert: single(ConvertChPasswdToBusybox),
+			input: &ShellPart{
+				Command: CommandChPasswd,
+				Args:    []string{"-R", "/mnt"},
+			},
+			expected: []*ShellPart{
+				{Command: CommandChPasswd, Args: []string{"-R", "/mnt"}},
+			},
+		},
+		{
+			name:    "passwd delete long option",
+			convert: single(ConvertPasswdToBusybox),
+			input: &ShellPart{
+				Command: CommandPasswd,
+				Args:    []string{"--delete", "app"},
+			},
+			expected: []*ShellPart{
+				{Command: CommandPasswd, Args: []string{"-d", "app"}},
+			},
+		},
+		{
+			name:    "passwd expire is unchanged",
+			convert: single(ConvertPasswdToBusybox),
+			input: &ShellPart{
+				Command: CommandPasswd,
+				Args:    []string{"-e", "app"},
+			},
+			expected: []*ShellPart{
+				{Command: CommandPasswd, Args: []string{"-e", "app"}},
+			},
+		},
+		{
+			name:    "chsh",
+			convert: single(ConvertChshToBusybox),
+			input: &ShellPart{
+				Command:   CommandChsh,
+				Args:      []string{"-s", "/bin/bash", "${USERNAME}"},
+				Delimiter: "&&",
+			},
+			expected: []*ShellPart{
+				{Command: "sed", Args: []string{"-i", `"s|^\(${USERNAME}:.*:\)[^:]*$|\1/bin/bash|"`, "/etc/passwd"}, Delimiter: "&&"},
+			},
+		},
+		{
+			name:    "chsh without user is unchanged",
+			convert: single(ConvertChshToBusybox),
+			input: &ShellPart{
+				Command: CommandChsh,
+				Args:    []string{"--shell=/bin/bash"},
+			},
+			expected: []*ShellPart{
+				{Command: CommandChsh, Args: []string{"--shell=/bin/bash"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := tc.convert(tc.input)
+			if diff := cmp.Diff(tc.expected, result); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUserManagementConversion(t *testing.T) {
+	testCases := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{
+			name: "usermod and useradd groups",
+			raw:  `RUN useradd -m -G sudo app && usermod -aG docker app`,
+			expected: `RUN adduser app && \
+    addgroup app sudo && \
+    addgroup app docker`,
+		},
+		{
+			name:     "chpasswd in a pipeline",
+			raw:      `RUN echo "app:secret" | chpasswd --md5`,
+			expected: `RUN echo "app:secret" | chpasswd -m`,
+		},
+		{
+			name: "left alone when shadow is installed",
+			raw:  `RUN apt-get install -y shadow && usermod -aG docker app && chsh -s /bin/bash app`,
+			expected: `RUN apk add --no-cache shadow && \
+    usermod -aG docker app && \
+    chsh -s /bin/bash app`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+)
+
+// BumpPolicy limits how far "dfc bump" moves the version of a tag
+type BumpPolicy string
+
+// Supported bump policies
+const (
+	BumpPolicyPatch BumpPolicy = "patch" // Only move 3.12.1 to 3.12.4
+	BumpPolicyMinor BumpPolicy = "minor" // Also move 3.11 to 3.12 (default)
+	BumpPolicyMajor BumpPolicy = "major" // Also move 20 to 22
+)
+
+// BumpOptions configures Bump
+type BumpOptions struct {
+	Catalog    TagCatalog // Tags published for each Chainguard image, listed by the repository of the reference
+	Policy     BumpPolicy // How far versions may move, BumpPolicyMinor by default
+	Registries []string   // Registries and root namespaces of Chainguard images besides cgr.dev
+}
+
+// BumpedImage is an image reference moved to a newer tag by Bump
+type BumpedImage struct {
+	Line int    `json:"line"` // 1-based line number in the Dockerfile
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// bumpTagRegexp splits a tag into the text before its version, the version and the text after
+// it, e.g. "openjdk-", "17" and "-dev" for "openjdk-17-dev"
+var bumpTagRegexp = regexp.MustCompile(`^(.*?)(\d+(?:\.\d+)*)(.*)$`)
+
+// Bump moves the Chainguard images referenced by the FROM lines, ARG defaults and COPY --from
+// lines of a converted Dockerfile to the newest tag of the catalog that the policy allows. Only the
+// references change, the rest of the content is kept byte for byte. A tag keeps its form, so
+// 3.11-dev only moves to a published -dev tag with a version of the same depth, such as 3.12-dev.
+// References pinned to a digest or using ARGs are left as is.
+func Bump(ctx context.Context, content []byte, opts BumpOptions) ([]byte, []BumpedImage, error) {
+	parsed, err := ParseDockerfile(ctx, content)
+	if err != nil {
+		return nil, nil, err
+	}
+	policy := opts.Policy
+	if policy == "" {
+		policy = BumpPolicyMinor
+	}
+
+	text := string(content)
+	var result strings.Builder
+	var bumped []BumpedImage
+	written, offset := 0, 0
+	for _, line := range parsed.Lines {
+		// Skip the comments before the line, which may mention the same image
+		if strings.HasPrefix(text[offset:], line.Extra) {
+			offset += len(line.Extra)
+		}
+		start := strings.Index(text[offset:], line.Raw)
+		if start < 0 {
+			continue
+		}
+		start += offset
+		offset = start + len(line.Raw)
+
+		ref := bumpableImage(line)
+		if ref == "" {
+			continue
+		}
+		to, err := bumpImage(ctx, ref, opts.Registries, opts.Catalog, policy)
+		if err != nil {
+			return nil, nil, err
+		}
+		if to == "" {
+			continue
+		}
+
+		result.WriteString(text[written:start])
+		result.WriteString(strings.Replace(line.Raw, ref, to, 1))
+		written = offset
+		bumped = append(bumped, BumpedImage{
+			Line: strings.Count(text[:start+strings.Index(line.Raw, ref)], "\n") + 1,
+			From: ref,
+			To:   to,
+		})
+	}
+	result.WriteString(text[written:])
+	return []byte(result.String()), bumped, nil
+}
+
+// bumpableImage returns the image reference of a FROM line, ARG default or COPY --from line that
+// Bump may change, or an empty string
+func bumpableImage(line *DockerfileLine) string {
+	var ref string
+	switch {
+	case line.From != nil && line.From.Parent == 0:
+		ref = line.From.Orig
+	case line.Arg != nil:
+		ref = line.Arg.DefaultValue
+	case line.Copy != nil:
+		ref = line.Copy.From
+	}
+	if strings.Contains(ref, "$") || strings.Contains(ref, "@") {
+		return ""
+	}
+	return ref
+}
+
+// bumpImage returns the reference with the newest tag allowed by the policy, or an empty string if
+// the image is not a Chainguard image or its tag is current
+func bumpImage(ctx context.Context, ref string, registries []string, catalog TagCatalog, policy BumpPolicy) (string, error) {
+	base, tag, _ := splitImageReference(ref)
+	name, ok := chainguardImageName(base, registries)
+	if !ok || tag == "" {
+		return "", nil
+	}
+
+	// The tags are listed from the repository of the reference, so images of another organization
+	// or registry are compared to their own tags
+	tags, err := catalog.ListTags(ctx, base)
+	if err != nil {
+		return "", fmt.Errorf("bumping %s: %w", ref, err)
+	}
+	if tags == nil {
+		clog.FromContext(ctx).Warn("No tags found for image, leaving it as is", "image", name, "repository", base)
+		return "", nil
+	}
+	newer, ok := newestAllowedTag(tag, tags, policy)
+	if !ok {
+		return "", nil
+	}
+	return base + ":" + newer, nil
+}
+
+// newestAllowedTag returns the tag of the newest version above the version of tag that the policy
+// allows, among the tags with the same form, e.g. 3.12-dev for 3.11-dev with the minor policy
+func newestAllowedTag(tag string, tags []string, policy BumpPolicy) (string, bool) {
+	m := bumpTagRegexp.FindStringSubmatch(tag)
+	if m == nil {
+		return "", false
+	}
+	prefix, suffix := m[1], m[3]
+	current := versionComponents(m[2])
+
+	// Components of the version that must stay the same
+	fixed := 0
+	switch policy {
+	case BumpPolicyPatch:
+		fixed = 2
+	case BumpPolicyMinor:
+		fixed = 1
+	}
+	fixed = min(fixed, len(current))
+
+	best, bestVersion := "", current
+	for _, candidate := range tags {
+		version, ok := strings.CutPrefix(candidate, prefix)
+		if !ok {
+			continue
+		}
+		if version, ok = strings.CutSuffix(version, suffix); !ok {
+			continue
+		}
+		components := versionComponents(version)
+		if len(components) != len(current) || !slices.Equal(components[:fixed], current[:fixed]) {
+			continue
+		}
+		if slices.Compare(components, bestVersion) > 0 {
+			best, bestVersion = candidate, components
+		}
+	}
+	return best, best != ""
+}
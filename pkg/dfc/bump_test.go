@@ -0,0 +1,167 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBump(t *testing.T) {
+	catalog := &FileTagCatalog{Tags: map[string][]string{
+		"python": {"3.11", "3.11-dev", "3.12", "3.12-dev", "3.13", "3.13-dev", "3.12.4", "3.12.7", "latest", "latest-dev"},
+		"node":   {"20", "20-dev", "22", "22-dev", "23"},
+		"jdk":    {"openjdk-17", "openjdk-21", "openjdk-21-dev"},
+	}}
+
+	testCases := []struct {
+		name     string
+		raw      string
+		policy   BumpPolicy
+		expected string
+		bumped   []BumpedImage
+	}{
+		{
+			name: "minor policy",
+			raw: `# syntax=docker/dockerfile:1
+ARG BASE=cgr.dev/acme/python:3.11-dev
+FROM ${BASE} AS build
+RUN pip install -r requirements.txt
+
+# Runtime image cgr.dev/acme/python:3.11
+FROM  cgr.dev/acme/python:3.11
+COPY --from=build /app /app
+COPY --from=cgr.dev/acme/node:20 /usr/bin/node /usr/bin/node
+`,
+			expected: `# syntax=docker/dockerfile:1
+ARG BASE=cgr.dev/acme/python:3.13-dev
+FROM ${BASE} AS build
+RUN pip install -r requirements.txt
+
+# Runtime image cgr.dev/acme/python:3.11
+FROM  cgr.dev/acme/python:3.13
+COPY --from=build /app /app
+COPY --from=cgr.dev/acme/node:20 /usr/bin/node /usr/bin/node
+`,
+			bumped: []BumpedImage{
+				{Line: 2, From: "cgr.dev/acme/python:3.11-dev", To: "cgr.dev/acme/python:3.13-dev"},
+				{Line: 7, From: "cgr.dev/acme/python:3.11", To: "cgr.dev/acme/python:3.13"},
+			},
+		},
+		{
+			name: "major policy keeps -dev tags on -dev",
+			raw: "FROM cgr.dev/acme/node:20-dev AS build\r\n" +
+				"COPY --from=cgr.dev/acme/node:20 /usr/bin/node /usr/bin/node\r\n" +
+				"FROM cgr.dev/acme/jdk:openjdk-17",
+			policy: BumpPolicyMajor,
+			expected: "FROM cgr.dev/acme/node:22-dev AS build\r\n" +
+				"COPY --from=cgr.dev/acme/node:23 /usr/bin/node /usr/bin/node\r\n" +
+				"FROM cgr.dev/acme/jdk:openjdk-21",
+			bumped: []BumpedImage{
+				{Line: 1, From: "cgr.dev/acme/node:20-dev", To: "cgr.dev/acme/node:22-dev"},
+				{Line: 2, From: "cgr.dev/acme/node:20", To: "cgr.dev/acme/node:23"},
+				{Line: 3, From: "cgr.dev/acme/jdk:openjdk-17", To: "cgr.dev/acme/jdk:openjdk-21"},
+			},
+		},
+		{
+			name:     "patch policy",
+			raw:      "FROM cgr.dev/acme/python:3.12.4\nFROM cgr.dev/acme/python:3.12\n",
+			policy:   BumpPolicyPatch,
+			expected: "FROM cgr.dev/acme/python:3.12.7\nFROM cgr.dev/acme/python:3.12\n",
+			bumped: []BumpedImage{
+				{Line: 1, From: "cgr.dev/acme/python:3.12.4", To: "cgr.dev/acme/python:3.12.7"},
+			},
+		},
+		{
+			name: "references left as is",
+			raw: `FROM python:3.11
+FROM cgr.dev/acme/python:latest
+FROM cgr.dev/acme/python:3.11@sha256:abc
+FROM cgr.dev/acme/python:${VERSION}
+FROM cgr.dev/acme/go:1.22
+FROM cgr.dev/acme/python:3.13-dev`,
+			policy: BumpPolicyMajor,
+			expected: `FROM python:3.11
+FROM cgr.dev/acme/python:latest
+FROM cgr.dev/acme/python:3.11@sha256:abc
+FROM cgr.dev/acme/python:${VERSION}
+FROM cgr.dev/acme/go:1.22
+FROM cgr.dev/acme/python:3.13-dev`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, bumped, err := Bump(context.Background(), []byte(tc.raw), BumpOptions{Catalog: catalog, Policy: tc.policy})
+			if err != nil {
+				t.Fatalf("Bump() error = %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, string(result)); diff != "" {
+				t.Errorf("Bump() not as expected (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.bumped, bumped); diff != "" {
+				t.Errorf("bumped images not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBumpRegistries(t *testing.T) {
+	catalog := &FileTagCatalog{Tags: map[string][]string{"python": {"3.12"}}}
+	raw := "FROM r.example.com/cg/python:3.11\n"
+
+	result, _, err := Bump(context.Background(), []byte(raw), BumpOptions{Catalog: catalog})
+	if err != nil {
+		t.Fatalf("Bump() error = %v", err)
+	}
+	if diff := cmp.Diff(raw, string(result)); diff != "" {
+		t.Errorf("image of another registry was bumped (-want, +got):\n%s", diff)
+	}
+
+	result, _, err = Bump(context.Background(), []byte(raw), BumpOptions{Catalog: catalog, Registries: []string{"r.example.com/cg"}})
+	if err != nil {
+		t.Fatalf("Bump() error = %v", err)
+	}
+	if diff := cmp.Diff("FROM r.example.com/cg/python:3.12\n", string(result)); diff != "" {
+		t.Errorf("Bump() not as expected (-want, +got):\n%s", diff)
+	}
+}
+
+func TestBumpRepositoryTags(t *testing.T) {
+	catalog := &FileTagCatalog{Tags: map[string][]string{
+		"cgr.dev/acme/python":  {"3.11", "3.12"},
+		"cgr.dev/other/python": {"3.11", "3.13"},
+	}}
+	raw := "FROM cgr.dev/acme/python:3.11\nFROM cgr.dev/other/python:3.11\nFROM cgr.dev/acme/node:20\n"
+
+	result, _, err := Bump(context.Background(), []byte(raw), BumpOptions{Catalog: catalog})
+	if err != nil {
+		t.Fatalf("Bump() error = %v", err)
+	}
+	want := "FROM cgr.dev/acme/python:3.12\nFROM cgr.dev/other/python:3.13\nFROM cgr.dev/acme/node:20\n"
+	if diff := cmp.Diff(want, string(result)); diff != "" {
+		t.Errorf("Bump() not as expected (-want, +got):\n%s", diff)
+	}
+}
+
+type failingTagCatalog struct{}
+
+func (failingTagCatalog) ListTags(context.Context, string) ([]string, error) {
+	return nil, errors.New("registry unavailable")
+}
+
+func TestBumpCatalogError(t *testing.T) {
+	_, _, err := Bump(context.Background(), []byte("FROM cgr.dev/acme/python:3.11"), BumpOptions{Catalog: failingTagCatalog{}})
+	if err == nil {
+		t.Fatal("Bump() error = nil, want error")
+	}
+	if want := "bumping cgr.dev/acme/python:3.11: registry unavailable"; err.Error() != want {
+		t.Errorf("Bump() error = %q, want %q", err, want)
+	}
+}
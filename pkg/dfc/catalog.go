@@ -0,0 +1,191 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"gopkg.in/yaml.v3"
+)
+
+// TagPolicy selects the version used when a converted tag is not in the tag catalog
+type TagPolicy string
+
+// Supported tag policies
+const (
+	TagPolicyNextNewer TagPolicy = "next-newer" // The closest newer version, or the newest one if there is none (default)
+	TagPolicyLatest    TagPolicy = "latest"     // The newest version
+)
+
+// TagCatalog lists the tags published for Chainguard images, so converted tags can be checked
+type TagCatalog interface {
+	// ListTags returns the tags of an image, e.g. "node", or nil if the catalog does not know the image
+	ListTags(ctx context.Context, image string) ([]string, error)
+}
+
+// FileTagCatalog is a tag catalog read from a YAML or JSON file mapping image names to tags:
+//
+//	node: ["22", "22-dev", "20", "20-dev", "latest", "latest-dev"]
+type FileTagCatalog struct {
+	Tags map[string][]string
+}
+
+// NewFileTagCatalog reads a tag catalog file
+func NewFileTagCatalog(path string) (*FileTagCatalog, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("reading tag catalog: %w", err)
+	}
+	var tags map[string][]string
+	if err := yaml.Unmarshal(data, &tags); err != nil {
+		return nil, fmt.Errorf("unmarshalling tag catalog %s: %w", path, err)
+	}
+	return &FileTagCatalog{Tags: tags}, nil
+}
+
+// ListTags returns the tags listed for the image. A repository such as "cgr.dev/acme/node" uses
+// the tags of its name when the file does not list the repository itself.
+func (c *FileTagCatalog) ListTags(_ context.Context, image string) ([]string, error) {
+	if tags, ok := c.Tags[image]; ok {
+		return tags, nil
+	}
+	return c.Tags[filepath.Base(image)], nil
+}
+
+// RegistryTagCatalog lists the tags of images in a registry namespace, with the credentials of
+// the Docker config
+type RegistryTagCatalog struct {
+	Repository string       // Registry and namespace of the images, e.g. "cgr.dev/chainguard", or empty to list full repositories
+	Client     *http.Client // HTTP client whose transport is used, http.DefaultTransport if nil
+	UserAgent  string       // User agent of the requests
+}
+
+// ListTags fetches the tags of the image from the registry, following pagination
+func (c *RegistryTagCatalog) ListTags(ctx context.Context, image string) ([]string, error) {
+	if c.Repository != "" {
+		image = strings.TrimSuffix(c.Repository, "/") + "/" + image
+	}
+	repository, err := name.NewRepository(image)
+	if err != nil {
+		return nil, fmt.Errorf("parsing repository of %s: %w", image, err)
+	}
+	tags, err := remote.List(repository, remoteOptions(ctx, c.Client, c.UserAgent)...)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing tags of %s: %w", repository, err)
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	return tags, nil
+}
+
+// selectAvailableVersion returns the version to use when version (e.g. "14.17") is not among the
+// available versions. A coarser stream of the same version ("14") is preferred, then a finer one
+// ("14.17.3"), then the version chosen by the policy. It returns false if no version is available.
+func selectAvailableVersion(version string, available []string, policy TagPolicy) (string, bool) {
+	requested := versionComponents(version)
+	var candidates [][]int
+	var names []string
+	for _, v := range available {
+		if c := versionComponents(v); c != nil {
+			candidates = append(candidates, c)
+			names = append(names, v)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+
+	// Same version stream
+	best := -1
+	for i, c := range candidates {
+		if len(c) < len(requested) && slices.Equal(c, requested[:len(c)]) &&
+			(best < 0 || len(c) > len(candidates[best])) {
+			best = i
+		}
+	}
+	if best < 0 {
+		for i, c := range candidates {
+			if len(c) > len(requested) && slices.Equal(c[:len(requested)], requested) &&
+				(best < 0 || slices.Compare(c, candidates[best]) > 0) {
+				best = i
+			}
+		}
+	}
+	if best >= 0 {
+		return names[best], true
+	}
+
+	newest := 0
+	for i, c := range candidates {
+		if slices.Compare(c, candidates[newest]) > 0 {
+			newest = i
+		}
+	}
+	if policy == TagPolicyLatest {
+		return names[newest], true
+	}
+
+	// Closest newer version, or the newest one
+	closest := -1
+	for i, c := range candidates {
+		if slices.Compare(c, requested) > 0 && (closest < 0 || slices.Compare(c, candidates[closest]) < 0) {
+			closest = i
+		}
+	}
+	if closest < 0 {
+		return names[newest], true
+	}
+	return names[closest], true
+}
+
+// versionComponents parses a numeric version such as "3.12", returning nil for other tags
+func versionComponents(version string) []int {
+	if version == "" {
+		return nil
+	}
+	parts := strings.Split(version, ".")
+	components := make([]int, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil
+		}
+		components = append(components, n)
+	}
+	return components
+}
+
+// catalogVersions returns the versions of the tags of an image that follow its tag rule, ignoring
+// -dev variants
+func catalogVersions(tags []string, rule TagRule) []string {
+	var versions []string
+	for _, tag := range tags {
+		if strings.HasSuffix(tag, "-dev") && !strings.HasSuffix(rule.Suffix, "-dev") {
+			continue
+		}
+		version, ok := strings.CutPrefix(tag, rule.Prefix)
+		if !ok {
+			continue
+		}
+		if version, ok = strings.CutSuffix(version, rule.Suffix); ok && versionComponents(version) != nil {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
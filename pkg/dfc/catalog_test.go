@@ -0,0 +1,179 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSelectAvailableVersion(t *testing.T) {
+	available := []string{"18", "20", "22", "3.11", "3.12"}
+	tests := []struct {
+		name      string
+		version   string
+		available []string
+		policy    TagPolicy
+		expected  string
+		ok        bool
+	}{
+		{name: "next newer major", version: "14.17", available: available, expected: "18", ok: true},
+		{name: "next newer between streams", version: "21", available: available, expected: "22", ok: true},
+		{name: "newest when nothing is newer", version: "24.1", available: available, expected: "22", ok: true},
+		{name: "latest policy", version: "14.17", available: available, policy: TagPolicyLatest, expected: "22", ok: true},
+		{name: "coarser stream", version: "20.11", available: available, policy: TagPolicyLatest, expected: "20", ok: true},
+		{name: "finer stream", version: "3", available: available, expected: "3.12", ok: true},
+		{name: "minor versions", version: "3.9", available: []string{"3.10", "3.11", "3.12"}, expected: "3.10", ok: true},
+		{name: "no versions", version: "14.17", available: []string{"latest"}, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := selectAvailableVersion(tt.version, tt.available, tt.policy)
+			if got != tt.expected || ok != tt.ok {
+				t.Errorf("selectAvailableVersion(%q) = %q, %v, want %q, %v", tt.version, got, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTagCatalogConversion(t *testing.T) {
+	catalogFile := filepath.Join(t.TempDir(), "catalog.yaml")
+	catalogYAML := `node: ["22", "22-dev", "20", "20-dev", "latest", "latest-dev"]
+jre: ["openjdk-17", "openjdk-21", "openjdk-21-dev", "latest"]
+static: ["latest"]
+`
+	if err := os.WriteFile(catalogFile, []byte(catalogYAML), 0600); err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := NewFileTagCatalog(catalogFile)
+	if err != nil {
+		t.Fatalf("NewFileTagCatalog() error = %v", err)
+	}
+
+	testCases := []struct {
+		name        string
+		raw         string
+		policy      TagPolicy
+		expected    string
+		diagnostics []string
+	}{
+		{
+			name: "unpublished version moves to the next newer one",
+			raw: `FROM node:14.17.3
+RUN npm ci`,
+			expected: `FROM cgr.dev/ORG/node:20-dev
+RUN npm ci`,
+			diagnostics: []string{"node:14.17 is not published, using node:20 instead"},
+		},
+		{
+			name:        "latest policy",
+			raw:         `FROM node:14.17.3`,
+			policy:      TagPolicyLatest,
+			expected:    `FROM cgr.dev/ORG/node:22`,
+			diagnostics: []string{"node:14.17 is not published, using node:22 instead"},
+		},
+		{
+			name:     "published version is kept",
+			raw:      `FROM node:20`,
+			expected: `FROM cgr.dev/ORG/node:20`,
+		},
+		{
+			name:        "tag rule prefix",
+			raw:         `FROM eclipse-temurin:11-jre`,
+			expected:    `FROM cgr.dev/ORG/jre:openjdk-17`,
+			diagnostics: []string{"jre:openjdk-11 is not published, using jre:openjdk-17 instead"},
+		},
+		{
+			name:        "image without versions",
+			raw:         `FROM gcr.io/distroless/static-debian12:nonroot`,
+			expected:    `FROM cgr.dev/ORG/static:latest`,
+			diagnostics: nil,
+		},
+		{
+			name:     "image unknown to the catalog",
+			raw:      `FROM python:3.9.18`,
+			expected: `FROM cgr.dev/ORG/python:3.9`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{Organization: "ORG", TagCatalog: catalog, TagPolicy: tc.policy})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var diagnostics []string
+			for _, d := range converted.Diagnostics {
+				if d.Code == DiagnosticTagNotPublished {
+					diagnostics = append(diagnostics, d.Message)
+				}
+			}
+			if diff := cmp.Diff(tc.diagnostics, diagnostics); diff != "" {
+				t.Errorf("diagnostics not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRegistryTagCatalog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+		case r.URL.Path == "/v2/chainguard/node/tags/list" && r.URL.Query().Get("last") == "":
+			w.Header().Set("Link", `</v2/chainguard/node/tags/list?last=20&n=2>; rel="next"`)
+			w.Write([]byte(`{"name": "chainguard/node", "tags": ["18", "20"]}`))
+		case r.URL.Path == "/v2/chainguard/node/tags/list":
+			w.Write([]byte(`{"name": "chainguard/node", "tags": ["22", "latest"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	catalog := &RegistryTagCatalog{
+		Repository: strings.TrimPrefix(server.URL, "http://") + "/chainguard",
+		Client:     server.Client(),
+	}
+	ctx := context.Background()
+	tags, err := catalog.ListTags(ctx, "node")
+	if err != nil {
+		t.Fatalf("ListTags() error = %v", err)
+	}
+	if diff := cmp.Diff([]string{"18", "20", "22", "latest"}, tags); diff != "" {
+		t.Errorf("ListTags() mismatch (-want, +got):\n%s", diff)
+	}
+
+	tags, err = catalog.ListTags(ctx, "unknown")
+	if err != nil || tags != nil {
+		t.Errorf("ListTags() for an unknown image = %v, %v, want nil, nil", tags, err)
+	}
+
+	// Without a namespace the image is a full repository
+	catalog.Repository = ""
+	tags, err = catalog.ListTags(ctx, strings.TrimPrefix(server.URL, "http://")+"/chainguard/node")
+	if err != nil {
+		t.Fatalf("ListTags() error = %v", err)
+	}
+	if diff := cmp.Diff([]string{"18", "20", "22", "latest"}, tags); diff != "" {
+		t.Errorf("ListTags() of a full repository mismatch (-want, +got):\n%s", diff)
+	}
+}
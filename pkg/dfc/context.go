@@ -0,0 +1,197 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Severity describes how serious a Diagnostic is
+type Severity string
+
+// Supported diagnostic severities
+const (
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+// Diagnostic codes
+const (
+	DiagnosticCopyWithoutChown   = "copy-without-chown"
+	DiagnosticEntrypointAdjusted = "entrypoint-adjusted"
+	DiagnosticFromConverterError = "from-converter-error"
+	DiagnosticHealthcheckTool    = "healthcheck-tool"
+	DiagnosticImageResolverError = "image-resolver-error"
+	DiagnosticImageWorkdir       = "image-workdir"
+	DiagnosticMissingPath        = "missing-path"
+	DiagnosticNoFIPSImage        = "no-fips-image"
+	DiagnosticNoFIPSPackage      = "no-fips-package"
+	DiagnosticShellForm          = "shell-form"
+	DiagnosticShellFormConverted = "shell-form-converted"
+	DiagnosticTagAliasResolved   = "tag-alias-resolved"
+	DiagnosticTagCatalogError    = "tag-catalog-error"
+	DiagnosticTagNotPublished    = "tag-not-published"
+	DiagnosticPathRewritten      = "path-rewritten"
+	DiagnosticPrivilegedPort     = "privileged-port"
+	DiagnosticReplacedCommand    = "replaced-command"
+	DiagnosticRootOwnedPath      = "root-owned-path"
+	DiagnosticRuntimeImage       = "runtime-image"
+	DiagnosticRuntimeStageSplit  = "runtime-stage-split"
+	DiagnosticUnsupportedCommand = "unsupported-command"
+	DiagnosticUnsupportedVariant = "unsupported-variant"
+	DiagnosticUserNotRestored    = "user-not-restored"
+)
+
+// Diagnostic describes a problem or a notable decision made during conversion
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Line     int      `json:"line,omitempty"`  // 1-based line number in the original Dockerfile
+	Stage    int      `json:"stage,omitempty"` // Stage the diagnostic applies to
+	Message  string   `json:"message"`
+}
+
+// String returns a human readable representation of the diagnostic
+func (d Diagnostic) String() string {
+	if d.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", d.Line, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+}
+
+// StageContext holds the state of a single build stage during conversion
+type StageContext struct {
+	Index         int          // Stage number as used in DockerfileLine.Stage (first FROM is 1)
+	Alias         string       // Name given to the stage with "AS", if any
+	From          *FromDetails // The original FROM details of the stage
+	ConvertedFrom string       // The image reference the stage uses after conversion
+	HasRun        bool         // Whether the stage contains at least one RUN directive
+	Packages      []string     // apk packages installed so far in the stage
+	User          string       // The user in effect at the current line, empty if never set
+
+	apkLine *DockerfileLine // Last converted RUN line of the stage with an apk add command
+}
+
+// ConversionContext exposes the Dockerfile and stage state of an in-progress conversion
+// to custom converters. Converters must treat it as read-only.
+type ConversionContext struct {
+	Dockerfile *Dockerfile     // The original Dockerfile being converted
+	Stages     []*StageContext // All stages of the Dockerfile, in order
+	Stage      *StageContext   // The stage the current line belongs to
+	Line       int             // 1-based line number of the current line in the original Dockerfile
+
+	stages      map[int]*StageContext
+	lineNumbers []int
+	originals   map[*DockerfileLine]int // Index of the original line of each converted line
+	diagnostics []Diagnostic
+	fips        *FIPSMappings // FIPS equivalents of packages, nil unless converting in FIPS mode
+}
+
+// newConversionContext builds the stage state for a Dockerfile before its lines are converted
+func newConversionContext(d *Dockerfile, stagesWithRunCommands map[int]bool) *ConversionContext {
+	cc := &ConversionContext{
+		Dockerfile:  d,
+		stages:      make(map[int]*StageContext),
+		lineNumbers: make([]int, len(d.Lines)),
+		originals:   make(map[*DockerfileLine]int),
+	}
+
+	lineNumber := 1
+	for i, line := range d.Lines {
+		lineNumber += strings.Count(line.Extra, "\n")
+		cc.lineNumbers[i] = lineNumber
+		lineNumber += strings.Count(line.Raw, "\n") + 1
+
+		if line.From == nil {
+			continue
+		}
+		stage := &StageContext{
+			Index:  line.Stage,
+			Alias:  line.From.Alias,
+			From:   line.From,
+			HasRun: stagesWithRunCommands[line.Stage],
+		}
+		cc.stages[line.Stage] = stage
+		cc.Stages = append(cc.Stages, stage)
+	}
+
+	return cc
+}
+
+// StageByIndex returns the stage with the given stage number, creating an
+// empty one for lines that appear before the first FROM
+func (cc *ConversionContext) StageByIndex(index int) *StageContext {
+	if stage, ok := cc.stages[index]; ok {
+		return stage
+	}
+	stage := &StageContext{Index: index}
+	cc.stages[index] = stage
+	return stage
+}
+
+// convertedImage returns the converted image reference of the stage started by a FROM line, or
+// an empty string if the line kept its original image
+func (cc *ConversionContext) convertedImage(fromLine *DockerfileLine) string {
+	stage := cc.StageByIndex(fromLine.Stage)
+	if fromLine.Converted == "" || stage.ConvertedFrom == "" || stage.ConvertedFrom == fromLine.From.Orig {
+		return ""
+	}
+	return stage.ConvertedFrom
+}
+
+// LookupStage resolves a stage reference as used by FROM and COPY --from, either
+// a stage alias (case-insensitive) or a 0-based stage number. It returns nil if
+// the reference does not name a stage.
+func (cc *ConversionContext) LookupStage(ref string) *StageContext {
+	for _, stage := range cc.Stages {
+		if stage.Alias != "" && strings.EqualFold(stage.Alias, ref) {
+			return stage
+		}
+	}
+	if n, err := strconv.Atoi(ref); err == nil && n >= 0 && n < len(cc.Stages) {
+		return cc.Stages[n]
+	}
+	return nil
+}
+
+// enterLine points the context at the given line of the original Dockerfile
+func (cc *ConversionContext) enterLine(i int) {
+	line := cc.Dockerfile.Lines[i]
+	cc.Line = cc.lineNumbers[i]
+	cc.Stage = cc.StageByIndex(line.Stage)
+}
+
+// enterConvertedLine points the context at the original line of a converted line, or only at
+// its stage for lines added by the conversion
+func (cc *ConversionContext) enterConvertedLine(line *DockerfileLine) {
+	if i, ok := cc.originals[line]; ok {
+		cc.enterLine(i)
+		return
+	}
+	cc.Line = 0
+	cc.Stage = cc.StageByIndex(line.Stage)
+}
+
+// report records a diagnostic for the current line, and does nothing without a conversion
+func (cc *ConversionContext) report(severity Severity, code string, format string, args ...any) {
+	if cc == nil {
+		return
+	}
+	stage := 0
+	if cc.Stage != nil {
+		stage = cc.Stage.Index
+	}
+	cc.diagnostics = append(cc.diagnostics, Diagnostic{
+		Severity: severity,
+		Code:     code,
+		Line:     cc.Line,
+		Stage:    stage,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
@@ -0,0 +1,341 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Packages providing the GNU versions of commands that busybox also provides
+const (
+	PackageCoreutils = "coreutils"
+	PackageGrep      = "grep"
+	PackageFindutils = "findutils"
+	PackageSed       = "sed"
+	PackageWget      = "wget"
+)
+
+// BusyboxFlagRule describes how a GNU flag of a command is handled when converting to busybox
+type BusyboxFlagRule struct {
+	Flags       []string // GNU spellings of the flag, such as "-P" and "--perl-regexp"
+	Replacement string   // Equivalent busybox flag, empty to drop the flag
+	HasValue    bool     // The flag takes a value, either as "--flag=value" or as the next argument
+	AttachValue bool     // An optional "=value" is attached to the replacement ("--in-place=.bak" -> "-i.bak")
+	Unsupported bool     // There is no busybox equivalent, the GNU package is required
+
+	// Values busybox accepts, other literal values require the GNU package
+	SupportedValue *regexp.Regexp
+}
+
+// busyboxDateRegexp matches the date formats busybox date -d accepts: @seconds, hh:mm[:ss],
+// [YYYY.]MM.DD-hh:mm[:ss], YYYY-MM-DD[ hh:mm[:ss]] and MMDDhhmm[[YY]YY][.ss]. Relative dates such
+// as "next monday" or "1 day ago" need GNU date.
+var busyboxDateRegexp = regexp.MustCompile(`^(@\d+|\d{1,2}:\d{2}(:\d{2})?|(\d{4}\.)?\d{1,2}\.\d{1,2}-\d{1,2}:\d{2}(:\d{2})?|\d{4}-\d{1,2}-\d{1,2}([ T]\d{1,2}:\d{2}(:\d{2})?)?|\d{8}(\d{2}|\d{4})?(\.\d{2})?)$`)
+
+// BusyboxCommandRules lists the GNU flags of a command that busybox does not understand
+type BusyboxCommandRules struct {
+	Command string
+	Package string // Package providing the GNU version of the command
+	Bundles bool   // Short flags may be bundled ("-rP")
+	Flags   []BusyboxFlagRule
+}
+
+// BusyboxIncompatibilities is the table of known differences between GNU and busybox commands
+var BusyboxIncompatibilities = []BusyboxCommandRules{
+	{
+		Command: "sed",
+		Package: PackageSed,
+		Bundles: true,
+		Flags: []BusyboxFlagRule{
+			{Flags: []string{"--expression"}, Replacement: "-e", HasValue: true},
+			{Flags: []string{"--file"}, Replacement: "-f", HasValue: true},
+			{Flags: []string{"--in-place"}, Replacement: "-i", AttachValue: true},
+			{Flags: []string{"--quiet", "--silent"}, Replacement: "-n"},
+			{Flags: []string{"--regexp-extended"}, Replacement: "-E"},
+			{Flags: []string{"-u", "--unbuffered"}},
+			{Flags: []string{"-z", "--null-data", "--follow-symlinks", "--debug", "--sandbox"}, Unsupported: true},
+		},
+	},
+	{
+		Command: "grep",
+		Package: PackageGrep,
+		Bundles: true,
+		Flags: []BusyboxFlagRule{
+			{Flags: []string{"--extended-regexp"}, Replacement: "-E"},
+			{Flags: []string{"--fixed-strings"}, Replacement: "-F"},
+			{Flags: []string{"--basic-regexp"}},
+			{Flags: []string{"--ignore-case"}, Replacement: "-i"},
+			{Flags: []string{"--invert-match"}, Replacement: "-v"},
+			{Flags: []string{"--word-regexp"}, Replacement: "-w"},
+			{Flags: []string{"--line-regexp"}, Replacement: "-x"},
+			{Flags: []string{"--count"}, Replacement: "-c"},
+			{Flags: []string{"--quiet", "--silent"}, Replacement: "-q"},
+			{Flags: []string{"--no-messages"}, Replacement: "-s"},
+			{Flags: []string{"--files-with-matches"}, Replacement: "-l"},
+			{Flags: []string{"--files-without-match"}, Replacement: "-L"},
+			{Flags: []string{"--line-number"}, Replacement: "-n"},
+			{Flags: []string{"--with-filename"}, Replacement: "-H"},
+			{Flags: []string{"--no-filename"}, Replacement: "-h"},
+			{Flags: []string{"--only-matching"}, Replacement: "-o"},
+			{Flags: []string{"--recursive"}, Replacement: "-r"},
+			{Flags: []string{"--dereference-recursive"}, Replacement: "-R"},
+			{Flags: []string{"--regexp"}, Replacement: "-e", HasValue: true},
+			{Flags: []string{"--file"}, Replacement: "-f", HasValue: true},
+			{Flags: []string{"--max-count"}, Replacement: "-m", HasValue: true},
+			{Flags: []string{"--after-context"}, Replacement: "-A", HasValue: true},
+			{Flags: []string{"--before-context"}, Replacement: "-B", HasValue: true},
+			{Flags: []string{"--context"}, Replacement: "-C", HasValue: true},
+			{Flags: []string{"--color", "--colour"}},
+			{Flags: []string{"-P", "--perl-regexp", "-z", "--null-data", "-Z", "--null"}, Unsupported: true},
+			{Flags: []string{"--include", "--exclude", "--exclude-dir", "--label"}, HasValue: true, Unsupported: true},
+		},
+	},
+	{
+		Command: "find",
+		Package: PackageFindutils,
+		Flags: []BusyboxFlagRule{
+			{Flags: []string{"-printf", "-fprint", "-regextype"}, HasValue: true, Unsupported: true},
+			{Flags: []string{"-fprintf", "-execdir", "-readable", "-writable", "-executable"}, Unsupported: true},
+		},
+	},
+	{
+		Command: "xargs",
+		Package: PackageFindutils,
+		Bundles: true,
+		Flags: []BusyboxFlagRule{
+			{Flags: []string{"--no-run-if-empty"}, Replacement: "-r"},
+			{Flags: []string{"--null"}, Replacement: "-0"},
+			{Flags: []string{"--verbose"}, Replacement: "-t"},
+			{Flags: []string{"--interactive"}, Replacement: "-p"},
+			{Flags: []string{"--exit"}, Replacement: "-x"},
+			{Flags: []string{"--max-args"}, Replacement: "-n", HasValue: true},
+			{Flags: []string{"--max-procs"}, Replacement: "-P", HasValue: true},
+			{Flags: []string{"--max-chars"}, Replacement: "-s", HasValue: true},
+			{Flags: []string{"-a", "--arg-file", "-d", "--delimiter"}, HasValue: true, Unsupported: true},
+		},
+	},
+	{
+		Command: "date",
+		Package: PackageCoreutils,
+		Bundles: true,
+		Flags: []BusyboxFlagRule{
+			{Flags: []string{"--utc", "--universal"}, Replacement: "-u"},
+			{Flags: []string{"--iso-8601"}, Replacement: "-I", AttachValue: true},
+			{Flags: []string{"--rfc-email", "--rfc-2822"}, Replacement: "-R"},
+			{Flags: []string{"--reference"}, Replacement: "-r", HasValue: true},
+			{Flags: []string{"--set"}, Replacement: "-s", HasValue: true},
+			{Flags: []string{"-d", "--date"}, Replacement: "-d", HasValue: true, SupportedValue: busyboxDateRegexp},
+		},
+	},
+	{
+		Command: "stat",
+		Package: PackageCoreutils,
+		Bundles: true,
+		Flags: []BusyboxFlagRule{
+			{Flags: []string{"--format"}, Replacement: "-c", HasValue: true},
+			{Flags: []string{"--dereference"}, Replacement: "-L"},
+			{Flags: []string{"--file-system"}, Replacement: "-f"},
+			{Flags: []string{"--terse"}, Replacement: "-t"},
+			{Flags: []string{"--printf"}, HasValue: true, Unsupported: true},
+		},
+	},
+	{
+		Command: "cp",
+		Package: PackageCoreutils,
+		Bundles: true,
+		Flags: []BusyboxFlagRule{
+			{Flags: []string{"--archive"}, Replacement: "-a"},
+			{Flags: []string{"--recursive"}, Replacement: "-R"},
+			{Flags: []string{"--force"}, Replacement: "-f"},
+			{Flags: []string{"--no-clobber"}, Replacement: "-n"},
+			{Flags: []string{"--verbose"}, Replacement: "-v"},
+			{Flags: []string{"--dereference"}, Replacement: "-L"},
+			{Flags: []string{"--no-dereference"}, Replacement: "-P"},
+			{Flags: []string{"--link"}, Replacement: "-l"},
+			{Flags: []string{"--symbolic-link"}, Replacement: "-s"},
+			{Flags: []string{"--update"}, Replacement: "-u"},
+			{Flags: []string{"--interactive"}, Replacement: "-i"},
+			{Flags: []string{"--one-file-system"}, Replacement: "-x"},
+			{Flags: []string{"--preserve"}, Replacement: "-p"},
+			{Flags: []string{"--parents", "--reflink", "--sparse", "--backup", "--remove-destination", "--strip-trailing-slashes"}, Unsupported: true},
+			{Flags: []string{"-t", "--target-directory", "--no-preserve", "--suffix"}, HasValue: true, Unsupported: true},
+		},
+	},
+	{
+		Command: "readlink",
+		Package: PackageCoreutils,
+		Bundles: true,
+		Flags: []BusyboxFlagRule{
+			{Flags: []string{"--canonicalize"}, Replacement: "-f"},
+			{Flags: []string{"--no-newline"}, Replacement: "-n"},
+			{Flags: []string{"--verbose"}, Replacement: "-v"},
+			{Flags: []string{"--quiet", "--silent"}, Replacement: "-q"},
+			{Flags: []string{"-e", "--canonicalize-existing", "-m", "--canonicalize-missing", "-z", "--zero"}, Unsupported: true},
+		},
+	},
+	{
+		Command: "ln",
+		Package: PackageCoreutils,
+		Bundles: true,
+		Flags: []BusyboxFlagRule{
+			{Flags: []string{"--symbolic"}, Replacement: "-s"},
+			{Flags: []string{"--force"}, Replacement: "-f"},
+			{Flags: []string{"--no-dereference"}, Replacement: "-n"},
+			{Flags: []string{"--no-target-directory"}, Replacement: "-T"},
+			{Flags: []string{"--verbose"}, Replacement: "-v"},
+			{Flags: []string{"--suffix"}, Replacement: "-S", HasValue: true},
+			{Flags: []string{"-r", "--relative", "-L", "--logical", "--backup"}, Unsupported: true},
+			{Flags: []string{"-t", "--target-directory"}, HasValue: true, Unsupported: true},
+		},
+	},
+	{
+		Command: "wget",
+		Package: PackageWget,
+		Flags: []BusyboxFlagRule{
+			{Flags: []string{"--quiet"}, Replacement: "-q"},
+			{Flags: []string{"-nv", "--no-verbose"}, Replacement: "-q"},
+			{Flags: []string{"--show-progress"}},
+			{Flags: []string{"--progress"}, HasValue: true},
+			{Flags: []string{"--continue"}, Replacement: "-c"},
+			{Flags: []string{"--server-response"}, Replacement: "-S"},
+			{Flags: []string{"--output-document"}, Replacement: "-O", HasValue: true},
+			{Flags: []string{"--directory-prefix"}, Replacement: "-P", HasValue: true},
+			{Flags: []string{"--user-agent"}, Replacement: "-U", HasValue: true},
+			{Flags: []string{"--timeout"}, Replacement: "-T", HasValue: true},
+			{Flags: []string{"--output-file"}, Replacement: "-o", HasValue: true},
+			{Flags: []string{"-r", "--recursive", "-m", "--mirror", "-N", "--timestamping", "--no-parent",
+				"--retry-connrefused", "--content-disposition", "--https-only", "--auth-no-challenge"}, Unsupported: true},
+			{Flags: []string{"-t", "--tries", "-w", "--wait", "--waitretry", "-i", "--input-file", "--ca-certificate",
+				"--user", "--password", "--http-user", "--http-password", "--secure-protocol"}, HasValue: true, Unsupported: true},
+		},
+	},
+}
+
+// busyboxCompatHandlers returns a command handler for each entry of BusyboxIncompatibilities
+func busyboxCompatHandlers() []CommandHandler {
+	handlers := make([]CommandHandler, 0, len(BusyboxIncompatibilities))
+	for _, rules := range BusyboxIncompatibilities {
+		handlers = append(handlers, CommandHandler{
+			Command: rules.Command,
+			Converter: func(part *ShellPart) *ShellPart {
+				return ConvertGNUFlagsToBusybox(part, rules)
+			},
+			Package: rules.Package,
+			RequiresPackage: func(part *ShellPart) bool {
+				return RequiresGNUCommand(part, rules)
+			},
+		})
+	}
+	return handlers
+}
+
+// lookupFlag finds the rule for a flag, ignoring any "=value" suffix of long flags
+func (r BusyboxCommandRules) lookupFlag(arg string) (BusyboxFlagRule, string, bool, bool) {
+	name, value, hasValue := arg, "", false
+	if strings.HasPrefix(arg, "--") {
+		name, value, hasValue = strings.Cut(arg, "=")
+	}
+	for _, rule := range r.Flags {
+		if slices.Contains(rule.Flags, name) {
+			return rule, value, hasValue, true
+		}
+	}
+	return BusyboxFlagRule{}, "", false, false
+}
+
+// RequiresGNUCommand reports whether the command uses a flag that has no busybox equivalent
+func RequiresGNUCommand(part *ShellPart, rules BusyboxCommandRules) bool {
+	if part.Command != rules.Command {
+		return false
+	}
+
+	for i := 0; i < len(part.Args); i++ {
+		arg := part.Args[i]
+		if arg == "--" {
+			return false
+		}
+		if rule, value, hasValue, ok := rules.lookupFlag(arg); ok {
+			if rule.Unsupported {
+				return true
+			}
+			if rule.HasValue && !hasValue && i+1 < len(part.Args) {
+				value = part.Args[i+1]
+				i++
+			}
+			// Values using variables cannot be checked
+			if rule.SupportedValue != nil && !strings.Contains(value, "$") && !rule.SupportedValue.MatchString(unquote(value)) {
+				return true
+			}
+			continue
+		}
+
+		// Check each flag of a bundle such as "-rP"
+		if rules.Bundles && len(arg) > 2 && arg[0] == '-' && arg[1] != '-' {
+			for _, c := range arg[1:] {
+				if rule, _, _, ok := rules.lookupFlag("-" + string(c)); ok && rule.Unsupported {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ConvertGNUFlagsToBusybox rewrites the GNU flags of a command to their busybox equivalents
+// according to the rules. Flags without an equivalent are left as is, see RequiresGNUCommand.
+func ConvertGNUFlagsToBusybox(part *ShellPart, rules BusyboxCommandRules) *ShellPart {
+	if part.Command != rules.Command {
+		return part
+	}
+
+	result := &ShellPart{
+		ExtraPre:  part.ExtraPre,
+		Command:   part.Command,
+		Delimiter: part.Delimiter,
+	}
+
+	var resultArgs []string
+	for i := 0; i < len(part.Args); i++ {
+		arg := part.Args[i]
+		if arg == "--" {
+			// Everything after "--" is an operand
+			resultArgs = append(resultArgs, part.Args[i:]...)
+			break
+		}
+
+		rule, value, hasValue, ok := rules.lookupFlag(arg)
+		if !ok || rule.Unsupported {
+			resultArgs = append(resultArgs, arg)
+			continue
+		}
+
+		if rule.HasValue && !hasValue {
+			if i+1 >= len(part.Args) {
+				resultArgs = append(resultArgs, arg)
+				continue
+			}
+			value = part.Args[i+1]
+			hasValue = true
+			i++
+		}
+
+		switch {
+		case rule.Replacement == "":
+			// The flag has no effect under busybox, drop it along with its value
+		case rule.HasValue:
+			resultArgs = append(resultArgs, rule.Replacement, value)
+		case rule.AttachValue && hasValue:
+			resultArgs = append(resultArgs, rule.Replacement+value)
+		default:
+			resultArgs = append(resultArgs, rule.Replacement)
+		}
+	}
+
+	result.Args = resultArgs
+	return result
+}
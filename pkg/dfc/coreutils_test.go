@@ -0,0 +1,215 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func busyboxRulesFor(t *testing.T, command string) BusyboxCommandRules {
+	t.Helper()
+	for _, rules := range BusyboxIncompatibilities {
+		if rules.Command == command {
+			return rules
+		}
+	}
+	t.Fatalf("no busybox rules for %q", command)
+	return BusyboxCommandRules{}
+}
+
+func TestConvertGNUFlagsToBusybox(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    *ShellPart
+		expected *ShellPart
+		requires bool
+	}{
+		{
+			name:     "sed long options",
+			input:    &ShellPart{Command: "sed", Args: []string{"-i", "--regexp-extended", "--expression", "'s/a/b/'", "file"}},
+			expected: &ShellPart{Command: "sed", Args: []string{"-i", "-E", "-e", "'s/a/b/'", "file"}},
+		},
+		{
+			name:     "sed in-place with suffix",
+			input:    &ShellPart{Command: "sed", Args: []string{"--in-place=.bak", "--expression='s/a/b/'", "file"}},
+			expected: &ShellPart{Command: "sed", Args: []string{"-i.bak", "-e", "'s/a/b/'", "file"}},
+		},
+		{
+			name:     "grep perl regexp",
+			input:    &ShellPart{Command: "grep", Args: []string{"-P", "'\\d+'", "file"}},
+			expected: &ShellPart{Command: "grep", Args: []string{"-P", "'\\d+'", "file"}},
+			requires: true,
+		},
+		{
+			name:     "grep bundled perl regexp",
+			input:    &ShellPart{Command: "grep", Args: []string{"-oP", "'\\d+'", "file"}},
+			expected: &ShellPart{Command: "grep", Args: []string{"-oP", "'\\d+'", "file"}},
+			requires: true,
+		},
+		{
+			name:     "grep long options",
+			input:    &ShellPart{Command: "grep", Args: []string{"--quiet", "--ignore-case", "--color=auto", "--regexp=foo", "file"}, Delimiter: "&&"},
+			expected: &ShellPart{Command: "grep", Args: []string{"-q", "-i", "-e", "foo", "file"}, Delimiter: "&&"},
+		},
+		{
+			name:     "find printf",
+			input:    &ShellPart{Command: "find", Args: []string{".", "-name", "'*.so'", "-printf", "'%p\\n'"}},
+			expected: &ShellPart{Command: "find", Args: []string{".", "-name", "'*.so'", "-printf", "'%p\\n'"}},
+			requires: true,
+		},
+		{
+			name:     "xargs no-run-if-empty",
+			input:    &ShellPart{Command: "xargs", Args: []string{"--no-run-if-empty", "--max-args=1", "rm"}},
+			expected: &ShellPart{Command: "xargs", Args: []string{"-r", "-n", "1", "rm"}},
+		},
+		{
+			name:     "date with a relative date",
+			input:    &ShellPart{Command: "date", Args: []string{"-d", "'next monday'", "--utc"}},
+			expected: &ShellPart{Command: "date", Args: []string{"-d", "'next monday'", "-u"}},
+			requires: true,
+		},
+		{
+			name:     "date with a date busybox parses",
+			input:    &ShellPart{Command: "date", Args: []string{"--date='2024-01-31 12:00'", "+%s"}},
+			expected: &ShellPart{Command: "date", Args: []string{"-d", "'2024-01-31 12:00'", "+%s"}},
+		},
+		{
+			name:     "date with seconds since the epoch",
+			input:    &ShellPart{Command: "date", Args: []string{"-u", "-d", "@1700000000"}},
+			expected: &ShellPart{Command: "date", Args: []string{"-u", "-d", "@1700000000"}},
+		},
+		{
+			name:     "date with a variable",
+			input:    &ShellPart{Command: "date", Args: []string{"-d", `"@${SOURCE_DATE_EPOCH}"`}},
+			expected: &ShellPart{Command: "date", Args: []string{"-d", `"@${SOURCE_DATE_EPOCH}"`}},
+		},
+		{
+			name:     "stat format",
+			input:    &ShellPart{Command: "stat", Args: []string{"--format", "'%s'", "file"}},
+			expected: &ShellPart{Command: "stat", Args: []string{"-c", "'%s'", "file"}},
+		},
+		{
+			name:     "cp parents",
+			input:    &ShellPart{Command: "cp", Args: []string{"--parents", "--archive", "a/b", "/dst"}},
+			expected: &ShellPart{Command: "cp", Args: []string{"--parents", "-a", "a/b", "/dst"}},
+			requires: true,
+		},
+		{
+			name:     "readlink canonicalize existing",
+			input:    &ShellPart{Command: "readlink", Args: []string{"-e", "/usr/bin/java"}},
+			expected: &ShellPart{Command: "readlink", Args: []string{"-e", "/usr/bin/java"}},
+			requires: true,
+		},
+		{
+			name:     "ln relative bundled",
+			input:    &ShellPart{Command: "ln", Args: []string{"-sr", "a", "b"}},
+			expected: &ShellPart{Command: "ln", Args: []string{"-sr", "a", "b"}},
+			requires: true,
+		},
+		{
+			name:     "ln long options",
+			input:    &ShellPart{Command: "ln", Args: []string{"--symbolic", "--force", "a", "b"}},
+			expected: &ShellPart{Command: "ln", Args: []string{"-s", "-f", "a", "b"}},
+		},
+		{
+			name:     "wget progress is dropped",
+			input:    &ShellPart{Command: "wget", Args: []string{"--progress=dot:giga", "--output-document", "/tmp/x", "https://example.com/x"}},
+			expected: &ShellPart{Command: "wget", Args: []string{"-O", "/tmp/x", "https://example.com/x"}},
+		},
+		{
+			name:     "operands after double dash are kept",
+			input:    &ShellPart{Command: "grep", Args: []string{"--count", "--", "--count", "file"}},
+			expected: &ShellPart{Command: "grep", Args: []string{"-c", "--", "--count", "file"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rules := busyboxRulesFor(t, tc.input.Command)
+			if got := RequiresGNUCommand(tc.input, rules); got != tc.requires {
+				t.Errorf("RequiresGNUCommand() = %v, want %v", got, tc.requires)
+			}
+			if tc.requires {
+				return
+			}
+			result := ConvertGNUFlagsToBusybox(tc.input, rules)
+			if diff := cmp.Diff(tc.expected, result); diff != "" {
+				t.Errorf("ConvertGNUFlagsToBusybox() mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCoreutilsConversion(t *testing.T) {
+	testCases := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{
+			name:     "flags are rewritten",
+			raw:      `RUN sed --in-place --regexp-extended --expression 's/a/b/' file`,
+			expected: `RUN sed -i -E -e 's/a/b/' file`,
+		},
+		{
+			name:     "pipeline",
+			raw:      `RUN ls | xargs --no-run-if-empty rm`,
+			expected: `RUN ls | xargs -r rm`,
+		},
+		{
+			name: "package is installed when there is no apk line",
+			raw:  `RUN grep -P '\d+' file`,
+			expected: `RUN apk add --no-cache grep && \
+    grep -P '\d+' file`,
+		},
+		{
+			name: "package is added to the apk line of the same RUN",
+			raw:  `RUN apt-get install -y curl && readlink -e /usr/bin/java`,
+			expected: `RUN apk add --no-cache coreutils curl && \
+    readlink -e /usr/bin/java`,
+		},
+		{
+			name: "package is added to an earlier apk line of the stage",
+			raw: `RUN apt-get update && apt-get install -y curl
+RUN find . -printf '%p\n'`,
+			expected: `RUN apk add --no-cache curl findutils
+RUN find . -printf '%p\n'`,
+		},
+		{
+			name: "GNU tar is installed when needed",
+			raw:  `RUN apt-get install -y curl && tar --zstd -xf archive.tar.zst`,
+			expected: `RUN apk add --no-cache curl tar && \
+    tar --zstd -xf archive.tar.zst`,
+		},
+		{
+			name: "GNU command already installed",
+			raw:  `RUN apt-get install -y coreutils && cp --parents --archive a /dst`,
+			expected: `RUN apk add --no-cache coreutils && \
+    cp --parents --archive a /dst`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
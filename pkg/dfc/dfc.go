@@ -8,7 +8,7 @@ package dfc
 import (
 	"context"
 	"fmt"
-	"path/filepath"
+	"regexp"
 	"slices"
 	"strconv"
 	"strings"
@@ -51,6 +51,12 @@ const (
 	CommandAddUser  = "adduser"
 	CommandGroupAdd = "groupadd"
 	CommandAddGroup = "addgroup"
+	CommandDelGroup = "delgroup"
+	CommandUserMod  = "usermod"
+	CommandGPasswd  = "gpasswd"
+	CommandChPasswd = "chpasswd"
+	CommandPasswd   = "passwd"
+	CommandChsh     = "chsh"
 	PackageShadow   = "shadow"
 )
 
@@ -66,6 +72,8 @@ const (
 	DirectiveRun  = "RUN"
 	DirectiveUser = "USER"
 	DirectiveArg  = "ARG"
+	DirectiveEnv  = "ENV"
+	DirectiveCopy = "COPY"
 	KeywordAs     = "AS"
 )
 
@@ -116,11 +124,13 @@ type PackageSpec struct {
 type DockerfileLine struct {
 	Raw       string       `json:"raw"`
 	Converted string       `json:"converted,omitempty"`
-	Extra     string       `json:"extra,omitempty"` // Comments and whitespace that appear before this line
+	Extra     string       `json:"extra,omitempty"`    // Comments and whitespace that appear before this line
+	Appended  []string     `json:"appended,omitempty"` // Directives the conversion added after this line (e.g. ENV TZ)
 	Stage     int          `json:"stage,omitempty"`
 	From      *FromDetails `json:"from,omitempty"`
 	Run       *RunDetails  `json:"run,omitempty"`
 	Arg       *ArgDetails  `json:"arg,omitempty"`
+	Copy      *CopyDetails `json:"copy,omitempty"`
 }
 
 // ArgDetails holds details about an ARG directive
@@ -141,14 +151,25 @@ type FromDetails struct {
 	TagDynamic  bool   `json:"tagDynamic,omitempty"`
 	Orig        string `json:"orig,omitempty"`     // Original full image reference
 	Platform    string `json:"platform,omitempty"` // Platform specification from --platform flag
+
+	// Parts of the tag, e.g. "17", ["jre"] and "jammy" for "17-jre-jammy"
+	Version  string   `json:"version,omitempty"`  // Leading version of the tag
+	Variants []string `json:"variants,omitempty"` // Variant suffixes of the tag, such as "slim" or "jre"
+	OS       string   `json:"os,omitempty"`       // OS or distribution codename of the tag, such as "bookworm" or "alpine"
+}
+
+// CopyDetails holds details about a COPY directive with a --from flag
+type CopyDetails struct {
+	From string `json:"from,omitempty"` // Stage or image given to --from
 }
 
 // RunDetails holds details about a RUN directive
 type RunDetails struct {
-	Distro   Distro           `json:"distro,omitempty"`
-	Manager  Manager          `json:"manager,omitempty"`
-	Packages []string         `json:"packages,omitempty"`
-	Shell    *RunDetailsShell `json:"-"`
+	Distro    Distro           `json:"distro,omitempty"`
+	Manager   Manager          `json:"manager,omitempty"`
+	Packages  []string         `json:"packages,omitempty"`
+	MountFrom []string         `json:"mountFrom,omitempty"` // Stages or images given to from= of --mount flags
+	Shell     *RunDetailsShell `json:"-"`
 }
 
 type RunDetailsShell struct {
@@ -158,7 +179,9 @@ type RunDetailsShell struct {
 
 // Dockerfile represents a parsed Dockerfile
 type Dockerfile struct {
-	Lines []*DockerfileLine `json:"lines"`
+	Lines       []*DockerfileLine `json:"lines"`
+	Diagnostics []Diagnostic      `json:"diagnostics,omitempty"` // Problems found during conversion
+	Runtime     *RuntimeAnalysis  `json:"runtime,omitempty"`     // Runtime image analysis of a final stage that only copies files from other stages
 }
 
 // String returns the Dockerfile content as a string
@@ -180,10 +203,16 @@ func (d *Dockerfile) String() string {
 			builder.WriteString(line.Raw)
 
 			// If this is the last line, don't add a newline
-			if i < len(d.Lines)-1 {
+			if i < len(d.Lines)-1 || len(line.Appended) > 0 {
 				builder.WriteString("\n")
 			}
 		}
+
+		// Add the directives the conversion added after the line
+		for _, directive := range line.Appended {
+			builder.WriteString(directive)
+			builder.WriteString("\n")
+		}
 	}
 
 	return builder.String()
@@ -303,6 +332,7 @@ func ParseDockerfile(_ context.Context, content []byte) (*Dockerfile, error) {
 			}
 
 			// Create the FromDetails
+			version, variants, osVariant := parseTagVariants(tag)
 			dockerfileLine.From = &FromDetails{
 				Base:        base,
 				Tag:         tag,
@@ -313,6 +343,9 @@ func ParseDockerfile(_ context.Context, content []byte) (*Dockerfile, error) {
 				TagDynamic:  strings.Contains(tag, "$"),
 				Orig:        origImageRef,
 				Platform:    platform,
+				Version:     version,
+				Variants:    variants,
+				OS:          osVariant,
 			}
 		}
 
@@ -338,11 +371,18 @@ func ParseDockerfile(_ context.Context, content []byte) (*Dockerfile, error) {
 			}
 		}
 
+		// Handle COPY instructions with a --from flag (case-insensitive)
+		if strings.HasPrefix(upperInstruction, DirectiveCopy+" ") {
+			if from := parseCopyFrom(trimmedInstruction[len(DirectiveCopy+" "):]); from != "" {
+				dockerfileLine.Copy = &CopyDetails{From: from}
+			}
+		}
+
 		// Handle RUN instructions (case-insensitive)
 		if strings.HasPrefix(upperInstruction, DirectiveRun+" ") {
-			// Extract the command part (everything after "RUN ")
+			// Extract the command part (everything after "RUN " and its flags)
 			cmdPartIdx := len(DirectiveRun + " ")
-			cmdPart := strings.TrimSpace(trimmedInstruction[cmdPartIdx:])
+			flags, cmdPart := splitRunFlags(trimmedInstruction[cmdPartIdx:])
 
 			// Parse the shell command
 			shellCmd := ParseMultilineShell(cmdPart)
@@ -350,6 +390,7 @@ func ParseDockerfile(_ context.Context, content []byte) (*Dockerfile, error) {
 			// Store the shell command in Run.Shell.Before
 			if shellCmd != nil {
 				dockerfileLine.Run = &RunDetails{
+					MountFrom: parseMountFrom(flags),
 					Shell: &RunDetailsShell{
 						Before: shellCmd,
 					},
@@ -440,18 +481,20 @@ func ParseDockerfile(_ context.Context, content []byte) (*Dockerfile, error) {
 type PackageMap map[Distro]map[string][]string
 
 // FromLineConverter is a function type for custom image reference conversion in FROM directives.
-// It takes a FromDetails struct containing information about the original image and the
-// string that would be produced by the default Chainguard conversion, and allows for customizing
-// the final image reference.
-// The stageHasRun parameter indicates whether the current build stage has at least one RUN directive,
+// It takes a FromDetails struct containing information about the original image, the
+// string that would be produced by the default Chainguard conversion, and the ConversionContext
+// describing the current stage (alias, whether it has RUN directives, packages installed so far,
+// the user in effect) and the rest of the Dockerfile, and allows for customizing the final image reference.
+// The cc.Stage.HasRun field indicates whether the current build stage has at least one RUN directive,
 // which is useful for determining whether to add a "-dev" suffix to the image tag.
-// If an error is returned, the original image reference will be used instead.
+// If an error is returned, the original image reference will be used instead and the error
+// is reported in the Diagnostics of the converted Dockerfile.
 // The converter is only responsible for returning the image reference part (e.g., "cgr.dev/chainguard/node:latest"),
 // not the full FROM line with directives like "AS" - those will be handled by the calling code.
 //
 // Example usage of a custom converter:
 //
-//	myConverter := func(from *FromDetails, converted string, stageHasRun bool) (string, error) {
+//	myConverter := func(from *FromDetails, converted string, cc *ConversionContext) (string, error) {
 //	    // For most images, just use the default Chainguard conversion
 //	    if from.Base != "python" {
 //	        return converted, nil
@@ -459,7 +502,7 @@ type PackageMap map[Distro]map[string][]string
 //
 //	    // Special handling for python images
 //	    tag := from.Tag
-//	    if stageHasRun && !strings.HasSuffix(tag, "-dev") {
+//	    if cc.Stage.HasRun && !strings.HasSuffix(tag, "-dev") {
 //	        tag += "-dev"
 //	    }
 //	    return "myregistry.example.com/python:" + tag, nil
@@ -470,15 +513,16 @@ type PackageMap map[Distro]map[string][]string
 //	    Organization: "myorg",
 //	    FromLineConverter: myConverter,
 //	})
-type FromLineConverter func(from *FromDetails, converted string, stageHasRun bool) (string, error)
+type FromLineConverter func(from *FromDetails, converted string, cc *ConversionContext) (string, error)
 
 // RunLineConverter is a function type for custom RUN line conversion.
 // It takes a RunDetails struct (parsed info about the RUN line), the string that would be produced by the default conversion,
-// and the build stage number. It returns the string to use for the RUN line, or an error to fall back to the default.
+// and the ConversionContext describing the current stage and the rest of the Dockerfile.
+// It returns the string to use for the RUN line, or an error which aborts the conversion.
 //
 // Example usage:
 //
-//	myRunConverter := func(run *RunDetails, converted string, stage int) (string, error) {
+//	myRunConverter := func(run *RunDetails, converted string, cc *ConversionContext) (string, error) {
 //	    if run.Manager == "apt-get" {
 //	        return "RUN echo 'apt-get is not allowed!'", nil
 //	    }
@@ -488,7 +532,7 @@ type FromLineConverter func(from *FromDetails, converted string, stageHasRun boo
 //	dockerFile.Convert(ctx, dfc.Options{
 //	    RunLineConverter: myRunConverter,
 //	})
-type RunLineConverter func(run *RunDetails, converted string, stage int) (string, error)
+type RunLineConverter func(run *RunDetails, converted string, cc *ConversionContext) (string, error)
 
 // Options defines the configuration options for the conversion
 type Options struct {
@@ -501,66 +545,154 @@ type Options struct {
 	RunLineConverter    RunLineConverter  // Optional custom converter for RUN lines
 	Strict              bool              // When true, fail if any package is unknown
 	WarnMissingPackages bool              // When true, warn about missing package mappings instead of using the original package name
+	ImageResolver       ImageResolver     // Optional custom resolver for image references, NewDefaultImageResolver by default
+	Mirrors             []string          // Registry mirror and proxy prefixes stripped before matching images, in addition to those of the mappings
+	TagCatalog          TagCatalog        // Optional catalog of the published Chainguard tags, converted tags are checked against it
+	TagPolicy           TagPolicy         // Version used when a converted tag is not published, TagPolicyNextNewer by default
+	FIPS                bool              // When true, use the FIPS images and packages of the fips mappings
+	SplitRuntimeStage   bool              // When true, split a final stage that needs a -dev image into a builder and a minimal runtime stage
+	FixNonroot          bool              // When true, fix the non-root issues of the final stage where it is safe
+	FromRegistries      []string          // Registries of previously converted images, moved to Registry or Organization like cgr.dev images
 }
 
 // MappingsConfig represents the structure of builtin-mappings.yaml
 type MappingsConfig struct {
-	Images   map[string]string `yaml:"images"`
-	Packages PackageMap        `yaml:"packages"`
+	Images   map[string]string      `yaml:"images"`
+	Packages PackageMap             `yaml:"packages"`
+	Tags     map[string]TagRule     `yaml:"tags,omitempty"`
+	Mirrors  []string               `yaml:"mirrors,omitempty"` // Prefixes of registry mirrors and pull-through proxies, may contain wildcards
+	Variants map[string]VariantRule `yaml:"variants,omitempty"`
+	FIPS     FIPSMappings           `yaml:"fips,omitempty"`
+
+	ImageMetadata map[string]ImageMetadata     `yaml:"imageMetadata,omitempty"` // Metadata of Chainguard images, keyed by image name
+	Paths         PathMappings                 `yaml:"paths,omitempty"`
+	TagAliases    map[string]map[string]string `yaml:"tagAliases,omitempty"` // Named tags resolved to versions before the tag rules, keyed by original image name
 }
 
-// parseImageReference extracts base and tag from an image reference
-func parseImageReference(imageRef string) (base, tag string) {
-	// Check for tag
-	if tagParts := strings.Split(imageRef, ":"); len(tagParts) > 1 {
-		base = tagParts[0]
-		tag = tagParts[1]
-	} else {
-		base = imageRef
-	}
-	return base, tag
+// ImageMetadata describes the configuration of a Chainguard image that conversions depend on
+type ImageMetadata struct {
+	User       string   `yaml:"user,omitempty"`       // Default user of the image, e.g. "nonroot"
+	Entrypoint []string `yaml:"entrypoint,omitempty"` // ENTRYPOINT of the image, e.g. ["/usr/bin/python"]
+	Shell      *bool    `yaml:"shell,omitempty"`      // Whether the image without -dev has a shell, nil if unknown
+	Workdir    string   `yaml:"workdir,omitempty"`    // Working directory of the image, empty for "/"
+	PortEnv    string   `yaml:"portEnv,omitempty"`    // Environment variable setting the port the image listens on, e.g. "PORT"
 }
 
-// Convert applies the conversion to the Dockerfile and returns a new converted Dockerfile
-func (d *Dockerfile) Convert(ctx context.Context, opts Options) (*Dockerfile, error) {
-	// Initialize mappings
-	var mappings MappingsConfig
+// TagRule describes how the tags of a Chainguard image are derived from the original tags.
+// Rules are keyed by Chainguard image name; a key ending in "*" matches any image with that
+// prefix, and "*" alone applies to images without a more specific rule.
+type TagRule struct {
+	Match   string `yaml:"match,omitempty"`   // Regular expression rewritten in the original tag
+	Replace string `yaml:"replace,omitempty"` // Replacement for Match, may reference groups as $1
+	Prefix  string `yaml:"prefix,omitempty"`  // Prepended to version tags (not to "latest")
+	Suffix  string `yaml:"suffix,omitempty"`  // Appended to version tags (not to "latest")
+	Depth   int    `yaml:"depth,omitempty"`   // Number of version components to keep, 2 (major.minor) by default
+	Fixed   string `yaml:"fixed,omitempty"`   // Always use this tag
+	Dev     *bool  `yaml:"dev,omitempty"`     // Whether -dev is added for stages with RUN lines, true by default
+}
 
-	// Handle mappings based on options
-	if !opts.NoBuiltIn {
-		// Load the default mappings (unless NoBuiltIn is true)
-		defaultMappings, err := defaultGetDefaultMappings(ctx, opts.Update)
-		if err != nil {
-			return nil, fmt.Errorf("loading default mappings: %w", err)
-		}
+// defaultTagDepth is the number of version components kept when a TagRule does not set Depth
+const defaultTagDepth = 2
 
-		// Use default mappings
-		mappings = defaultMappings
+// compiledTagRule is a TagRule with its Match expression compiled
+type compiledTagRule struct {
+	TagRule
+	match *regexp.Regexp
+}
 
-		// Merge with the extra mappings if provided
-		if len(opts.ExtraMappings.Images) > 0 || len(opts.ExtraMappings.Packages) > 0 {
-			mappings = MergeMappings(defaultMappings, opts.ExtraMappings)
+// compileTagRules compiles the Match expressions of the tag rules
+func compileTagRules(rules map[string]TagRule) (map[string]compiledTagRule, error) {
+	compiled := make(map[string]compiledTagRule, len(rules))
+	for key, rule := range rules {
+		c := compiledTagRule{TagRule: rule}
+		if rule.Match != "" {
+			re, err := regexp.Compile(rule.Match)
+			if err != nil {
+				return nil, fmt.Errorf("invalid match of tag rule %q: %w", key, err)
+			}
+			c.match = re
 		}
-	} else {
-		// NoBuiltIn is true, use only ExtraMappings if provided
-		// Otherwise, use empty mappings
-		mappings = opts.ExtraMappings
-		// Initialize empty maps if they don't exist
-		if mappings.Images == nil {
-			mappings.Images = make(map[string]string)
+		compiled[key] = c
+	}
+	return compiled, nil
+}
+
+// defaultTagRules are the tag rules used for images that no rule of the mappings matches, so
+// that conversions with NoBuiltIn keep the historical tag handling
+var defaultTagRules = mustCompileTagRules(map[string]TagRule{
+	"*":                   {Match: "-.*$"},
+	DefaultChainguardBase: {Fixed: "latest", Dev: new(bool)},
+	"jdk":                 {Match: "-.*$", Prefix: "openjdk-"},
+	"jre":                 {Match: "-.*$", Prefix: "openjdk-"},
+})
+
+// mustCompileTagRules compiles tag rules that are known to be valid
+func mustCompileTagRules(rules map[string]TagRule) map[string]compiledTagRule {
+	compiled, err := compileTagRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+// lookupTagRule returns the tag rule for a Chainguard image: an exact match, then the longest
+// matching "prefix*" key, then "*", and the default tag rules when none of the rules matches
+func lookupTagRule(rules map[string]compiledTagRule, image string) compiledTagRule {
+	if rule, ok := findTagRule(rules, image); ok {
+		return rule
+	}
+	rule, _ := findTagRule(defaultTagRules, image)
+	return rule
+}
+
+// findTagRule returns the rule of rules that applies to image, if any
+func findTagRule(rules map[string]compiledTagRule, image string) (compiledTagRule, bool) {
+	if rule, ok := rules[image]; ok {
+		return rule, true
+	}
+	var best string
+	found := false
+	for pattern := range rules {
+		prefix, ok := strings.CutSuffix(pattern, "*")
+		if !ok || !strings.HasPrefix(image, prefix) {
+			continue
 		}
-		if mappings.Packages == nil {
-			mappings.Packages = make(PackageMap)
+		if !found || len(pattern) > len(best) || (len(pattern) == len(best) && pattern < best) {
+			best = pattern
+			found = true
 		}
 	}
+	if found {
+		return rules[best], true
+	}
+	return compiledTagRule{}, false
+}
+
+// Convert applies the conversion to the Dockerfile and returns a new converted Dockerfile
+func (d *Dockerfile) Convert(ctx context.Context, opts Options) (*Dockerfile, error) {
+	mappings, err := LoadMappings(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create a new Dockerfile for the converted content
 	converted := &Dockerfile{
 		Lines: make([]*DockerfileLine, len(d.Lines)),
 	}
 
-	// Track packages installed per stage
-	stagePackages := make(map[int][]string)
+	// Resolve all image references (FROM, ARG and COPY --from) with the same resolver
+	resolver := opts.ImageResolver
+	if resolver == nil {
+		defaultResolver, err := NewDefaultImageResolver(mappings, opts.Registry, opts.Organization)
+		if err != nil {
+			return nil, fmt.Errorf("compiling image mappings: %w", err)
+		}
+		defaultResolver.Catalog = opts.TagCatalog
+		defaultResolver.TagPolicy = opts.TagPolicy
+		defaultResolver.FIPS = opts.FIPS
+		defaultResolver.FromRegistries = opts.FromRegistries
+		resolver = defaultResolver
+	}
 
 	// Track ARGs that are used as base images
 	argNameToDockerfileLine := make(map[string]*DockerfileLine)
@@ -572,8 +704,16 @@ func (d *Dockerfile) Convert(ctx context.Context, opts Options) (*Dockerfile, er
 	// First pass: collect all ARG definitions and identify which ones are used as base images
 	identifyArgsUsedAsBaseImages(d.Lines, argNameToDockerfileLine, argsUsedAsBase)
 
+	// Track the state of each stage as the lines are converted
+	cc := newConversionContext(d, stagesWithRunCommands)
+	if opts.FIPS {
+		cc.fips = &mappings.FIPS
+	}
+
 	// Convert each line
 	for i, line := range d.Lines {
+		cc.enterLine(i)
+
 		// Create a deep copy of the line
 		newLine := &DockerfileLine{
 			Raw:   line.Raw,
@@ -584,54 +724,96 @@ func (d *Dockerfile) Convert(ctx context.Context, opts Options) (*Dockerfile, er
 		if line.From != nil {
 			newLine.From = copyFromDetails(line.From)
 
+			// A stage built on top of another stage starts with that stage's user and packages
+			if line.From.Parent > 0 {
+				parent := cc.StageByIndex(line.From.Parent)
+				cc.Stage.User = parent.User
+				cc.Stage.Packages = slices.Clone(parent.Packages)
+				cc.Stage.ConvertedFrom = parent.ConvertedFrom
+			} else {
+				cc.Stage.ConvertedFrom = line.From.Orig
+			}
+
 			// Apply FROM line conversion only for non-dynamic bases
 			if shouldConvertFromLine(line.From) {
-				// Use the merged mappings for conversion
-				optsWithMappings := Options{
-					Organization:      opts.Organization,
-					Registry:          opts.Registry,
-					ExtraMappings:     mappings,
-					FromLineConverter: opts.FromLineConverter,
-					RunLineConverter:  opts.RunLineConverter,
-				}
-				newLine.Converted = convertFromLine(line.From, line.Stage, stagesWithRunCommands, optsWithMappings)
+				var imageRef string
+				newLine.Converted, imageRef = convertFromLine(ctx, line.From, cc, resolver, opts.FromLineConverter)
+				cc.Stage.ConvertedFrom = imageRef
 			}
 		}
 
 		// Handle ARG lines that are used as base images
 		if line.Arg != nil && line.Arg.UsedAsBase && line.Arg.DefaultValue != "" {
-			// Use the merged mappings for conversion
-			optsWithMappings := Options{
-				Organization:      opts.Organization,
-				Registry:          opts.Registry,
-				ExtraMappings:     mappings,
-				FromLineConverter: opts.FromLineConverter,
-				RunLineConverter:  opts.RunLineConverter,
-			}
-			argLine, argDetails := convertArgLine(line.Arg, d.Lines, stagesWithRunCommands, optsWithMappings)
+			argLine, argDetails := convertArgLine(ctx, line.Arg, d.Lines, stagesWithRunCommands, cc, resolver, opts.FromLineConverter)
 			newLine.Converted = argLine
 			newLine.Arg = argDetails
 		}
 
+		// Handle COPY --from lines that copy from an image rather than a stage
+		if line.Copy != nil {
+			newLine.Copy = &CopyDetails{From: line.Copy.From}
+			if converted, ok := convertCopyFromLine(ctx, line, cc, resolver); ok {
+				newLine.Converted = converted
+			}
+		}
+
 		// Process RUN commands
 		if line.Run != nil && line.Run.Shell != nil && line.Run.Shell.Before != nil {
-			err := processRunLineWithConverter(ctx, newLine, line, stagePackages, mappings.Packages, opts.RunLineConverter, opts.Strict, opts.WarnMissingPackages)
+			err := processRunLineWithConverter(ctx, cc, newLine, line, mappings.Packages, opts.RunLineConverter, opts.Strict, opts.WarnMissingPackages)
 			if err != nil {
 				return nil, err
 			}
+
+			// Handle RUN --mount flags that mount an image rather than a stage
+			if converted, ok := convertRunMountLine(ctx, newLine, cc, resolver); ok {
+				newLine.Converted = converted
+			}
+		}
+
+		// Keep track of the user in effect
+		if user, ok := parseUserDirective(line.Raw); ok {
+			cc.Stage.User = user
 		}
 
 		// Add the converted line to the result
 		converted.Lines[i] = newLine
+		cc.originals[newLine] = i
+	}
+
+	// Move the paths of other distributions to where the converted images have them
+	rewritePaths(cc, converted.Lines, mappings.Paths)
+
+	// Pick a minimal runtime image for a final stage that only copies files from other stages,
+	// unless custom converters decide the images
+	converted.Runtime = analyzeRuntimeStage(cc, d.Lines)
+	if converted.Runtime != nil && opts.ImageResolver == nil && opts.FromLineConverter == nil {
+		applyRuntimeAnalysis(cc, converted.Runtime, converted.Lines, opts.Registry, opts.Organization)
+	}
+
+	// Keep the -dev image of the final stage out of the runtime image
+	if opts.SplitRuntimeStage {
+		converted.Lines = splitRuntimeStage(cc, converted.Lines)
 	}
 
-	// Second pass: add USER root directives where needed
-	addUserRootDirectives(converted.Lines)
+	// Keep commands working on converted images without a shell
+	convertShellForms(cc, converted.Lines, mappings.ImageMetadata)
+
+	// Keep CMD lines running the same command under the entrypoint of the converted images
+	adjustEntrypoints(cc, converted.Lines, mappings.ImageMetadata)
+
+	// Switch to root for package installs and back to the user of each stage
+	finalUsers := addUserDirectives(cc, converted.Lines, mappings.ImageMetadata)
+
+	// Check that the final stage works as the non-root user it runs as
+	checkNonroot(cc, converted.Lines, finalUsers, mappings.ImageMetadata, opts.FixNonroot)
+
+	converted.Diagnostics = cc.diagnostics
 
 	return converted, nil
 }
 
-// detectStagesWithRunCommands identifies which stages contain RUN commands
+// detectStagesWithRunCommands identifies which stages contain RUN commands, directly or in a
+// stage built on top of them, as their image then needs a -dev variant
 func detectStagesWithRunCommands(lines []*DockerfileLine) map[int]bool {
 	stagesWithRunCommands := make(map[int]bool)
 
@@ -641,6 +823,14 @@ func detectStagesWithRunCommands(lines []*DockerfileLine) map[int]bool {
 		}
 	}
 
+	// Parent stages come before the stages built on top of them, so walking the stages backwards
+	// carries the RUN commands up to every ancestor
+	for i := len(lines) - 1; i >= 0; i-- {
+		if from := lines[i].From; from != nil && from.Parent > 0 && stagesWithRunCommands[lines[i].Stage] {
+			stagesWithRunCommands[from.Parent] = true
+		}
+	}
+
 	return stagesWithRunCommands
 }
 
@@ -685,220 +875,103 @@ func copyFromDetails(from *FromDetails) *FromDetails {
 		TagDynamic:  from.TagDynamic,
 		Orig:        from.Orig,
 		Platform:    from.Platform,
+		Version:     from.Version,
+		Variants:    slices.Clone(from.Variants),
+		OS:          from.OS,
 	}
 }
 
-// convertFromLine handles converting a FROM line
-func convertFromLine(from *FromDetails, stage int, stagesWithRunCommands map[int]bool, opts Options) string {
+// convertFromLine handles converting a FROM line, returning the converted line and the image reference it uses
+func convertFromLine(ctx context.Context, from *FromDetails, cc *ConversionContext, resolver ImageResolver, fromLineConverter FromLineConverter) (string, string) {
 	// First, always do the default Chainguard conversion
-	// Determine if we need the -dev suffix
-	needsDevSuffix := stagesWithRunCommands[stage]
-
-	// Get the converted base without tag
-	base := from.Base
-	tag := from.Tag
-
-	// Handle the basename
-	baseFilename := filepath.Base(base)
-
-	// Get the appropriate Chainguard image name using mappings
-	targetImage := baseFilename
-	var convertedTag string
-
-	// Check for exact match first, in specific order
-	// For example, if the mapping is just node, it should match all of the following:
-	// FROM registry-1.docker.io/library/node
-	// FROM docker.io/node
-	// FROM docker.io/library/node
-	// FROM index.docker.io/node
-	// FROM index.docker.io/library/node
-	//
-	// If the mapping is someorg/somerepo, it should match all of the following:
-	// FROM registry-1.docker.io/someorg/somerepo
-	// FROM docker.io/someorg/somerepo
-	// FROM index.docker.io/someorg/somerepo
-	var mappedImage string
-
-	// First check for exact match with full image reference including tag
-	fullImageRef := base
-	if tag != "" {
-		fullImageRef += ":" + tag
-	}
-	if img, ok := opts.ExtraMappings.Images[fullImageRef]; ok {
-		mappedImage = img
-	} else if img, ok := opts.ExtraMappings.Images[base]; ok {
-		mappedImage = img
-	} else if img, ok := opts.ExtraMappings.Images[baseFilename]; ok {
-		mappedImage = img
-	} else {
-		// Generate all possible variants for the base image
-		baseVariants := generateDockerHubVariants(base)
-
-		// Check if any variant matches a key in the mappings
-		for _, variant := range baseVariants {
-			if img, ok := opts.ExtraMappings.Images[variant]; ok {
-				mappedImage = img
-				break
-			}
-		}
-
-		// If still no match, try to normalize the base and check against simple keys
-		if mappedImage == "" {
-			normalizedBase := normalizeImageName(base)
-
-			// Check if the normalized base matches any key
-			if img, ok := opts.ExtraMappings.Images[normalizedBase]; ok {
-				mappedImage = img
-			} else if strings.HasPrefix(normalizedBase, "library/") {
-				// Try removing library/ prefix if it exists
-				simpleBase := strings.TrimPrefix(normalizedBase, "library/")
-				if img, ok := opts.ExtraMappings.Images[simpleBase]; ok {
-					mappedImage = img
-				}
-			}
-		}
-
-		// If still no match, check for glob patterns with asterisks
-		if mappedImage == "" {
-			for pattern, img := range opts.ExtraMappings.Images {
-				if strings.HasSuffix(pattern, "*") {
-					prefix := strings.TrimSuffix(pattern, "*")
-					if strings.HasPrefix(baseFilename, prefix) {
-						mappedImage = img
-						break
-					}
-				}
-			}
-		}
-	}
-
-	// Process the mapped image if found
-	if mappedImage != "" {
-		// Check if the mapped image includes a tag
-		if parts := strings.Split(mappedImage, ":"); len(parts) > 1 {
-			targetImage = parts[0]
-			convertedTag = parts[1]
-		} else {
-			targetImage = mappedImage
-		}
-	}
-
-	// If targetTag is not specified in mapping, calculate it using the existing logic
-	if convertedTag == "" {
-		convertedTag = calculateConvertedTag(targetImage, tag, from.TagDynamic, needsDevSuffix)
+	// The -dev variant is needed if the stage has RUN lines
+	chainguardImageRef, err := resolver.ResolveImage(ctx, ImageReference{
+		Base:     from.Base,
+		Tag:      from.Tag,
+		Digest:   from.Digest,
+		NeedsDev: cc.Stage.HasRun,
+	}, cc)
+	if err != nil {
+		reportResolveError(cc, err,
+			"resolving image %s failed, keeping the original image: %v", from.Orig, err)
+		chainguardImageRef = from.Orig
 	}
 
-	// Build the image reference
-	chainguardImageRef := buildImageReference(targetImage, convertedTag, opts)
-
 	// Now, if a custom converter is provided, let it process the result
-	if opts.FromLineConverter != nil {
-		customImageRef, err := opts.FromLineConverter(from, chainguardImageRef, stagesWithRunCommands[stage])
+	imageRef := chainguardImageRef
+	if fromLineConverter != nil {
+		customImageRef, err := fromLineConverter(from, chainguardImageRef, cc)
 		if err != nil {
 			// If an error occurs, still return a valid FROM line using the original image
-			fromLine := DirectiveFrom
-			if from.Platform != "" {
-				fromLine += " --platform=" + from.Platform
-			}
-			fromLine += " " + from.Orig
-			if from.Alias != "" {
-				fromLine += " " + KeywordAs + " " + from.Alias
-			}
-			return fromLine
-		}
-
-		// Create the converted FROM line with the custom image
-		fromLine := DirectiveFrom
-		if from.Platform != "" {
-			fromLine += " --platform=" + from.Platform
-		}
-		fromLine += " " + customImageRef
-		if from.Alias != "" {
-			fromLine += " " + KeywordAs + " " + from.Alias
+			cc.report(SeverityWarning, DiagnosticFromConverterError,
+				"custom FROM line converter failed for %s, keeping the original image: %v", from.Orig, err)
+			imageRef = from.Orig
+		} else {
+			imageRef = customImageRef
 		}
-		return fromLine
 	}
 
-	// If no custom converter, use the Chainguard converted reference
+	// Create the converted FROM line
 	fromLine := DirectiveFrom
 	if from.Platform != "" {
 		fromLine += " --platform=" + from.Platform
 	}
-	fromLine += " " + chainguardImageRef
+	fromLine += " " + imageRef
 	if from.Alias != "" {
 		fromLine += " " + KeywordAs + " " + from.Alias
 	}
 
-	return fromLine
+	return fromLine, imageRef
 }
 
 // convertArgLine handles converting an ARG line used as base image
-func convertArgLine(arg *ArgDetails, lines []*DockerfileLine, stagesWithRunCommands map[int]bool, opts Options) (string, *ArgDetails) {
+func convertArgLine(ctx context.Context, arg *ArgDetails, lines []*DockerfileLine, stagesWithRunCommands map[int]bool, cc *ConversionContext, resolver ImageResolver, fromLineConverter FromLineConverter) (string, *ArgDetails) {
 	// Create a FromDetails structure from the ARG default value
-	base, tag := parseImageReference(arg.DefaultValue)
+	base, tag, digest := splitImageReference(arg.DefaultValue)
+	version, variants, osVariant := parseTagVariants(tag)
 
 	// Create a FromDetails to represent this ARG value as a FROM line
 	fromDetails := &FromDetails{
-		Base: base,
-		Tag:  tag,
-		Orig: arg.DefaultValue,
-	}
-
-	// Determine if we need the -dev suffix
-	needsDevSuffix := determineIfArgNeedsDevSuffix(arg.Name, lines, stagesWithRunCommands)
-
-	// First perform the default Chainguard conversion
-	// Calculate default image reference using common approach
-	baseFilename := filepath.Base(base)
-
-	// Get the appropriate Chainguard image name using mappings
-	targetImage := baseFilename
-	var convertedTag string
-
-	// Check for exact match first
-	if mappedImage, ok := opts.ExtraMappings.Images[baseFilename]; ok {
-		// Check if the mapped image includes a tag
-		if parts := strings.Split(mappedImage, ":"); len(parts) > 1 {
-			targetImage = parts[0]
-			convertedTag = parts[1]
-		} else {
-			targetImage = mappedImage
-		}
-	} else {
-		// No exact match, check for glob patterns with asterisks
-		for pattern, mappedImage := range opts.ExtraMappings.Images {
-			if strings.HasSuffix(pattern, "*") {
-				prefix := strings.TrimSuffix(pattern, "*")
-				if strings.HasPrefix(baseFilename, prefix) {
-					// Found a match with a glob pattern
-					if parts := strings.Split(mappedImage, ":"); len(parts) > 1 {
-						targetImage = parts[0]
-						convertedTag = parts[1]
-					} else {
-						targetImage = mappedImage
-					}
-					break
-				}
-			}
-		}
-	}
-
-	// If targetTag is not specified in mapping, calculate it using the existing logic
-	if convertedTag == "" {
-		convertedTag = calculateConvertedTag(targetImage, tag, false, needsDevSuffix)
+		Base:     base,
+		Tag:      tag,
+		Digest:   digest,
+		Orig:     arg.DefaultValue,
+		Version:  version,
+		Variants: variants,
+		OS:       osVariant,
+	}
+
+	// First perform the default Chainguard conversion, the same way as for a FROM line
+	chainguardImageRef, err := resolver.ResolveImage(ctx, ImageReference{
+		Base:     base,
+		Tag:      tag,
+		Digest:   digest,
+		NeedsDev: determineIfArgNeedsDevSuffix(arg.Name, lines, stagesWithRunCommands),
+	}, cc)
+	if err != nil {
+		reportResolveError(cc, err,
+			"resolving image %s for ARG %s failed, keeping the original image: %v", arg.DefaultValue, arg.Name, err)
+		chainguardImageRef = arg.DefaultValue
 	}
 
-	// Build the image reference
-	chainguardImageRef := buildImageReference(targetImage, convertedTag, opts)
-
 	// Get the converted image reference
 	var finalImageRef string
 
 	// If a custom FROM line converter is provided, use it
-	if opts.FromLineConverter != nil {
-		customImageRef, err := opts.FromLineConverter(fromDetails, chainguardImageRef, needsDevSuffix)
+	if fromLineConverter != nil {
+		// Let the converter see the stage that uses this ARG as its base
+		argStage := cc.Stage
+		if stage := argBaseStage(arg.Name, lines); stage > 0 {
+			argStage = cc.StageByIndex(stage)
+		}
+		argContext := *cc
+		argContext.Stage = argStage
+
+		customImageRef, err := fromLineConverter(fromDetails, chainguardImageRef, &argContext)
 		if err != nil {
 			// On error, use the original value
+			cc.report(SeverityWarning, DiagnosticFromConverterError,
+				"custom FROM line converter failed for ARG %s, keeping the original image: %v", arg.Name, err)
 			finalImageRef = arg.DefaultValue
 		} else {
 			finalImageRef = customImageRef
@@ -921,29 +994,45 @@ func convertArgLine(arg *ArgDetails, lines []*DockerfileLine, stagesWithRunComma
 	return argLine, argDetails
 }
 
-// determineIfArgNeedsDevSuffix determines if an ARG used as base needs a -dev suffix
+// determineIfArgNeedsDevSuffix determines if an ARG used as base needs a -dev suffix, which is
+// the case when any stage using it as its base has RUN commands
 func determineIfArgNeedsDevSuffix(argName string, lines []*DockerfileLine, stagesWithRunCommands map[int]bool) bool {
 	for _, line := range lines {
-		if line.From != nil && line.From.BaseDynamic &&
-			(strings.Contains(line.From.Base, "${"+argName+"}") ||
-				strings.Contains(line.From.Base, "$"+argName)) {
-			return stagesWithRunCommands[line.Stage]
+		if usesArgAsBase(line, argName) && stagesWithRunCommands[line.Stage] {
+			return true
 		}
 	}
 	return false
 }
 
-// calculateConvertedTag calculates the appropriate tag based on the base image and whether -dev is needed
-func calculateConvertedTag(baseFilename string, tag string, isDynamicTag bool, needsDevSuffix bool) string {
-	var convertedTag string
-
-	// Special case for chainguard-base - always use latest
-	if baseFilename == DefaultChainguardBase {
-		return "latest" // Always use latest tag for chainguard-base, no -dev suffix ever
+// argBaseStage returns the first stage whose FROM uses the given ARG as its base, or 0 if there is none
+func argBaseStage(argName string, lines []*DockerfileLine) int {
+	for _, line := range lines {
+		if usesArgAsBase(line, argName) {
+			return line.Stage
+		}
 	}
+	return 0
+}
+
+// usesArgAsBase reports whether a line is a FROM line using the given ARG as its base
+func usesArgAsBase(line *DockerfileLine, argName string) bool {
+	return line.From != nil && line.From.BaseDynamic &&
+		(strings.Contains(line.From.Base, "${"+argName+"}") ||
+			strings.Contains(line.From.Base, "$"+argName))
+}
+
+// calculateConvertedTag calculates the appropriate tag based on the base image, its tag rule
+// and whether -dev is needed. If the tags available for the image are given, a version that is
+// not available is replaced according to the policy, and a note explaining the change is returned.
+func calculateConvertedTag(baseFilename string, tag string, needsDevSuffix bool, tagRules map[string]compiledTagRule, available []string, policy TagPolicy) (string, string) {
+	var convertedTag, note string
+	rule := lookupTagRule(tagRules, baseFilename)
 
 	// First process the tag normally (including semantic version truncation)
 	switch {
+	case rule.Fixed != "":
+		convertedTag = rule.Fixed
 	case tag == "":
 		convertedTag = "latest"
 	case strings.Contains(tag, "$"):
@@ -951,12 +1040,30 @@ func calculateConvertedTag(baseFilename string, tag string, isDynamicTag bool, n
 		convertedTag = tag
 	default:
 		// Convert the tag normally for static tags
-		convertedTag = convertImageTag(tag, isDynamicTag)
+		convertedTag = convertImageTag(tag, rule)
+
+		// Make sure the version is published
+		if available != nil && convertedTag != "latest" && !slices.Contains(available, rule.Prefix+convertedTag+rule.Suffix) {
+			requested := rule.Prefix + convertedTag + rule.Suffix
+			version, ok := selectAvailableVersion(convertedTag, catalogVersions(available, rule.TagRule), policy)
+			if ok {
+				convertedTag = version
+				version = rule.Prefix + version + rule.Suffix
+			} else {
+				convertedTag, version = "latest", "latest"
+			}
+			note = fmt.Sprintf("%s:%s is not published, using %s:%s instead", baseFilename, requested, baseFilename, version)
+		}
+	}
+
+	// Add the prefix and suffix of the rule to version tags
+	if rule.Fixed == "" && convertedTag != "latest" && convertedTag != "latest-dev" {
+		convertedTag = rule.Prefix + convertedTag + rule.Suffix
 	}
 
-	// Special case for JDK/JRE - prepend "openjdk-" to the tag unless it's "latest" or "latest-dev"
-	if (baseFilename == "jdk" || baseFilename == "jre") && convertedTag != "latest" && convertedTag != "latest-dev" {
-		convertedTag = "openjdk-" + convertedTag
+	// Some images (e.g. chainguard-base) have no -dev variant
+	if rule.Dev != nil && !*rule.Dev {
+		return convertedTag, note
 	}
 
 	// Add -dev suffix if needed
@@ -969,19 +1076,18 @@ func calculateConvertedTag(baseFilename string, tag string, isDynamicTag bool, n
 		convertedTag = DefaultImageTag
 	}
 
-	return convertedTag
+	return convertedTag, note
 }
 
 // buildImageReference builds the full image reference with registry, org, and tag
-func buildImageReference(baseFilename string, tag string, opts Options) string {
+func buildImageReference(baseFilename string, tag string, registry string, org string) string {
 	var newBase string
 
 	// If registry is specified, use registry/basename
-	if opts.Registry != "" {
-		newBase = opts.Registry + "/" + baseFilename
+	if registry != "" {
+		newBase = registry + "/" + baseFilename
 	} else {
 		// Otherwise use DefaultRegistryDomain/org/basename
-		org := opts.Organization
 		if org == "" {
 			org = DefaultOrg
 		}
@@ -996,11 +1102,12 @@ func buildImageReference(baseFilename string, tag string, opts Options) string {
 }
 
 // processRunLineWithConverter handles the conversion of RUN lines but supports a RunLineConverter.
-func processRunLineWithConverter(ctx context.Context, newLine *DockerfileLine, line *DockerfileLine, stagePackages map[int][]string, packageMap PackageMap, runLineConverter RunLineConverter, strict bool, warnMissingPackages bool) error {
+func processRunLineWithConverter(ctx context.Context, cc *ConversionContext, newLine *DockerfileLine, line *DockerfileLine, packageMap PackageMap, runLineConverter RunLineConverter, strict bool, warnMissingPackages bool) error {
 	beforeShell := line.Run.Shell.Before
 
 	// Initialize RunDetails with Before shell
 	newLine.Run = &RunDetails{
+		MountFrom: slices.Clone(line.Run.MountFrom),
 		Shell: &RunDetailsShell{
 			Before: beforeShell,
 		},
@@ -1008,7 +1115,7 @@ func processRunLineWithConverter(ctx context.Context, newLine *DockerfileLine, l
 
 	// First check for package manager commands
 	modifiedPMCommands, distro, manager, packages, mappedPackages, afterShell, err :=
-		convertPackageManagerCommands(ctx, beforeShell, packageMap, strict, warnMissingPackages)
+		convertPackageManagerCommands(ctx, cc, beforeShell, packageMap, strict, warnMissingPackages)
 	if err != nil {
 		return err
 	}
@@ -1017,112 +1124,151 @@ func processRunLineWithConverter(ctx context.Context, newLine *DockerfileLine, l
 	newLine.Run.Packages = packages
 
 	// Add the mapped packages to the stage's package list
-	if len(mappedPackages) > 0 {
-		if _, exists := stagePackages[line.Stage]; !exists {
-			stagePackages[line.Stage] = []string{}
-		}
-		stagePackages[line.Stage] = append(stagePackages[line.Stage], mappedPackages...)
-	}
+	cc.Stage.Packages = append(cc.Stage.Packages, mappedPackages...)
+
+	// Handle distro maintenance commands (locale-gen, update-alternatives, ...)
+	modifiedMaintenanceCommands, afterShell, requiredPackages, env := convertMaintenanceCommands(cc, afterShell)
+
+	modifiedBusyboxCommands, afterShell, busyboxPackages := convertBusyboxCommands(afterShell, cc.Stage.Packages)
+	requiredPackages = append(requiredPackages, busyboxPackages...)
 
-	modifiedBusyboxCommands := false
-	modifiedBusyboxCommands, afterShell = convertBusyboxCommands(afterShell, stagePackages[line.Stage])
+	// Install the packages needed by maintenance commands and the GNU versions of commands
+	// that have no busybox equivalent
+	modifiedStagePackages, afterShell := installStagePackages(cc, afterShell, requiredPackages)
 
 	// Check if we modified anything (related to package managers or useradd/groupadd)
-	modifiedAnything := modifiedPMCommands || modifiedBusyboxCommands
+	modifiedAnything := modifiedPMCommands || modifiedMaintenanceCommands || modifiedBusyboxCommands || modifiedStagePackages
 
 	// If we modified the shell command, set After and Converted
 	if modifiedAnything {
 		newLine.Run.Shell.After = afterShell
-
-		// Extract the original RUN directive from the raw line to preserve case
-		rawLine := line.Raw
-		upperRawLine := strings.ToUpper(rawLine)
-
-		// Find the position of the case-insensitive "RUN " directive
-		runPrefix := DirectiveRun + " "
-		runIndex := strings.Index(upperRawLine, runPrefix)
-
-		var defaultConverted string
-		if runIndex != -1 {
-			// Get the original case of the RUN directive
-			originalRunDirective := rawLine[runIndex : runIndex+len(runPrefix)]
-			defaultConverted = originalRunDirective + afterShell.String()
-		} else {
-			// Fallback if we can't find the directive (shouldn't happen)
-			defaultConverted = DirectiveRun + " " + afterShell.String()
-		}
+		defaultConverted := renderRunLine(line.Raw, afterShell)
 
 		if runLineConverter != nil {
-			custom, err := runLineConverter(newLine.Run, defaultConverted, line.Stage)
+			custom, err := runLineConverter(newLine.Run, defaultConverted, cc)
 			if err != nil {
 				return err
 			}
 			newLine.Converted = custom
 		} else {
 			newLine.Converted = defaultConverted
+
+			// Later lines of the stage may add packages to this line's apk add command
+			if findApkAddPart(afterShell) != nil {
+				cc.Stage.apkLine = newLine
+			}
 		}
 	}
+
+	// Settings replacing the effect of removed maintenance commands (e.g. TZ)
+	if len(env) > 0 {
+		newLine.Appended = append(newLine.Appended, DirectiveEnv+" "+strings.Join(env, " "))
+	}
 	return nil
 }
 
-// addUserRootDirectives adds USER root directives where needed
-func addUserRootDirectives(lines []*DockerfileLine) {
-	// First determine which stages have converted RUN lines
-	stagesWithConvertedRuns := make(map[int]bool)
-	// Also keep track of stages that already have USER root directives
-	stagesWithUserRoot := make(map[int]bool)
+// renderRunLine renders a converted shell command as a RUN line, preserving the case of the
+// RUN directive in the raw line
+func renderRunLine(rawLine string, shell *ShellCommand) string {
+	upperRawLine := strings.ToUpper(rawLine)
 
-	// First pass - identify stages with converted RUN lines and existing USER root directives
-	for _, line := range lines {
-		// Check if this is a converted RUN line with a package manager
-		if line.Run != nil && line.Converted != "" && line.Run.Manager != "" {
-			stagesWithConvertedRuns[line.Stage] = true
-		}
+	// Find the position of the case-insensitive "RUN " directive
+	runPrefix := DirectiveRun + " "
+	runIndex := strings.Index(upperRawLine, runPrefix)
 
-		// Check if this line is a USER directive with root
-		raw := line.Raw
-		converted := line.Converted
+	if runIndex != -1 {
+		// Get the original case of the RUN directive, and keep its flags
+		originalRunDirective := rawLine[runIndex : runIndex+len(runPrefix)]
+		if flags, _ := splitRunFlags(rawLine[runIndex+len(runPrefix):]); len(flags) > 0 {
+			originalRunDirective += strings.Join(flags, " ") + " "
+		}
+		return originalRunDirective + shell.String()
+	}
+	// Fallback if we can't find the directive (shouldn't happen)
+	return DirectiveRun + " " + shell.String()
+}
 
-		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(raw)), DirectiveUser+" ") {
-			// Extract the user name after "USER "
-			userPart := strings.TrimSpace(raw[len(DirectiveUser)+1:])
-			if strings.ToLower(userPart) == DefaultUser {
-				stagesWithUserRoot[line.Stage] = true
-			}
+// installStagePackages makes sure the packages are installed in the stage before the shell command runs.
+// They are added to the apk add command of the shell or, failing that, to the last converted RUN line
+// of the stage that installs packages. Otherwise a new apk add command is prepended to the shell.
+func installStagePackages(cc *ConversionContext, shell *ShellCommand, packages []string) (bool, *ShellCommand) {
+	var missing []string
+	for _, pkg := range packages {
+		if !slices.Contains(cc.Stage.Packages, pkg) && !slices.Contains(missing, pkg) {
+			missing = append(missing, pkg)
 		}
+	}
+	if len(missing) == 0 {
+		return false, shell
+	}
+	cc.Stage.Packages = append(cc.Stage.Packages, missing...)
 
-		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(converted)), DirectiveUser+" ") {
-			// Extract the user name after "USER "
-			userPart := strings.TrimSpace(converted[len(DirectiveUser)+1:])
-			if strings.ToLower(userPart) == DefaultUser {
-				stagesWithUserRoot[line.Stage] = true
-			}
+	// Work on a copy, the shell may still be the original one
+	parts := make([]*ShellPart, 0, len(shell.Parts)+1)
+	for _, part := range shell.Parts {
+		parts = append(parts, cloneShellPart(part))
+	}
+	shell = &ShellCommand{Parts: parts}
+
+	if apkPart := findApkAddPart(shell); apkPart != nil {
+		addApkPackages(apkPart, missing)
+		return true, shell
+	}
+
+	if line := cc.Stage.apkLine; line != nil {
+		// Render from the converted line to keep its converted flags (e.g. RUN --mount images)
+		addApkPackages(findApkAddPart(line.Run.Shell.After), missing)
+		line.Converted = renderRunLine(lineText(line), line.Run.Shell.After)
+		return false, shell
+	}
+
+	apkPart := &ShellPart{
+		Command: string(ManagerApk),
+		Args:    []string{SubcommandAdd, ApkNoCacheFlag},
+	}
+	addApkPackages(apkPart, missing)
+	if len(parts) > 0 {
+		apkPart.ExtraPre = parts[0].ExtraPre
+		apkPart.Delimiter = "&&"
+		parts[0].ExtraPre = ""
+	}
+	shell.Parts = append([]*ShellPart{apkPart}, parts...)
+	return true, shell
+}
+
+// findApkAddPart returns the first apk add command of the shell, if any
+func findApkAddPart(shell *ShellCommand) *ShellPart {
+	if shell == nil {
+		return nil
+	}
+	for _, part := range shell.Parts {
+		if part.Command == string(ManagerApk) && len(part.Args) > 0 && part.Args[0] == SubcommandAdd {
+			return part
 		}
 	}
+	return nil
+}
 
-	// If we found any stages with converted RUN lines, add USER root after the FROM
-	if len(stagesWithConvertedRuns) > 0 {
-		for _, line := range lines {
-			// Check if this is a FROM line in a stage that has converted RUN lines
-			if line.From != nil && stagesWithConvertedRuns[line.Stage] {
-				// Skip parent stages (FROM base AS dependencies)
-				if line.From.Parent > 0 {
-					continue
-				}
-				// If there's no USER root directive in this stage already
-				if !stagesWithUserRoot[line.Stage] {
-					// Add a USER root directive after this FROM line
-					if line.Converted != "" {
-						line.Converted += "\n" + DirectiveUser + " " + DefaultUser
-					} else {
-						line.Converted = line.Raw + "\n" + DirectiveUser + " " + DefaultUser
-					}
-					// Mark this stage as having a USER root directive
-					stagesWithUserRoot[line.Stage] = true
-				}
-			}
+// addApkPackages adds packages to an apk add command, keeping its package list sorted
+func addApkPackages(part *ShellPart, packages []string) {
+	first := len(part.Args)
+	for i := 1; i < len(part.Args); i++ {
+		if !strings.HasPrefix(part.Args[i], "-") {
+			first = i
+			break
 		}
 	}
+	part.Args = append(part.Args, packages...)
+	slices.Sort(part.Args[first:])
+}
+
+// parseUserDirective returns the user set by a USER directive
+func parseUserDirective(raw string) (string, bool) {
+	trimmed := strings.TrimSpace(raw)
+	if !strings.HasPrefix(strings.ToUpper(trimmed), DirectiveUser+" ") {
+		return "", false
+	}
+	return strings.TrimSpace(trimmed[len(DirectiveUser)+1:]), true
 }
 
 // shouldConvertFromLine determines if a FROM line should be converted
@@ -1134,15 +1280,16 @@ func shouldConvertFromLine(from *FromDetails) bool {
 	return true
 }
 
-// convertImageTag returns the converted image tag
-func convertImageTag(tag string, _ bool) string {
+// convertImageTag returns the converted image tag: the tag rule's rewrite is applied, then
+// version tags are truncated to the rule's depth and any other tag becomes "latest"
+func convertImageTag(tag string, rule compiledTagRule) string {
 	if tag == "" {
 		return DefaultImageTag
 	}
 
-	// Remove anything after and including the first hyphen
-	if hyphenIndex := strings.Index(tag, "-"); hyphenIndex != -1 {
-		tag = tag[:hyphenIndex]
+	// Apply the rewrite of the rule (the built-in mappings drop anything after the first hyphen)
+	if rule.match != nil {
+		tag = rule.match.ReplaceAllString(tag, rule.Replace)
 	}
 
 	// If tag has 'v' prefix for semver, remove it
@@ -1150,6 +1297,11 @@ func convertImageTag(tag string, _ bool) string {
 		tag = tag[1:]
 	}
 
+	depth := rule.Depth
+	if depth <= 0 {
+		depth = defaultTagDepth
+	}
+
 	// Check if this is a semver tag (e.g. 1.2.3)
 	semverParts := strings.Split(tag, ".")
 	isSemver := false
@@ -1166,9 +1318,9 @@ func convertImageTag(tag string, _ bool) string {
 		minor, minorErr := strconv.Atoi(semverParts[1])
 		if majorErr == nil && minorErr == nil && major >= 0 && minor >= 0 {
 			isSemver = true
-			// Keep only major.minor for semver tags
-			if len(semverParts) > 2 {
-				tag = fmt.Sprintf("%d.%d", major, minor)
+			// Keep only the first depth components (major.minor by default), normalized as numbers
+			if len(semverParts) > depth {
+				tag = normalizeVersion(semverParts[:depth])
 			}
 		}
 	}
@@ -1181,9 +1333,21 @@ func convertImageTag(tag string, _ bool) string {
 	return tag
 }
 
+// normalizeVersion joins version components, writing the numeric ones without leading zeros
+func normalizeVersion(parts []string) string {
+	normalized := make([]string, len(parts))
+	for i, part := range parts {
+		if n, err := strconv.Atoi(part); err == nil && n >= 0 {
+			part = strconv.Itoa(n)
+		}
+		normalized[i] = part
+	}
+	return strings.Join(normalized, ".")
+}
+
 // convertPackageManagerCommands converts package manager commands in a shell command
 // to the Alpine equivalent (apk add)
-func convertPackageManagerCommands(ctx context.Context, shell *ShellCommand, packageMap PackageMap, strict bool, warnMissingPackages bool) (bool, Distro, Manager, []string, []string, *ShellCommand, error) {
+func convertPackageManagerCommands(ctx context.Context, cc *ConversionContext, shell *ShellCommand, packageMap PackageMap, strict bool, warnMissingPackages bool) (bool, Distro, Manager, []string, []string, *ShellCommand, error) {
 	if shell == nil {
 		return false, "", "", nil, nil, nil, nil
 	}
@@ -1234,7 +1398,7 @@ func convertPackageManagerCommands(ctx context.Context, shell *ShellCommand, pac
 						if !strings.HasPrefix(arg, "-") {
 							packagesDetected = append(packagesDetected, arg)
 							packageSpec := parsePackageSpec(firstPM, arg)
-							packages, err := convertPackage(ctx, packageSpec, distro, packageMap, strict, warnMissingPackages)
+							packages, err := convertPackage(ctx, cc, packageSpec, distro, packageMap, strict, warnMissingPackages)
 							if err != nil {
 								return false, "", "", nil, nil, nil, err
 							}
@@ -1385,24 +1549,34 @@ func cloneShellPart(part *ShellPart) *ShellPart {
 // CommandConverter defines a function type for converting shell commands
 type CommandConverter func(*ShellPart) *ShellPart
 
+// MultiCommandConverter defines a function type for converting a shell command
+// into one or more shell commands
+type MultiCommandConverter func(*ShellPart) []*ShellPart
+
 // CommandHandler represents a handler for a specific command conversion
 type CommandHandler struct {
 	Command             string
 	Converter           CommandConverter
-	SkipIfShadowPresent bool // If true, only convert when shadow is NOT installed
+	MultiConverter      MultiCommandConverter // Used instead of Converter for commands that expand into several commands
+	SkipIfShadowPresent bool                  // If true, only convert when shadow is NOT installed
+	Package             string                // Package providing the GNU version of the command; the command is left as is when it is installed
+	RequiresPackage     func(*ShellPart) bool // Reports whether the command has no busybox equivalent and needs Package
 }
 
-// convertBusyboxCommands converts useradd and groupadd commands to adduser and addgroup and modifies the tar command syntax
-func convertBusyboxCommands(shell *ShellCommand, stagePackages []string) (bool, *ShellCommand) {
+// convertBusyboxCommands converts shadow user management commands (useradd, groupadd, usermod, ...) to their
+// busybox equivalents (adduser, addgroup, ...) and rewrites GNU flags of tar and the commands listed in
+// BusyboxIncompatibilities. It also returns the packages the stage needs for commands that have no
+// busybox equivalent.
+func convertBusyboxCommands(shell *ShellCommand, stagePackages []string) (bool, *ShellCommand, []string) {
 	if shell == nil || len(shell.Parts) == 0 {
-		return false, shell
+		return false, shell, nil
 	}
 
 	// Define command handlers
 	commandHandlers := []CommandHandler{
 		{
 			Command:             CommandUserAdd,
-			Converter:           ConvertUserAddToAddUser,
+			MultiConverter:      ConvertUserAddToBusybox,
 			SkipIfShadowPresent: true,
 		},
 		{
@@ -1411,53 +1585,162 @@ func convertBusyboxCommands(shell *ShellCommand, stagePackages []string) (bool,
 			SkipIfShadowPresent: true,
 		},
 		{
-			Command:   CommandGNUTar,
-			Converter: ConvertGNUTarToBusyboxTar,
+			Command:             CommandUserMod,
+			MultiConverter:      ConvertUserModToBusybox,
+			SkipIfShadowPresent: true,
+		},
+		{
+			Command:             CommandGPasswd,
+			MultiConverter:      ConvertGPasswdToBusybox,
+			SkipIfShadowPresent: true,
+		},
+		{
+			Command:             CommandChPasswd,
+			Converter:           ConvertChPasswdToBusybox,
+			SkipIfShadowPresent: true,
+		},
+		{
+			Command:             CommandPasswd,
+			Converter:           ConvertPasswdToBusybox,
+			SkipIfShadowPresent: true,
+		},
+		{
+			Command:             CommandChsh,
+			Converter:           ConvertChshToBusybox,
+			SkipIfShadowPresent: true,
+		},
+		{
+			Command:         CommandGNUTar,
+			Converter:       ConvertGNUTarToBusyboxTar,
+			Package:         PackageTar,
+			RequiresPackage: RequiresGNUTar,
 		},
 	}
+	commandHandlers = append(commandHandlers, busyboxCompatHandlers()...)
 
 	// Create new shell command to hold the converted parts
-	convertedParts := make([]*ShellPart, len(shell.Parts))
+	convertedParts := make([]*ShellPart, 0, len(shell.Parts))
 	modified := false
-
-	// Check if shadow is installed
-	hasShadow := slices.Contains(stagePackages, PackageShadow)
+	var requiredPackages []string
+	requirePackage := func(pkg string) {
+		if pkg != "" && !slices.Contains(requiredPackages, pkg) {
+			requiredPackages = append(requiredPackages, pkg)
+		}
+	}
 
 	// Process each shell part
-	for i, part := range shell.Parts {
-		converted := false
-
-		// Try each handler in the registry
-		for _, handler := range commandHandlers {
-			// Skip if this handler requires shadow checking and shadow is installed
-			if handler.SkipIfShadowPresent && hasShadow {
-				continue
-			}
-
-			// Check if this command matches
-			if part.Command == handler.Command {
-				convertedPart := handler.Converter(part)
-				// Check if conversion actually changed anything
-				if convertedPart.Command != part.Command || !slices.Equal(convertedPart.Args, part.Args) {
-					convertedParts[i] = convertedPart
-					modified = true
-					converted = true
-					break
+	for _, part := range shell.Parts {
+		var result []*ShellPart
+
+		// Commands in a pipeline (e.g. "echo user:pass | chpasswd") are converted one segment at a time
+		segments := splitPipeline(part)
+		if len(segments) > 1 {
+			pipelineModified := false
+			for i, segment := range segments {
+				parts, pkg := applyCommandHandlers(commandHandlers, segment, stagePackages)
+				requirePackage(pkg)
+				if len(parts) == 1 {
+					segments[i] = parts[0]
+					pipelineModified = true
 				}
 			}
+			if pipelineModified {
+				result = []*ShellPart{joinPipeline(segments)}
+			}
+		} else {
+			var pkg string
+			result, pkg = applyCommandHandlers(commandHandlers, part, stagePackages)
+			requirePackage(pkg)
 		}
 
 		// If no conversion was applied, copy the original part
-		if !converted {
-			convertedParts[i] = cloneShellPart(part)
+		if result == nil {
+			convertedParts = append(convertedParts, cloneShellPart(part))
+			continue
 		}
+		convertedParts = append(convertedParts, result...)
+		modified = true
 	}
 
 	if modified {
-		return true, &ShellCommand{Parts: convertedParts}
+		return true, &ShellCommand{Parts: convertedParts}, requiredPackages
 	}
 
-	return false, shell
+	return false, shell, requiredPackages
+}
+
+// applyCommandHandlers runs the first matching handler on the shell part. It returns nil
+// if no handler matched or the conversion did not change anything. When the command has
+// no busybox equivalent, it is left as is and the package it needs is returned instead.
+func applyCommandHandlers(commandHandlers []CommandHandler, part *ShellPart, stagePackages []string) ([]*ShellPart, string) {
+	// Check if shadow is installed
+	hasShadow := slices.Contains(stagePackages, PackageShadow)
+
+	for _, handler := range commandHandlers {
+		// Skip if this handler requires shadow checking and shadow is installed
+		if handler.SkipIfShadowPresent && hasShadow {
+			continue
+		}
+
+		// Check if this command matches
+		if part.Command != handler.Command {
+			continue
+		}
+
+		// The GNU version of the command is installed, nothing to convert
+		if handler.Package != "" && slices.Contains(stagePackages, handler.Package) {
+			return nil, ""
+		}
+		if handler.RequiresPackage != nil && handler.RequiresPackage(part) {
+			return nil, handler.Package
+		}
+
+		var convertedParts []*ShellPart
+		if handler.MultiConverter != nil {
+			convertedParts = handler.MultiConverter(part)
+		} else {
+			convertedParts = []*ShellPart{handler.Converter(part)}
+		}
+
+		// Check if conversion actually changed anything
+		if len(convertedParts) != 1 || convertedParts[0].Command != part.Command || !slices.Equal(convertedParts[0].Args, part.Args) {
+			return convertedParts, ""
+		}
+	}
+	return nil, ""
+}
+
+// splitPipeline splits a shell part on unquoted "|" tokens into one part per command
+func splitPipeline(part *ShellPart) []*ShellPart {
+	segments := []*ShellPart{{ExtraPre: part.ExtraPre, Command: part.Command}}
+	for i := 0; i < len(part.Args); i++ {
+		arg := part.Args[i]
+		if arg == "|" && i+1 < len(part.Args) {
+			segments = append(segments, &ShellPart{Command: part.Args[i+1]})
+			i++
+			continue
+		}
+		current := segments[len(segments)-1]
+		current.Args = append(current.Args, arg)
+	}
+	segments[len(segments)-1].Delimiter = part.Delimiter
+	return segments
+}
+
+// joinPipeline is the inverse of splitPipeline
+func joinPipeline(segments []*ShellPart) *ShellPart {
+	joined := &ShellPart{
+		ExtraPre:  segments[0].ExtraPre,
+		Command:   segments[0].Command,
+		Delimiter: segments[len(segments)-1].Delimiter,
+	}
+	for i, segment := range segments {
+		if i > 0 {
+			joined.Args = append(joined.Args, "|", segment.Command)
+		}
+		joined.Args = append(joined.Args, segment.Args...)
+	}
+	return joined
 }
 
 // generateDockerHubVariants generates all possible Docker Hub variants for a given base
@@ -1592,12 +1875,10 @@ func parsePackageSpec(manager Manager, packageArg string) (spec PackageSpec) {
 }
 
 // convertPackage performs a lookup of a given package in the package map and returns a valid apk package parameter.
-func convertPackage(ctx context.Context, spec PackageSpec, distro Distro, packageMap PackageMap, strict bool, warnMissingPackages bool) ([]string, error) {
-	var packages []string
+func convertPackage(ctx context.Context, cc *ConversionContext, spec PackageSpec, distro Distro, packageMap PackageMap, strict bool, warnMissingPackages bool) ([]string, error) {
+	var names []string
 	if distroMap, exists := packageMap[distro]; exists && distroMap[spec.Name] != nil {
-		for _, pkg := range distroMap[spec.Name] {
-			packages = append(packages, createApkPackageSpec(pkg, spec))
-		}
+		names = distroMap[spec.Name]
 	} else if strict {
 		return nil, fmt.Errorf("%s has no mapping", spec.Name)
 	} else {
@@ -1605,7 +1886,19 @@ func convertPackage(ctx context.Context, spec PackageSpec, distro Distro, packag
 			log := clog.FromContext(ctx)
 			log.Warn("Package has no mapping, using original package name", "package", spec.Name, "distro", distro)
 		}
-		packages = append(packages, createApkPackageSpec(spec.Name, spec))
+		names = []string{spec.Name}
+	}
+
+	var packages []string
+	for _, name := range names {
+		// In FIPS mode, the version of the package is kept and its providers are added as is
+		for _, pkg := range fipsPackages(cc, name) {
+			if pkg == name {
+				packages = append(packages, createApkPackageSpec(pkg, spec))
+			} else {
+				packages = append(packages, pkg)
+			}
+		}
 	}
 	return packages, nil
 }
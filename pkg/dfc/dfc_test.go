@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 
@@ -803,10 +804,12 @@ RUN echo hello world
 						Converted: `FROM cgr.dev/ORG/python:3.9`,
 						Stage:     1,
 						From: &FromDetails{
-							Base:   "python",
-							Tag:    "3.9-slim",
-							Digest: "sha256:123456abcdef",
-							Orig:   "python:3.9-slim@sha256:123456abcdef",
+							Base:     "python",
+							Tag:      "3.9-slim",
+							Digest:   "sha256:123456abcdef",
+							Orig:     "python:3.9-slim@sha256:123456abcdef",
+							Version:  "3.9",
+							Variants: []string{"slim"},
 						},
 					},
 				},
@@ -1836,7 +1839,7 @@ RUN apk add --no-cache nano`,
 			name: "JRE with version tag and RUN command",
 			dockerfile: `FROM openjdk:21-jre
 RUN apt-get update && apt-get install -y nano`,
-			expectedOutput: `FROM cgr.dev/ORG/jdk:openjdk-21-dev
+			expectedOutput: `FROM cgr.dev/ORG/jre:openjdk-21-dev
 USER root
 RUN apk add --no-cache nano`,
 		},
@@ -1887,7 +1890,7 @@ RUN echo hello world`
 		t.Fatalf("ParseDockerfile(): %v", err)
 	}
 
-	myRunConverter := func(run *RunDetails, converted string, _ int) (string, error) {
+	myRunConverter := func(run *RunDetails, converted string, _ *ConversionContext) (string, error) {
 		if run.Manager == ManagerAptGet {
 			return "RUN echo 'apt-get is not allowed!'", nil
 		}
@@ -1922,7 +1925,7 @@ RUN apt-get update && apt-get install -y nano`
 		t.Fatalf("ParseDockerfile(): %v", err)
 	}
 
-	errRunConverter := func(_ *RunDetails, _ string, _ int) (string, error) {
+	errRunConverter := func(_ *RunDetails, _ string, _ *ConversionContext) (string, error) {
 		return "", fmt.Errorf("custom run line error")
 	}
 
@@ -1933,6 +1936,236 @@ RUN apt-get update && apt-get install -y nano`
 		t.Errorf("Expected error from RunLineConverter to be propagated, got: %v", err)
 	}
 }
+
+func TestConversionContext(t *testing.T) {
+	dockerfileContent := `ARG BASE=node:20
+FROM python:3.12 AS builder
+RUN apt-get update && apt-get install -y nano
+USER app
+RUN echo hello
+
+FROM builder AS tests
+RUN echo testing
+
+FROM ${BASE}
+COPY --from=builder /app /app`
+
+	ctx := context.Background()
+	dockerfile, err := ParseDockerfile(ctx, []byte(dockerfileContent))
+	if err != nil {
+		t.Fatalf("ParseDockerfile(): %v", err)
+	}
+
+	type runSeen struct {
+		stage    int
+		alias    string
+		from     string
+		packages []string
+		user     string
+		line     int
+	}
+	var runs []runSeen
+	fromStages := map[string]int{}
+
+	_, err = dockerfile.Convert(ctx, Options{
+		ExtraMappings: MappingsConfig{
+			Packages: PackageMap{DistroDebian: {"nano": {"nano"}}},
+		},
+		FromLineConverter: func(from *FromDetails, converted string, cc *ConversionContext) (string, error) {
+			fromStages[from.Orig] = cc.Stage.Index
+			if cc.Stage.HasRun != (cc.Stage.Index != 3) {
+				t.Errorf("unexpected HasRun %t for stage %d", cc.Stage.HasRun, cc.Stage.Index)
+			}
+			return converted, nil
+		},
+		RunLineConverter: func(_ *RunDetails, converted string, cc *ConversionContext) (string, error) {
+			runs = append(runs, runSeen{
+				stage:    cc.Stage.Index,
+				alias:    cc.Stage.Alias,
+				from:     cc.Stage.ConvertedFrom,
+				packages: slices.Clone(cc.Stage.Packages),
+				user:     cc.Stage.User,
+				line:     cc.Line,
+			})
+			return converted, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("dockerfile.Convert(): %v", err)
+	}
+
+	want := []runSeen{{
+		stage:    1,
+		alias:    "builder",
+		from:     "cgr.dev/ORG/python:3.12-dev",
+		packages: []string{"nano"},
+		line:     3,
+	}}
+	if diff := cmp.Diff(want, runs, cmp.AllowUnexported(runSeen{})); diff != "" {
+		t.Errorf("RunLineConverter context not as expected (-want, +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(map[string]int{"python:3.12": 1, "node:20": 3}, fromStages); diff != "" {
+		t.Errorf("FromLineConverter stages not as expected (-want, +got):\n%s", diff)
+	}
+}
+
+func TestConversionContextInheritsParentStage(t *testing.T) {
+	dockerfileContent := `FROM python:3.12 AS builder
+RUN apt-get update && apt-get install -y nano
+USER app
+
+FROM builder AS tests
+RUN apt-get install -y vim`
+
+	ctx := context.Background()
+	dockerfile, err := ParseDockerfile(ctx, []byte(dockerfileContent))
+	if err != nil {
+		t.Fatalf("ParseDockerfile(): %v", err)
+	}
+
+	var got *StageContext
+	_, err = dockerfile.Convert(ctx, Options{
+		RunLineConverter: func(_ *RunDetails, converted string, cc *ConversionContext) (string, error) {
+			if cc.Stage.Index == 2 {
+				got = cc.Stage
+				if parent := cc.LookupStage("BUILDER"); parent == nil || parent.Index != 1 {
+					t.Errorf("LookupStage(BUILDER) = %v, want stage 1", parent)
+				}
+				if first := cc.LookupStage("0"); first == nil || first.Index != 1 {
+					t.Errorf("LookupStage(0) = %v, want stage 1", first)
+				}
+			}
+			return converted, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("dockerfile.Convert(): %v", err)
+	}
+	if got == nil {
+		t.Fatal("RunLineConverter was not called for stage 2")
+	}
+	if got.User != "app" {
+		t.Errorf("User = %q, want %q", got.User, "app")
+	}
+	if got.ConvertedFrom != "cgr.dev/ORG/python:3.12-dev" {
+		t.Errorf("ConvertedFrom = %q, want the parent stage image", got.ConvertedFrom)
+	}
+	if diff := cmp.Diff([]string{"nano", "vim"}, got.Packages); diff != "" {
+		t.Errorf("Packages not as expected (-want, +got):\n%s", diff)
+	}
+}
+
+func TestDevPropagatesToParentStages(t *testing.T) {
+	testCases := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{
+			name: "parent of a stage with RUN lines",
+			raw: `FROM node:20 AS base
+COPY package.json .
+FROM base AS build
+RUN npm ci`,
+			expected: `FROM cgr.dev/ORG/node:20-dev AS base
+COPY package.json .
+FROM base AS build
+RUN npm ci`,
+		},
+		{
+			name: "every ancestor of a stage with RUN lines",
+			raw: `FROM python:3.12 AS base
+FROM base AS deps
+COPY requirements.txt .
+FROM deps AS test
+RUN pip install pytest
+FROM python:3.12
+COPY --from=deps requirements.txt .`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev AS base
+FROM base AS deps
+COPY requirements.txt .
+FROM deps AS test
+RUN pip install pytest
+FROM cgr.dev/ORG/python:3.12
+COPY --from=deps requirements.txt .`,
+		},
+		{
+			name: "ARG used as the base of a parent stage",
+			raw: `ARG BASE=node:20
+FROM ${BASE} AS base
+FROM base AS build
+RUN npm ci`,
+			expected: `ARG BASE=cgr.dev/ORG/node:20-dev
+FROM ${BASE} AS base
+FROM base AS build
+RUN npm ci`,
+		},
+		{
+			name: "ARG used as the base of several stages",
+			raw: `ARG BASE=node:20
+FROM ${BASE} AS runtime
+FROM ${BASE} AS build
+RUN npm ci`,
+			expected: `ARG BASE=cgr.dev/ORG/node:20-dev
+FROM ${BASE} AS runtime
+FROM ${BASE} AS build
+RUN npm ci`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("ParseDockerfile(): %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{Organization: "ORG"})
+			if err != nil {
+				t.Fatalf("Convert(): %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFromLineConverterErrorDiagnostic(t *testing.T) {
+	dockerfileContent := `FROM node:20 AS web
+RUN echo hello`
+
+	ctx := context.Background()
+	dockerfile, err := ParseDockerfile(ctx, []byte(dockerfileContent))
+	if err != nil {
+		t.Fatalf("ParseDockerfile(): %v", err)
+	}
+
+	converted, err := dockerfile.Convert(ctx, Options{
+		FromLineConverter: func(_ *FromDetails, _ string, _ *ConversionContext) (string, error) {
+			return "", fmt.Errorf("registry unavailable")
+		},
+	})
+	if err != nil {
+		t.Fatalf("dockerfile.Convert(): %v", err)
+	}
+
+	if got := converted.Lines[0].Converted; got != "FROM node:20 AS web" {
+		t.Errorf("Expected the original image to be kept, got: %s", got)
+	}
+	if len(converted.Diagnostics) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got %d: %v", len(converted.Diagnostics), converted.Diagnostics)
+	}
+	diag := converted.Diagnostics[0]
+	if diag.Code != DiagnosticFromConverterError || diag.Line != 1 || diag.Stage != 1 || diag.Severity != SeverityWarning {
+		t.Errorf("Unexpected diagnostic: %+v", diag)
+	}
+	if !strings.Contains(diag.Message, "registry unavailable") {
+		t.Errorf("Expected diagnostic to include the converter error, got: %s", diag.Message)
+	}
+}
+
 func TestStrictMode(t *testing.T) {
 	convertTests := []struct {
 		name    string
@@ -2173,7 +2406,7 @@ func TestConvertPackage(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ctx := context.Background()
-			got, err := convertPackage(ctx, tt.args.spec, tt.args.distro, pm, false, false)
+			got, err := convertPackage(ctx, nil, tt.args.spec, tt.args.distro, pm, false, false)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -2274,6 +2507,8 @@ func TestPlatformFlagParsing(t *testing.T) {
 				Alias:    "build",
 				Orig:     "golang:1.23.8-bookworm",
 				Platform: "linux/amd64",
+				Version:  "1.23.8",
+				OS:       "bookworm",
 			},
 		},
 		{
@@ -2294,6 +2529,7 @@ func TestPlatformFlagParsing(t *testing.T) {
 				Tag:      "18",
 				Orig:     "nodejs:18",
 				Platform: "$ARCH",
+				Version:  "18",
 			},
 		},
 		{
@@ -2381,3 +2617,108 @@ func TestPlatformFlagPreservedInConversion(t *testing.T) {
 		})
 	}
 }
+
+func TestCalculateConvertedTagRules(t *testing.T) {
+	noDev := false
+	rules := map[string]TagRule{
+		"*":               {Match: "-.*$"},
+		"chainguard-base": {Fixed: "latest", Dev: &noDev},
+		"jdk":             {Match: "-.*$", Prefix: "openjdk-"},
+		"node*":           {Match: "-.*$", Depth: 1},
+		"node-lts":        {Fixed: "lts"},
+		"postgres":        {Match: `^(\d+)\.(\d+)-.*$`, Replace: "$1.$2", Depth: 3},
+		"keep-suffix":     {Suffix: "-slim"},
+	}
+
+	tests := []struct {
+		name     string
+		image    string
+		tag      string
+		dev      bool
+		expected string
+	}{
+		{name: "default rule drops hyphen suffix", image: "python", tag: "3.12.1-slim-bookworm", expected: "3.12"},
+		{name: "default rule with dev", image: "python", tag: "3.12-slim", dev: true, expected: "3.12-dev"},
+		{name: "fixed tag without dev", image: "chainguard-base", tag: "22.04", dev: true, expected: "latest"},
+		{name: "prefix", image: "jdk", tag: "17-jdk-slim", expected: "openjdk-17"},
+		{name: "prefix is not added to latest", image: "jdk", tag: "", dev: true, expected: "latest-dev"},
+		{name: "glob rule with depth", image: "node-alpine", tag: "20.11.1-bullseye", expected: "20"},
+		{name: "exact rule wins over glob", image: "node-lts", tag: "20", dev: true, expected: "lts-dev"},
+		{name: "regex rewrite with groups and depth", image: "postgres", tag: "16.2-bookworm", expected: "16.2"},
+		{name: "depth keeps patch version", image: "postgres", tag: "16.2.1", expected: "16.2.1"},
+		{name: "suffix", image: "keep-suffix", tag: "1.2.3", dev: true, expected: "1.2-slim-dev"},
+		{name: "dynamic tag", image: "python", tag: "${PY_VERSION}", expected: "${PY_VERSION}"},
+	}
+
+	compiled, err := compileTagRules(rules)
+	if err != nil {
+		t.Fatalf("compileTagRules() error = %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := calculateConvertedTag(tt.image, tt.tag, tt.dev, compiled, nil, "")
+			if got != tt.expected {
+				t.Errorf("calculateConvertedTag(%q, %q) = %q, want %q", tt.image, tt.tag, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestInvalidTagRule(t *testing.T) {
+	mappings := MappingsConfig{Tags: map[string]TagRule{"python": {Match: "-(.*$"}}}
+	if _, err := NewDefaultImageResolver(mappings, "", "ORG"); err == nil {
+		t.Error("NewDefaultImageResolver() error = nil, want error for the invalid match")
+	}
+}
+
+func TestTagRulesFromMappings(t *testing.T) {
+	ctx := context.Background()
+	parsed, err := ParseDockerfile(ctx, []byte("FROM openjdk:17-jdk-slim\nFROM myorg/tool:v2.4.1-rc1"))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	converted, err := parsed.Convert(ctx, Options{
+		ExtraMappings: MappingsConfig{
+			Images: map[string]string{"myorg/tool": "tool"},
+			Tags: map[string]TagRule{
+				"tool": {Match: `-rc\d+$`, Prefix: "v", Depth: 3},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to convert Dockerfile: %v", err)
+	}
+
+	expected := "FROM cgr.dev/ORG/jdk:openjdk-17\nFROM cgr.dev/ORG/tool:v2.4.1"
+	if diff := cmp.Diff(expected, strings.TrimSpace(converted.String())); diff != "" {
+		t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+	}
+}
+
+func TestDefaultTagRulesWithoutBuiltIn(t *testing.T) {
+	ctx := context.Background()
+	parsed, err := ParseDockerfile(ctx, []byte("FROM python:3.12-slim\nFROM openjdk:17\nFROM ubuntu:22.04\nFROM node:020.01.3-alpine"))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	converted, err := parsed.Convert(ctx, Options{
+		NoBuiltIn: true,
+		ExtraMappings: MappingsConfig{
+			Images: map[string]string{
+				"python":  "python",
+				"openjdk": "jdk",
+				"ubuntu":  "chainguard-base",
+				"node":    "node",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to convert Dockerfile: %v", err)
+	}
+
+	expected := "FROM cgr.dev/ORG/python:3.12\nFROM cgr.dev/ORG/jdk:openjdk-17\nFROM cgr.dev/ORG/chainguard-base:latest\nFROM cgr.dev/ORG/node:20.1"
+	if diff := cmp.Diff(expected, strings.TrimSpace(converted.String())); diff != "" {
+		t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"encoding/json"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Directives that decide the command a container runs
+const (
+	DirectiveCmd        = "CMD"
+	DirectiveEntrypoint = "ENTRYPOINT"
+)
+
+// stageCommand is how a stage decides the command of its containers
+type stageCommand struct {
+	entrypoint bool   // The stage or one of its parents sets ENTRYPOINT
+	workdir    bool   // The stage or one of its parents sets WORKDIR
+	image      string // Name of the Chainguard image the stage is built on, empty if not converted
+}
+
+// adjustEntrypoints keeps the CMD lines of stages that set no ENTRYPOINT running the same command
+// on converted images that have one. Chainguard language images often use the runtime as their
+// entrypoint, so CMD ["python", "app.py"] would run python python app.py: a CMD starting with the
+// entrypoint loses it, and any other CMD gets an ENTRYPOINT [] that resets the entrypoint.
+func adjustEntrypoints(cc *ConversionContext, lines []*DockerfileLine, metadata map[string]ImageMetadata) {
+	commands := make(map[int]stageCommand)
+
+	for start := 0; start < len(lines); {
+		end := start + 1
+		for end < len(lines) && lines[end].From == nil {
+			end++
+		}
+		if from := lines[start].From; from != nil {
+			var command stageCommand
+			if from.Parent > 0 {
+				command = commands[from.Parent]
+			} else if imageRef := cc.convertedImage(lines[start]); imageRef != "" {
+				base, _, _ := splitImageReference(imageRef)
+				command.image = filepath.Base(base)
+			}
+			for _, line := range lines[start:end] {
+				switch directive, _ := splitDirective(lineInstruction(line)); directive {
+				case DirectiveEntrypoint:
+					command.entrypoint = true
+				case "WORKDIR":
+					command.workdir = true
+				}
+			}
+			if command.image != "" {
+				adjustStageEntrypoint(cc, lines, start, end, &command, metadata[command.image])
+			}
+			commands[lines[start].Stage] = command
+		}
+		start = end
+	}
+}
+
+// adjustStageEntrypoint adjusts the CMD lines of the stage made of lines[start:end]
+func adjustStageEntrypoint(cc *ConversionContext, lines []*DockerfileLine, start, end int, command *stageCommand, image ImageMetadata) {
+	for i := start; i < end; i++ {
+		line := lines[i]
+		directive, args := splitDirective(lineText(line))
+		if directive != DirectiveCmd {
+			continue
+		}
+		cc.enterConvertedLine(line)
+		exec, isExec := parseExecForm(args)
+
+		if !command.entrypoint && len(image.Entrypoint) > 0 {
+			entrypoint := strings.Join(image.Entrypoint, " ")
+			switch {
+			case isExec && len(exec) > 0 && fileExtRegexp.MatchString(exec[0]):
+				// A CMD starting with a script like "index.js" already passes arguments to an entrypoint
+			case isExec && hasEntrypointPrefix(exec, image.Entrypoint):
+				removed := strings.Join(exec[:len(image.Entrypoint)], " ")
+				exec = exec[len(image.Entrypoint):]
+				line.Converted = DirectiveCmd + " " + renderExecForm(exec)
+				cc.report(SeverityInfo, DiagnosticEntrypointAdjusted,
+					"removed %s from CMD as it is the entrypoint of %s", removed, command.image)
+			default:
+				line.Converted = DirectiveEntrypoint + " []\n" + lineText(line)
+				command.entrypoint = true
+				cc.report(SeverityInfo, DiagnosticEntrypointAdjusted,
+					"added ENTRYPOINT [] so that CMD does not run as arguments of %s, the entrypoint of %s", entrypoint, command.image)
+			}
+		}
+
+		if !command.workdir && image.Workdir != "" && image.Workdir != "/" && slices.ContainsFunc(exec, isRelativePath) {
+			cc.report(SeverityInfo, DiagnosticImageWorkdir,
+				"CMD uses relative paths and runs in %s, the working directory of %s, as no WORKDIR is set", image.Workdir, command.image)
+		}
+	}
+}
+
+// parseExecForm returns the arguments of an instruction in exec form, e.g. ["python", "app.py"]
+func parseExecForm(args string) ([]string, bool) {
+	args = strings.TrimSpace(strings.ReplaceAll(args, "\\\n", " "))
+	if !strings.HasPrefix(args, "[") {
+		return nil, false
+	}
+	var exec []string
+	if err := json.Unmarshal([]byte(args), &exec); err != nil {
+		return nil, false
+	}
+	return exec, true
+}
+
+// renderExecForm renders arguments in exec form
+func renderExecForm(exec []string) string {
+	quoted := make([]string, 0, len(exec))
+	for _, arg := range exec {
+		b, _ := json.Marshal(arg)
+		quoted = append(quoted, string(b))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// hasEntrypointPrefix reports whether a command starts with the entrypoint, comparing the
+// executable by name so that "python" matches "/usr/bin/python"
+func hasEntrypointPrefix(exec, entrypoint []string) bool {
+	if len(exec) < len(entrypoint) || path.Base(exec[0]) != path.Base(entrypoint[0]) {
+		return false
+	}
+	return slices.Equal(exec[1:len(entrypoint)], entrypoint[1:])
+}
+
+// isRelativePath reports whether an argument looks like a relative file path, e.g. "app.py"
+func isRelativePath(arg string) bool {
+	if arg == "" || path.IsAbs(arg) || strings.ContainsAny(arg, ":$=") || strings.HasPrefix(arg, "-") {
+		return false
+	}
+	return strings.Contains(arg, "/") || fileExtRegexp.MatchString(arg)
+}
+
+// fileExtRegexp matches names with a file extension, e.g. "app.py" but not "python3.12"
+var fileExtRegexp = regexp.MustCompile(`^[\w.-]+\.[a-zA-Z]+$`)
@@ -0,0 +1,126 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAdjustEntrypoints(t *testing.T) {
+	testCases := []struct {
+		name        string
+		raw         string
+		expected    string
+		diagnostics []string
+	}{
+		{
+			name: "CMD starting with the entrypoint",
+			raw: `FROM python:3.12
+CMD ["python", "-m", "http.server"]`,
+			expected: `FROM cgr.dev/ORG/python:3.12
+CMD ["-m", "http.server"]`,
+			diagnostics: []string{"removed python from CMD as it is the entrypoint of python"},
+		},
+		{
+			name: "CMD starting with the full path of the entrypoint",
+			raw: `FROM node:20
+CMD ["/usr/bin/node", "server.js"]`,
+			expected: `FROM cgr.dev/ORG/node:20
+CMD ["server.js"]`,
+			diagnostics: []string{
+				"removed /usr/bin/node from CMD as it is the entrypoint of node",
+				"CMD uses relative paths and runs in /app, the working directory of node, as no WORKDIR is set",
+			},
+		},
+		{
+			name: "CMD running another command",
+			raw: `FROM node:20
+WORKDIR /srv
+CMD ["npm", "start"]`,
+			expected: `FROM cgr.dev/ORG/node:20
+WORKDIR /srv
+ENTRYPOINT []
+CMD ["npm", "start"]`,
+			diagnostics: []string{"added ENTRYPOINT [] so that CMD does not run as arguments of /usr/bin/node, the entrypoint of node"},
+		},
+		{
+			name: "CMD in shell form",
+			raw: `FROM python:3.12
+CMD python app.py --port $PORT`,
+			expected: `FROM cgr.dev/ORG/python:3.12
+ENTRYPOINT []
+CMD python app.py --port $PORT`,
+			diagnostics: []string{"added ENTRYPOINT [] so that CMD does not run as arguments of /usr/bin/python, the entrypoint of python"},
+		},
+		{
+			name: "CMD passing a script to the entrypoint",
+			raw: `FROM node:20
+WORKDIR /srv
+CMD ["index.js"]`,
+			expected: `FROM cgr.dev/ORG/node:20
+WORKDIR /srv
+CMD ["index.js"]`,
+		},
+		{
+			name: "explicit ENTRYPOINT",
+			raw: `FROM python:3.12
+ENTRYPOINT ["python"]
+CMD ["python", "app.py"]`,
+			expected: `FROM cgr.dev/ORG/python:3.12
+ENTRYPOINT ["python"]
+CMD ["python", "app.py"]`,
+		},
+		{
+			name: "ENTRYPOINT inherited from the parent stage",
+			raw: `FROM python:3.12 AS base
+ENTRYPOINT ["/bin/sh", "-c"]
+FROM base
+CMD ["python app.py"]`,
+			expected: `FROM cgr.dev/ORG/python:3.12 AS base
+ENTRYPOINT ["/bin/sh", "-c"]
+FROM base
+CMD ["python app.py"]`,
+		},
+		{
+			name: "image without entrypoint",
+			raw: `FROM debian:bookworm
+CMD ["bash"]`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+CMD ["bash"]`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{Organization: "ORG"})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var diagnostics []string
+			for _, d := range converted.Diagnostics {
+				if d.Code == DiagnosticEntrypointAdjusted || d.Code == DiagnosticImageWorkdir {
+					diagnostics = append(diagnostics, d.Message)
+				}
+			}
+			if diff := cmp.Diff(tc.diagnostics, diagnostics); diff != "" {
+				t.Errorf("diagnostics not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
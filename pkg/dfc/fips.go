@@ -0,0 +1,51 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"strings"
+)
+
+// FIPSMappings describes the FIPS equivalents of Chainguard images and packages, used when
+// converting with Options.FIPS
+type FIPSMappings struct {
+	Images   map[string]string   `yaml:"images,omitempty"`   // Chainguard image name to FIPS image name, e.g. python: python-fips
+	Packages map[string][]string `yaml:"packages,omitempty"` // apk package to its FIPS providers, an empty list when it has none
+}
+
+// fipsSuffix is the suffix of the names of Chainguard FIPS images
+const fipsSuffix = "-fips"
+
+// fipsImage returns the FIPS image name of a Chainguard image, reporting images without one
+func fipsImage(cc *ConversionContext, fips FIPSMappings, image string) string {
+	if strings.HasSuffix(image, fipsSuffix) {
+		return image
+	}
+	if fipsName, ok := fips.Images[image]; ok {
+		return fipsName
+	}
+	cc.report(SeverityError, DiagnosticNoFIPSImage,
+		"%s has no FIPS equivalent, keeping the non-FIPS image", image)
+	return image
+}
+
+// fipsPackages returns the FIPS providers of an apk package when the conversion is in FIPS mode,
+// reporting packages declared without one. Other packages are returned as is.
+func fipsPackages(cc *ConversionContext, pkg string) []string {
+	if cc == nil || cc.fips == nil {
+		return []string{pkg}
+	}
+	providers, ok := cc.fips.Packages[pkg]
+	if !ok {
+		return []string{pkg}
+	}
+	if len(providers) == 0 {
+		cc.report(SeverityError, DiagnosticNoFIPSPackage,
+			"package %s has no FIPS equivalent, keeping it", pkg)
+		return []string{pkg}
+	}
+	return providers
+}
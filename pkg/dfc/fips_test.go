@@ -0,0 +1,101 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFIPSConversion(t *testing.T) {
+	testCases := []struct {
+		name        string
+		raw         string
+		fips        bool
+		extra       MappingsConfig
+		expected    string
+		diagnostics []string
+	}{
+		{
+			name: "FIPS images and packages",
+			raw: `FROM python:3.12
+RUN apt-get update && apt-get install -y openssl libssl-dev=3.0.11 curl`,
+			fips: true,
+			expected: `FROM cgr.dev/ORG/python-fips:3.12-dev
+USER root
+RUN apk add --no-cache curl libssl3=~3.0.11 openssl openssl-config-fipshardened`,
+		},
+		{
+			name:     "FIPS image keeps the tag rules of the image",
+			raw:      `FROM eclipse-temurin:21-jre`,
+			fips:     true,
+			expected: `FROM cgr.dev/ORG/jre-fips:openjdk-21`,
+		},
+		{
+			name:     "without FIPS mode",
+			raw:      `FROM python:3.12`,
+			expected: `FROM cgr.dev/ORG/python:3.12`,
+		},
+		{
+			name:        "image without a FIPS equivalent",
+			raw:         `FROM redis:7`,
+			fips:        true,
+			extra:       MappingsConfig{Images: map[string]string{"redis": "redis"}},
+			expected:    `FROM cgr.dev/ORG/redis:7`,
+			diagnostics: []string{"redis has no FIPS equivalent, keeping the non-FIPS image"},
+		},
+		{
+			name: "package without a FIPS equivalent",
+			raw: `FROM node:20
+RUN apk add gnutls`,
+			fips: true,
+			expected: `FROM cgr.dev/ORG/node-fips:20-dev
+USER root
+RUN apk add --no-cache gnutls`,
+			diagnostics: []string{"package gnutls has no FIPS equivalent, keeping it"},
+		},
+		{
+			name: "custom FIPS mappings",
+			raw:  `FROM redis:7`,
+			fips: true,
+			extra: MappingsConfig{
+				Images: map[string]string{"redis": "redis"},
+				FIPS:   FIPSMappings{Images: map[string]string{"redis": "redis-server-fips"}},
+			},
+			expected: `FROM cgr.dev/ORG/redis-server-fips:7`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{Organization: "ORG", FIPS: tc.fips, ExtraMappings: tc.extra})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var diagnostics []string
+			for _, d := range converted.Diagnostics {
+				if d.Code == DiagnosticNoFIPSImage || d.Code == DiagnosticNoFIPSPackage {
+					diagnostics = append(diagnostics, d.Message)
+				}
+			}
+			if diff := cmp.Diff(tc.diagnostics, diagnostics); diff != "" {
+				t.Errorf("diagnostics not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
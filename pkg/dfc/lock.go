@@ -0,0 +1,247 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// LockfileName is the default name of the lockfile written by "dfc lock"
+const LockfileName = "dfc.lock"
+
+// lockfileVersion is the version of the lockfile format
+const lockfileVersion = 1
+
+// Lockfile records the digest of each image used by a converted Dockerfile
+type Lockfile struct {
+	Version int           `json:"version"`
+	Images  []LockedImage `json:"images"`
+}
+
+// LockedImage is an image reference of a converted Dockerfile and the digest it resolved to
+type LockedImage struct {
+	Image  string `json:"image"`  // Converted image reference, e.g. "cgr.dev/ORG/python:3.12-dev"
+	Digest string `json:"digest"` // Digest of the image, e.g. "sha256:..."
+}
+
+// Lookup returns the digest locked for an image reference
+func (l *Lockfile) Lookup(image string) (string, bool) {
+	for _, locked := range l.Images {
+		if locked.Image == image {
+			return locked.Digest, true
+		}
+	}
+	return "", false
+}
+
+// DigestResolver resolves an image reference to the digest of its manifest
+type DigestResolver interface {
+	ResolveDigest(ctx context.Context, image string) (string, error)
+}
+
+// ConvertedImages returns the image references used by the FROM lines, ARG defaults and
+// COPY --from lines of a converted Dockerfile, without duplicates. References using ARGs
+// and references already pinned to a digest are skipped.
+func ConvertedImages(d *Dockerfile) []string {
+	var images []string
+	for _, line := range d.Lines {
+		image := lineImage(line)
+		if image == "" || strings.Contains(image, "$") || strings.Contains(image, "@") {
+			continue
+		}
+		if !slices.Contains(images, image) {
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// lineImage returns the converted image reference of a line, or an empty string
+func lineImage(line *DockerfileLine) string {
+	if line.Converted == "" {
+		return ""
+	}
+	switch {
+	case line.From != nil:
+		for _, field := range strings.Fields(line.Converted)[1:] {
+			if !strings.HasPrefix(field, "--") {
+				return field
+			}
+		}
+	case line.Arg != nil && line.Arg.UsedAsBase:
+		return line.Arg.DefaultValue
+	case line.Copy != nil:
+		return parseCopyFrom(strings.TrimSpace(line.Converted)[len(DirectiveCopy+" "):])
+	}
+	return ""
+}
+
+// GenerateLockfile resolves the digest of every image of a converted Dockerfile
+func GenerateLockfile(ctx context.Context, d *Dockerfile, resolver DigestResolver) (*Lockfile, error) {
+	lock := &Lockfile{Version: lockfileVersion, Images: []LockedImage{}}
+	for _, image := range ConvertedImages(d) {
+		digest, err := resolver.ResolveDigest(ctx, image)
+		if err != nil {
+			return nil, fmt.Errorf("resolving digest of %s: %w", image, err)
+		}
+		lock.Images = append(lock.Images, LockedImage{Image: image, Digest: digest})
+	}
+	return lock, nil
+}
+
+// CheckLockfile verifies that the lockfile covers every image of a converted Dockerfile and, if
+// a resolver is given, that the images still resolve to the locked digests
+func CheckLockfile(ctx context.Context, d *Dockerfile, lock *Lockfile, resolver DigestResolver) error {
+	var errs []error
+	for _, image := range ConvertedImages(d) {
+		locked, ok := lock.Lookup(image)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s is not in the lockfile", image))
+			continue
+		}
+		if resolver == nil {
+			continue
+		}
+		digest, err := resolver.ResolveDigest(ctx, image)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("resolving digest of %s: %w", image, err))
+			continue
+		}
+		if digest != locked {
+			errs = append(errs, fmt.Errorf("%s is locked to %s but resolves to %s", image, locked, digest))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PinDigests rewrites the image references of a converted Dockerfile to image:tag@digest using
+// the digests of the lockfile. Images missing from the lockfile are left as is.
+func (d *Dockerfile) PinDigests(lock *Lockfile) {
+	for _, line := range d.Lines {
+		image := lineImage(line)
+		if image == "" {
+			continue
+		}
+		digest, ok := lock.Lookup(image)
+		if !ok {
+			continue
+		}
+		pinned := image + "@" + digest
+		switch {
+		case line.From != nil:
+			line.Converted = strings.Replace(line.Converted, " "+image, " "+pinned, 1)
+		case line.Arg != nil:
+			line.Converted = strings.Replace(line.Converted, "="+image, "="+pinned, 1)
+			line.Arg.DefaultValue = pinned
+		case line.Copy != nil:
+			line.Converted = strings.Replace(line.Converted, "--from="+image, "--from="+pinned, 1)
+		}
+	}
+}
+
+// ReadLockfile reads a lockfile
+func ReadLockfile(path string) (*Lockfile, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("reading lockfile: %w", err)
+	}
+	var lock Lockfile
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("unmarshalling lockfile %s: %w", path, err)
+	}
+	if lock.Version != lockfileVersion {
+		return nil, fmt.Errorf("unsupported lockfile version %d in %s", lock.Version, path)
+	}
+	return &lock, nil
+}
+
+// WriteLockfile writes a lockfile
+func WriteLockfile(path string, lock *Lockfile) error {
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling lockfile: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil { //nolint:gosec // The lockfile is meant to be committed
+		return fmt.Errorf("writing lockfile: %w", err)
+	}
+	return nil
+}
+
+// DigestFileResolver resolves digests from a JSON file mapping image references to digests,
+// for use without network access
+type DigestFileResolver struct {
+	Digests map[string]string
+}
+
+// NewDigestFileResolver reads a JSON file such as {"cgr.dev/ORG/python:3.12": "sha256:..."}
+func NewDigestFileResolver(path string) (*DigestFileResolver, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("reading digests file: %w", err)
+	}
+	var digests map[string]string
+	if err := json.Unmarshal(data, &digests); err != nil {
+		return nil, fmt.Errorf("unmarshalling digests file %s: %w", path, err)
+	}
+	return &DigestFileResolver{Digests: digests}, nil
+}
+
+// ResolveDigest returns the digest recorded for the image
+func (r *DigestFileResolver) ResolveDigest(_ context.Context, image string) (string, error) {
+	if digest, ok := r.Digests[image]; ok {
+		return digest, nil
+	}
+	return "", fmt.Errorf("no digest recorded for %s", image)
+}
+
+// Annotations naming the images of an OCI layout
+const (
+	ociRefNameAnnotation      = "org.opencontainers.image.ref.name"
+	containerdImageAnnotation = "io.containerd.image.name"
+)
+
+// OCILayoutDigestResolver resolves digests from the index of a local OCI image layout, for use
+// without network access
+type OCILayoutDigestResolver struct {
+	index ociIndex
+}
+
+// NewOCILayoutDigestResolver reads the index.json of an OCI image layout directory
+func NewOCILayoutDigestResolver(dir string) (*OCILayoutDigestResolver, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return nil, fmt.Errorf("reading OCI layout index: %w", err)
+	}
+	r := &OCILayoutDigestResolver{}
+	if err := json.Unmarshal(data, &r.index); err != nil {
+		return nil, fmt.Errorf("unmarshalling OCI layout index in %s: %w", dir, err)
+	}
+	return r, nil
+}
+
+// ResolveDigest returns the digest of the manifest annotated with the image reference, either in
+// full or by its tag
+func (r *OCILayoutDigestResolver) ResolveDigest(_ context.Context, image string) (string, error) {
+	_, tag, _ := splitImageReference(image)
+	for _, manifest := range r.index.Manifests {
+		if manifest.Annotations[containerdImageAnnotation] == image || manifest.Annotations[ociRefNameAnnotation] == image {
+			return manifest.Digest, nil
+		}
+	}
+	for _, manifest := range r.index.Manifests {
+		if tag != "" && manifest.Annotations[ociRefNameAnnotation] == tag {
+			return manifest.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not in the OCI layout", image)
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const (
+	testDigestPython = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	testDigestNode   = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+func convertForLock(t *testing.T, raw string) *Dockerfile {
+	t.Helper()
+	ctx := context.Background()
+	parsed, err := ParseDockerfile(ctx, []byte(raw))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	converted, err := parsed.Convert(ctx, Options{Organization: "ORG"})
+	if err != nil {
+		t.Fatalf("Failed to convert Dockerfile: %v", err)
+	}
+	return converted
+}
+
+func TestLockfile(t *testing.T) {
+	raw := `ARG BASE=node:20
+FROM ${BASE} AS build
+RUN npm ci
+FROM python:3.12@sha256:abcdef AS final
+COPY --from=build /app /app
+COPY --from=node:20 /usr/local/bin/node /usr/local/bin/node
+FROM ${OTHER}`
+
+	converted := convertForLock(t, raw)
+
+	wantImages := []string{"cgr.dev/ORG/node:20-dev", "cgr.dev/ORG/python:3.12", "cgr.dev/ORG/node:20"}
+	if diff := cmp.Diff(wantImages, ConvertedImages(converted)); diff != "" {
+		t.Fatalf("ConvertedImages() mismatch (-want, +got):\n%s", diff)
+	}
+
+	resolver := &DigestFileResolver{Digests: map[string]string{
+		"cgr.dev/ORG/node:20-dev": testDigestNode,
+		"cgr.dev/ORG/python:3.12": testDigestPython,
+		"cgr.dev/ORG/node:20":     testDigestNode,
+	}}
+	ctx := context.Background()
+	lock, err := GenerateLockfile(ctx, converted, resolver)
+	if err != nil {
+		t.Fatalf("GenerateLockfile() error = %v", err)
+	}
+
+	// Round trip through the file
+	path := filepath.Join(t.TempDir(), LockfileName)
+	if err := WriteLockfile(path, lock); err != nil {
+		t.Fatalf("WriteLockfile() error = %v", err)
+	}
+	lock, err = ReadLockfile(path)
+	if err != nil {
+		t.Fatalf("ReadLockfile() error = %v", err)
+	}
+	if err := CheckLockfile(ctx, converted, lock, resolver); err != nil {
+		t.Errorf("CheckLockfile() error = %v", err)
+	}
+
+	converted.PinDigests(lock)
+	expected := `ARG BASE=cgr.dev/ORG/node:20-dev@` + testDigestNode + `
+FROM ${BASE} AS build
+RUN npm ci
+FROM cgr.dev/ORG/python:3.12@` + testDigestPython + ` AS final
+COPY --from=build /app /app
+COPY --from=cgr.dev/ORG/node:20@` + testDigestNode + ` /usr/bin/node /usr/local/bin/node
+FROM ${OTHER}`
+	if diff := cmp.Diff(expected, strings.TrimSpace(converted.String())); diff != "" {
+		t.Errorf("PinDigests() mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestCheckLockfile(t *testing.T) {
+	converted := convertForLock(t, "FROM python:3.12\nFROM node:20")
+	lock := &Lockfile{Version: lockfileVersion, Images: []LockedImage{
+		{Image: "cgr.dev/ORG/python:3.12", Digest: testDigestPython},
+	}}
+	ctx := context.Background()
+
+	err := CheckLockfile(ctx, converted, lock, nil)
+	if err == nil || !strings.Contains(err.Error(), "cgr.dev/ORG/node:20 is not in the lockfile") {
+		t.Errorf("CheckLockfile() error = %v, want missing image", err)
+	}
+
+	lock.Images = append(lock.Images, LockedImage{Image: "cgr.dev/ORG/node:20", Digest: testDigestNode})
+	resolver := &DigestFileResolver{Digests: map[string]string{
+		"cgr.dev/ORG/python:3.12": testDigestPython,
+		"cgr.dev/ORG/node:20":     testDigestPython,
+	}}
+	err = CheckLockfile(ctx, converted, lock, resolver)
+	if err == nil || !strings.Contains(err.Error(), "cgr.dev/ORG/node:20 is locked to "+testDigestNode) {
+		t.Errorf("CheckLockfile() error = %v, want stale digest", err)
+	}
+}
+
+func TestOCILayoutDigestResolver(t *testing.T) {
+	dir := t.TempDir()
+	index := `{
+  "schemaVersion": 2,
+  "manifests": [
+    {"mediaType": "application/vnd.oci.image.index.v1+json", "digest": "` + testDigestPython + `", "size": 1,
+     "annotations": {"io.containerd.image.name": "cgr.dev/ORG/python:3.12"}},
+    {"mediaType": "application/vnd.oci.image.index.v1+json", "digest": "` + testDigestNode + `", "size": 1,
+     "annotations": {"org.opencontainers.image.ref.name": "20"}}
+  ]
+}`
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), []byte(index), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver, err := NewOCILayoutDigestResolver(dir)
+	if err != nil {
+		t.Fatalf("NewOCILayoutDigestResolver() error = %v", err)
+	}
+	ctx := context.Background()
+	for image, want := range map[string]string{
+		"cgr.dev/ORG/python:3.12": testDigestPython,
+		"cgr.dev/ORG/node:20":     testDigestNode,
+	} {
+		if got, err := resolver.ResolveDigest(ctx, image); err != nil || got != want {
+			t.Errorf("ResolveDigest(%q) = %q, %v, want %q", image, got, err, want)
+		}
+	}
+	if _, err := resolver.ResolveDigest(ctx, "cgr.dev/ORG/go:1.24"); err == nil {
+		t.Error("ResolveDigest() expected an error for a missing image")
+	}
+}
+
+func TestRegistryDigestResolver(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if got := r.URL.Query().Get("scope"); !strings.HasPrefix(got, "repository:org/") || !strings.HasSuffix(got, ":pull") {
+				t.Errorf("token scope = %q", got)
+			}
+			if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "password" {
+				t.Errorf("token request credentials = %q, %q, want those of the Docker config", user, password)
+			}
+			w.Write([]byte(`{"token": "secret"}`))
+		case "/v2/", "/v2/org/python/manifests/3.12":
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Path == "/v2/" {
+				return
+			}
+			if !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+				t.Errorf("Accept = %q", r.Header.Get("Accept"))
+			}
+			w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+			w.Header().Set("Content-Length", "2")
+			w.Header().Set("Docker-Content-Digest", testDigestPython)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	// Credentials of private repositories come from the Docker config
+	dir := t.TempDir()
+	config := `{"auths": {"` + host + `": {"auth": "dXNlcjpwYXNzd29yZA=="}}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	resolver := &RegistryDigestResolver{Client: server.Client(), UserAgent: "dfc/test"}
+	got, err := resolver.ResolveDigest(context.Background(), host+"/org/python:3.12")
+	if err != nil {
+		t.Fatalf("ResolveDigest() error = %v", err)
+	}
+	if got != testDigestPython {
+		t.Errorf("ResolveDigest() = %q, want %q", got, testDigestPython)
+	}
+
+	if _, err := resolver.ResolveDigest(context.Background(), host+"/org/node:20"); err == nil {
+		t.Error("ResolveDigest() expected an error for a missing image")
+	}
+}
@@ -0,0 +1,328 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"slices"
+	"strings"
+)
+
+// Command constants for distro maintenance commands
+const (
+	CommandUpdateCACertificates = "update-ca-certificates"
+	CommandLocaleGen            = "locale-gen"
+	CommandUpdateLocale         = "update-locale"
+	CommandDpkgReconfigure      = "dpkg-reconfigure"
+	CommandDebconfSetSelections = "debconf-set-selections"
+	CommandUpdateAlternatives   = "update-alternatives"
+	CommandSystemctl            = "systemctl"
+	CommandService              = "service"
+	CommandLn                   = "ln"
+)
+
+// Packages used by the distro maintenance conversions
+const (
+	PackageCACertificates    = "ca-certificates"
+	PackageTzdata            = "tzdata"
+	PackageSystemd           = "systemd"
+	PackageGlibcLocalePrefix = "glibc-locale-"
+)
+
+// Paths used by the time zone conversion
+const (
+	zoneinfoDir   = "/usr/share/zoneinfo/"
+	localtimePath = "/etc/localtime"
+)
+
+// MaintenanceResult describes how a distro maintenance command is handled on Wolfi
+type MaintenanceResult struct {
+	Keep     bool         // Keep the original command
+	Parts    []*ShellPart // Commands replacing the original one when not kept, none to remove it
+	Packages []string     // Packages the command needs on Wolfi
+	Env      []string     // ENV settings replacing the effect of the command, as "KEY=value"
+	Severity Severity     // Severity of the diagnostic to report, empty for none
+	Message  string       // Message of the diagnostic to report
+}
+
+// MaintenanceConverter converts a distro maintenance command. It returns nil to leave the
+// command as is.
+type MaintenanceConverter func(part *ShellPart, stagePackages []string) *MaintenanceResult
+
+// MaintenanceConverters maps distro maintenance commands to their converters. ldconfig is not
+// listed since it is part of glibc on Wolfi and works as is.
+var MaintenanceConverters = map[string]MaintenanceConverter{
+	CommandUpdateCACertificates: ConvertUpdateCACertificates,
+	CommandLocaleGen:            ConvertLocaleGen,
+	CommandUpdateLocale:         ConvertUpdateLocale,
+	CommandDpkgReconfigure:      ConvertDpkgReconfigure,
+	CommandDebconfSetSelections: ConvertDebconfSetSelections,
+	CommandUpdateAlternatives:   ConvertUpdateAlternatives,
+	CommandSystemctl:            ConvertSystemctl,
+	CommandService:              ConvertService,
+	CommandLn:                   ConvertZoneinfoLink,
+}
+
+// ConvertUpdateCACertificates makes sure the ca-certificates package, which provides
+// update-ca-certificates on Wolfi, is installed
+func ConvertUpdateCACertificates(_ *ShellPart, _ []string) *MaintenanceResult {
+	return &MaintenanceResult{Keep: true, Packages: []string{PackageCACertificates}}
+}
+
+// ConvertLocaleGen removes locale-gen, Wolfi ships precompiled locales in the glibc-locale-<language>
+// packages. The packages for the locales given as arguments are installed instead.
+func ConvertLocaleGen(part *ShellPart, _ []string) *MaintenanceResult {
+	result := &MaintenanceResult{}
+	for _, arg := range part.Args {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if pkg := glibcLocalePackage(unquote(arg)); pkg != "" && !slices.Contains(result.Packages, pkg) {
+			result.Packages = append(result.Packages, pkg)
+		}
+	}
+	if len(result.Packages) == 0 {
+		result.Severity = SeverityWarning
+		result.Message = "removed locale-gen: install the glibc-locale-<language> package for each locale the image needs"
+		return result
+	}
+	result.Severity = SeverityInfo
+	result.Message = "replaced locale-gen with the " + strings.Join(result.Packages, ", ") + " package(s)"
+	return result
+}
+
+// glibcLocalePackage returns the Wolfi package providing a locale such as "en_US.UTF-8", or an
+// empty string for locales built into glibc
+func glibcLocalePackage(locale string) string {
+	language, _, _ := strings.Cut(locale, "_")
+	language, _, _ = strings.Cut(language, ".")
+	language, _, _ = strings.Cut(language, "@")
+	switch language {
+	case "", "C", "POSIX":
+		return ""
+	}
+	return PackageGlibcLocalePrefix + strings.ToLower(language)
+}
+
+// ConvertUpdateLocale replaces update-locale with ENV settings for the given variables
+func ConvertUpdateLocale(part *ShellPart, _ []string) *MaintenanceResult {
+	result := &MaintenanceResult{Severity: SeverityInfo}
+	for _, arg := range part.Args {
+		if isEnvVarAssignment(arg) {
+			result.Env = append(result.Env, unquote(arg))
+		}
+	}
+	result.Message = "removed update-locale, set the locale with ENV instead"
+	return result
+}
+
+// ConvertDpkgReconfigure removes dpkg-reconfigure for packages that need no configuration on Wolfi
+// (tzdata and locales) and flags it for any other package
+func ConvertDpkgReconfigure(part *ShellPart, _ []string) *MaintenanceResult {
+	var packages []string
+	for i := 0; i < len(part.Args); i++ {
+		arg := part.Args[i]
+		switch {
+		case arg == "-f" || arg == "--frontend" || arg == "-p" || arg == "--priority":
+			i++
+		case strings.HasPrefix(arg, "-"):
+		default:
+			packages = append(packages, arg)
+		}
+	}
+
+	result := &MaintenanceResult{Severity: SeverityInfo, Message: "removed dpkg-reconfigure, it is not needed on Wolfi"}
+	for _, pkg := range packages {
+		switch pkg {
+		case PackageTzdata:
+			result.Packages = append(result.Packages, PackageTzdata)
+		case "locales":
+		default:
+			return &MaintenanceResult{
+				Keep:     true,
+				Severity: SeverityError,
+				Message:  "dpkg-reconfigure " + pkg + " is not available on Wolfi, configure the package directly",
+			}
+		}
+	}
+	return result
+}
+
+// ConvertDebconfSetSelections removes debconf-set-selections, Wolfi packages are not configured through debconf
+func ConvertDebconfSetSelections(_ *ShellPart, _ []string) *MaintenanceResult {
+	return &MaintenanceResult{
+		Severity: SeverityInfo,
+		Message:  "removed debconf-set-selections, Wolfi packages are not configured through debconf",
+	}
+}
+
+// ConvertUpdateAlternatives converts "update-alternatives --install link name path priority" (and its
+// --slave links) to symbolic links. Other actions are flagged.
+func ConvertUpdateAlternatives(part *ShellPart, _ []string) *MaintenanceResult {
+	var parts []*ShellPart
+	for i := 0; i < len(part.Args); i++ {
+		switch part.Args[i] {
+		case "--install":
+			// --install <link> <name> <path> <priority>
+			if i+4 >= len(part.Args) {
+				return unsupportedUpdateAlternatives()
+			}
+			parts = append(parts, symlinkPart(part.Args[i+3], part.Args[i+1]))
+			i += 4
+		case "--slave":
+			// --slave <link> <name> <path>
+			if i+3 >= len(part.Args) {
+				return unsupportedUpdateAlternatives()
+			}
+			parts = append(parts, symlinkPart(part.Args[i+3], part.Args[i+1]))
+			i += 3
+		case "--quiet", "--force":
+		default:
+			return unsupportedUpdateAlternatives()
+		}
+	}
+	if len(parts) == 0 {
+		return unsupportedUpdateAlternatives()
+	}
+	return &MaintenanceResult{
+		Parts:    chainShellParts(part, parts),
+		Severity: SeverityInfo,
+		Message:  "replaced update-alternatives --install with symbolic links",
+	}
+}
+
+// unsupportedUpdateAlternatives flags update-alternatives actions that cannot be converted
+func unsupportedUpdateAlternatives() *MaintenanceResult {
+	return &MaintenanceResult{
+		Keep:     true,
+		Severity: SeverityError,
+		Message:  "update-alternatives is not available on Wolfi, create the symbolic links with ln -sf instead",
+	}
+}
+
+// symlinkPart returns "ln -sf target link"
+func symlinkPart(target, link string) *ShellPart {
+	return &ShellPart{Command: CommandLn, Args: []string{"-sf", target, link}}
+}
+
+// ConvertSystemctl handles systemctl when systemd is not installed in the stage. Commands acting on
+// running services are removed since no service manager runs during the build, the others are flagged.
+func ConvertSystemctl(part *ShellPart, stagePackages []string) *MaintenanceResult {
+	if slices.Contains(stagePackages, PackageSystemd) {
+		return nil
+	}
+	var subcommand string
+	if i := slices.IndexFunc(part.Args, func(arg string) bool { return !strings.HasPrefix(arg, "-") }); i >= 0 {
+		subcommand = part.Args[i]
+	}
+	switch subcommand {
+	case "start", "stop", "restart", "reload", "try-restart", "daemon-reload", "status":
+		return &MaintenanceResult{
+			Severity: SeverityInfo,
+			Message:  "removed systemctl " + subcommand + ", services do not run during the build",
+		}
+	}
+	return &MaintenanceResult{
+		Keep:     true,
+		Severity: SeverityWarning,
+		Message:  "systemctl needs the systemd package, which Chainguard images do not include; run the service as the image entrypoint instead",
+	}
+}
+
+// ConvertService removes service, services started during the build do not outlive the RUN step
+func ConvertService(_ *ShellPart, _ []string) *MaintenanceResult {
+	return &MaintenanceResult{
+		Severity: SeverityInfo,
+		Message:  "removed service, services do not run during the build",
+	}
+}
+
+// ConvertZoneinfoLink replaces "ln -sf /usr/share/zoneinfo/<zone> /etc/localtime" with the tzdata
+// package and a TZ environment variable
+func ConvertZoneinfoLink(part *ShellPart, _ []string) *MaintenanceResult {
+	var operands []string
+	for _, arg := range part.Args {
+		if !strings.HasPrefix(arg, "-") {
+			operands = append(operands, unquote(arg))
+		}
+	}
+	if len(operands) != 2 || operands[1] != localtimePath || !strings.HasPrefix(operands[0], zoneinfoDir) {
+		return nil
+	}
+	zone := strings.TrimPrefix(operands[0], zoneinfoDir)
+	return &MaintenanceResult{
+		Packages: []string{PackageTzdata},
+		Env:      []string{"TZ=" + zone},
+		Severity: SeverityInfo,
+		Message:  "replaced the /etc/localtime link with the tzdata package and ENV TZ=" + zone,
+	}
+}
+
+// convertMaintenanceCommands applies MaintenanceConverters to the shell command, reporting a diagnostic
+// for each command handled. It returns the converted shell, the packages and the ENV settings needed.
+func convertMaintenanceCommands(cc *ConversionContext, shell *ShellCommand) (bool, *ShellCommand, []string, []string) {
+	if shell == nil || len(shell.Parts) == 0 {
+		return false, shell, nil, nil
+	}
+
+	var packages, env []string
+	convertedParts := make([]*ShellPart, 0, len(shell.Parts))
+	modified := false
+
+	for _, part := range shell.Parts {
+		// A command consuming a pipeline (e.g. "echo ... | debconf-set-selections") is removed along with it
+		segments := splitPipeline(part)
+		last := segments[len(segments)-1]
+
+		converter, ok := MaintenanceConverters[last.Command]
+		var result *MaintenanceResult
+		if ok {
+			result = converter(last, cc.Stage.Packages)
+		}
+		if result == nil {
+			convertedParts = append(convertedParts, cloneShellPart(part))
+			continue
+		}
+
+		if result.Severity != "" {
+			cc.report(result.Severity, maintenanceDiagnosticCode(result), "%s", result.Message)
+		}
+		for _, pkg := range result.Packages {
+			if !slices.Contains(packages, pkg) {
+				packages = append(packages, pkg)
+			}
+		}
+		env = append(env, result.Env...)
+
+		switch {
+		case result.Keep:
+			convertedParts = append(convertedParts, cloneShellPart(part))
+		case len(segments) > 1 && len(result.Parts) > 0:
+			// Replacements are only supported outside of pipelines
+			convertedParts = append(convertedParts, cloneShellPart(part))
+		default:
+			convertedParts = append(convertedParts, result.Parts...)
+			modified = true
+		}
+	}
+
+	if !modified {
+		return false, shell, packages, env
+	}
+
+	// The last command must not end with a delimiter
+	if len(convertedParts) > 0 {
+		convertedParts[len(convertedParts)-1].Delimiter = shell.Parts[len(shell.Parts)-1].Delimiter
+	}
+	return true, &ShellCommand{Parts: convertedParts}, packages, env
+}
+
+// maintenanceDiagnosticCode returns the diagnostic code for a maintenance command result
+func maintenanceDiagnosticCode(result *MaintenanceResult) string {
+	if result.Keep {
+		return DiagnosticUnsupportedCommand
+	}
+	return DiagnosticReplacedCommand
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMaintenanceCommandConversion(t *testing.T) {
+	testCases := []struct {
+		name        string
+		raw         string
+		expected    string
+		diagnostics []string
+	}{
+		{
+			name: "time zone link",
+			raw:  `RUN apt-get update && apt-get install -y curl && ln -sf /usr/share/zoneinfo/Europe/Paris /etc/localtime`,
+			expected: `RUN apk add --no-cache curl tzdata
+ENV TZ=Europe/Paris`,
+			diagnostics: []string{DiagnosticReplacedCommand},
+		},
+		{
+			name: "time zone link is kept when a later line adds a package",
+			raw: `RUN apt-get install -y curl && ln -sf /usr/share/zoneinfo/Europe/Paris /etc/localtime
+RUN grep -P foo bar`,
+			expected: `RUN apk add --no-cache curl grep tzdata
+ENV TZ=Europe/Paris
+RUN grep -P foo bar`,
+			diagnostics: []string{DiagnosticReplacedCommand},
+		},
+		{
+			name:        "debconf and dpkg-reconfigure tzdata",
+			raw:         `RUN echo "tzdata tzdata/Areas select Europe" | debconf-set-selections && DEBIAN_FRONTEND=noninteractive dpkg-reconfigure -f noninteractive tzdata`,
+			expected:    `RUN apk add --no-cache tzdata`,
+			diagnostics: []string{DiagnosticReplacedCommand, DiagnosticReplacedCommand},
+		},
+		{
+			name: "locales",
+			raw:  `RUN locale-gen en_US.UTF-8 de_DE.UTF-8 && update-locale LANG=en_US.UTF-8 && echo done`,
+			expected: `RUN apk add --no-cache glibc-locale-de glibc-locale-en && \
+    echo done
+ENV LANG=en_US.UTF-8`,
+			diagnostics: []string{DiagnosticReplacedCommand, DiagnosticReplacedCommand},
+		},
+		{
+			name:        "locale-gen without locales",
+			raw:         `RUN locale-gen && echo done`,
+			expected:    `RUN echo done`,
+			diagnostics: []string{DiagnosticReplacedCommand},
+		},
+		{
+			name:        "service",
+			raw:         `RUN service postgresql start && psql -c 'SELECT 1'`,
+			expected:    `RUN psql -c 'SELECT 1'`,
+			diagnostics: []string{DiagnosticReplacedCommand},
+		},
+		{
+			name:        "update-alternatives install",
+			raw:         `RUN update-alternatives --install /usr/bin/python python /usr/bin/python3 1`,
+			expected:    `RUN ln -sf /usr/bin/python3 /usr/bin/python`,
+			diagnostics: []string{DiagnosticReplacedCommand},
+		},
+		{
+			name:        "update-alternatives set is flagged",
+			raw:         `RUN update-alternatives --set java /usr/lib/jvm/java-17/bin/java`,
+			expected:    `RUN update-alternatives --set java /usr/lib/jvm/java-17/bin/java`,
+			diagnostics: []string{DiagnosticUnsupportedCommand},
+		},
+		{
+			name:        "systemctl enable is flagged",
+			raw:         `RUN systemctl enable nginx`,
+			expected:    `RUN systemctl enable nginx`,
+			diagnostics: []string{DiagnosticUnsupportedCommand},
+		},
+		{
+			name: "systemctl is left alone when systemd is installed",
+			raw:  `RUN apt-get install -y systemd && systemctl enable nginx`,
+			expected: `RUN apk add --no-cache systemd && \
+    systemctl enable nginx`,
+		},
+		{
+			name: "update-ca-certificates",
+			raw:  `RUN update-ca-certificates`,
+			expected: `RUN apk add --no-cache ca-certificates && \
+    update-ca-certificates`,
+		},
+		{
+			name:     "ldconfig works as is",
+			raw:      `RUN ldconfig`,
+			expected: `RUN ldconfig`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var codes []string
+			for _, d := range converted.Diagnostics {
+				codes = append(codes, d.Code)
+			}
+			if diff := cmp.Diff(tc.diagnostics, codes); diff != "" {
+				t.Errorf("diagnostics not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"context"
 	_ "embed"
 	"fmt"
+	"maps"
+	"slices"
 
 	"github.com/chainguard-dev/clog"
 	"gopkg.in/yaml.v3"
@@ -55,6 +57,38 @@ func defaultGetDefaultMappings(ctx context.Context, update bool) (MappingsConfig
 		return mappings, fmt.Errorf("unmarshalling mappings: %w", err)
 	}
 
+	// Mappings downloaded by an older version may predate the tags, tagAliases, mirrors, variants,
+	// fips, imageMetadata and paths sections
+	if (mappings.Tags == nil || mappings.TagAliases == nil || mappings.Mirrors == nil || mappings.Variants == nil ||
+		(mappings.FIPS.Images == nil && mappings.FIPS.Packages == nil) || mappings.ImageMetadata == nil ||
+		(mappings.Paths.Global == nil && mappings.Paths.Images == nil)) && xdgMappings != nil {
+		var builtin MappingsConfig
+		if err := yaml.Unmarshal(builtinMappingsYAMLBytes, &builtin); err != nil {
+			return mappings, fmt.Errorf("unmarshalling builtin mappings: %w", err)
+		}
+		if mappings.Tags == nil {
+			mappings.Tags = builtin.Tags
+		}
+		if mappings.TagAliases == nil {
+			mappings.TagAliases = builtin.TagAliases
+		}
+		if mappings.Mirrors == nil {
+			mappings.Mirrors = builtin.Mirrors
+		}
+		if mappings.Variants == nil {
+			mappings.Variants = builtin.Variants
+		}
+		if mappings.FIPS.Images == nil && mappings.FIPS.Packages == nil {
+			mappings.FIPS = builtin.FIPS
+		}
+		if mappings.ImageMetadata == nil {
+			mappings.ImageMetadata = builtin.ImageMetadata
+		}
+		if mappings.Paths.Global == nil && mappings.Paths.Images == nil {
+			mappings.Paths = builtin.Paths
+		}
+	}
+
 	return mappings, nil
 }
 
@@ -66,6 +100,119 @@ func MergeMappings(base, overlay MappingsConfig) MappingsConfig {
 		Packages: make(PackageMap),
 	}
 
+	// Copy base tag rules, then overlay with extra tag rules
+	for k, v := range base.Tags {
+		if result.Tags == nil {
+			result.Tags = make(map[string]TagRule)
+		}
+		result.Tags[k] = v
+	}
+	for k, v := range overlay.Tags {
+		if result.Tags == nil {
+			result.Tags = make(map[string]TagRule)
+		}
+		result.Tags[k] = v
+	}
+
+	// Copy base variant rules, then overlay with extra variant rules, merging their image mappings
+	for variant, rule := range base.Variants {
+		if result.Variants == nil {
+			result.Variants = make(map[string]VariantRule)
+		}
+		result.Variants[variant] = VariantRule{Images: maps.Clone(rule.Images), Unsupported: rule.Unsupported}
+	}
+	for variant, rule := range overlay.Variants {
+		if result.Variants == nil {
+			result.Variants = make(map[string]VariantRule)
+		}
+		merged := result.Variants[variant]
+		if len(rule.Images) > 0 && merged.Images == nil {
+			merged.Images = make(map[string]string)
+		}
+		maps.Copy(merged.Images, rule.Images)
+		if rule.Unsupported != "" {
+			merged.Unsupported = rule.Unsupported
+		}
+		result.Variants[variant] = merged
+	}
+
+	// Copy base FIPS mappings, then overlay with extra FIPS mappings
+	for k, v := range base.FIPS.Images {
+		if result.FIPS.Images == nil {
+			result.FIPS.Images = make(map[string]string)
+		}
+		result.FIPS.Images[k] = v
+	}
+	for k, v := range overlay.FIPS.Images {
+		if result.FIPS.Images == nil {
+			result.FIPS.Images = make(map[string]string)
+		}
+		result.FIPS.Images[k] = v
+	}
+	for k, v := range base.FIPS.Packages {
+		if result.FIPS.Packages == nil {
+			result.FIPS.Packages = make(map[string][]string)
+		}
+		result.FIPS.Packages[k] = v
+	}
+	for k, v := range overlay.FIPS.Packages {
+		if result.FIPS.Packages == nil {
+			result.FIPS.Packages = make(map[string][]string)
+		}
+		result.FIPS.Packages[k] = v
+	}
+
+	// Copy base image metadata, then overlay with extra image metadata
+	for k, v := range base.ImageMetadata {
+		if result.ImageMetadata == nil {
+			result.ImageMetadata = make(map[string]ImageMetadata)
+		}
+		result.ImageMetadata[k] = v
+	}
+	for k, v := range overlay.ImageMetadata {
+		if result.ImageMetadata == nil {
+			result.ImageMetadata = make(map[string]ImageMetadata)
+		}
+		result.ImageMetadata[k] = v
+	}
+
+	// Copy base path mappings, then overlay with extra path mappings
+	for _, paths := range []PathMappings{base.Paths, overlay.Paths} {
+		if len(paths.Global) > 0 && result.Paths.Global == nil {
+			result.Paths.Global = make(map[string]string)
+		}
+		maps.Copy(result.Paths.Global, paths.Global)
+		for image, imagePaths := range paths.Images {
+			if result.Paths.Images == nil {
+				result.Paths.Images = make(map[string]map[string]string)
+			}
+			if result.Paths.Images[image] == nil {
+				result.Paths.Images[image] = make(map[string]string)
+			}
+			maps.Copy(result.Paths.Images[image], imagePaths)
+		}
+	}
+
+	// Copy base tag aliases, then overlay with extra tag aliases
+	for _, tagAliases := range []map[string]map[string]string{base.TagAliases, overlay.TagAliases} {
+		for image, aliases := range tagAliases {
+			if result.TagAliases == nil {
+				result.TagAliases = make(map[string]map[string]string)
+			}
+			if result.TagAliases[image] == nil {
+				result.TagAliases[image] = make(map[string]string)
+			}
+			maps.Copy(result.TagAliases[image], aliases)
+		}
+	}
+
+	// Mirrors of both, without duplicates
+	for _, mirror := range slices.Concat(base.Mirrors, overlay.Mirrors) {
+		if !slices.Contains(result.Mirrors, mirror) {
+			result.Mirrors = append(result.Mirrors, mirror)
+		}
+	}
+
 	// Copy base images
 	for k, v := range base.Images {
 		result.Images[k] = v
@@ -98,3 +245,47 @@ func MergeMappings(base, overlay MappingsConfig) MappingsConfig {
 
 	return result
 }
+
+// LoadMappings returns the mappings used for a conversion with the given options: the builtin
+// mappings merged with opts.ExtraMappings, or only opts.ExtraMappings when opts.NoBuiltIn is set
+func LoadMappings(ctx context.Context, opts Options) (MappingsConfig, error) {
+	mappings, err := loadMappings(ctx, opts)
+	if err != nil {
+		return mappings, err
+	}
+
+	// Add the mirrors given as options
+	if len(opts.Mirrors) > 0 {
+		mappings = MergeMappings(mappings, MappingsConfig{Mirrors: opts.Mirrors})
+	}
+	return mappings, nil
+}
+
+// loadMappings returns the builtin and extra mappings according to opts.NoBuiltIn
+func loadMappings(ctx context.Context, opts Options) (MappingsConfig, error) {
+	if opts.NoBuiltIn {
+		// Use only ExtraMappings if provided, otherwise empty mappings
+		mappings := opts.ExtraMappings
+		if mappings.Images == nil {
+			mappings.Images = make(map[string]string)
+		}
+		if mappings.Packages == nil {
+			mappings.Packages = make(PackageMap)
+		}
+		return mappings, nil
+	}
+
+	// Load the default mappings
+	defaultMappings, err := defaultGetDefaultMappings(ctx, opts.Update)
+	if err != nil {
+		return MappingsConfig{}, fmt.Errorf("loading default mappings: %w", err)
+	}
+
+	// Merge with the extra mappings if provided
+	if len(opts.ExtraMappings.Images) > 0 || len(opts.ExtraMappings.Packages) > 0 || len(opts.ExtraMappings.Tags) > 0 || len(opts.ExtraMappings.TagAliases) > 0 || len(opts.ExtraMappings.Mirrors) > 0 || len(opts.ExtraMappings.Variants) > 0 ||
+		len(opts.ExtraMappings.FIPS.Images) > 0 || len(opts.ExtraMappings.FIPS.Packages) > 0 || len(opts.ExtraMappings.ImageMetadata) > 0 ||
+		len(opts.ExtraMappings.Paths.Global) > 0 || len(opts.ExtraMappings.Paths.Images) > 0 {
+		return MergeMappings(defaultMappings, opts.ExtraMappings), nil
+	}
+	return defaultMappings, nil
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RegexImageKeyPrefix marks an image mapping key as a regular expression, e.g. "regex:^nodejs[0-9]+$"
+const RegexImageKeyPrefix = "regex:"
+
+// imagePattern is a compiled glob or regex image mapping key
+type imagePattern struct {
+	key       string
+	target    string
+	regex     *regexp.Regexp // nil for glob patterns
+	qualified bool           // the key contains a "/" and is matched against the full image name
+	literals  int            // number of literal characters, used to rank patterns
+}
+
+// matches reports whether the pattern matches the image name
+func (p imagePattern) matches(name string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(name)
+	}
+	ok, _ := path.Match(p.key, name)
+	return ok
+}
+
+// ImageMatcher resolves image references against the images section of MappingsConfig.
+// Matches are ranked by specificity so the same reference always resolves to the same mapping:
+//  1. exact keys, tried on the reference with its tag, as written, by file name and as Docker Hub variants
+//  2. registry-qualified patterns (containing a "/"), matched against the full image name
+//  3. other patterns, matched against the image file name
+//
+// Patterns are globs ("node*", "bitnami/*-exporter") or regular expressions prefixed with
+// RegexImageKeyPrefix. Within a rank, globs come before regular expressions, then the pattern
+// with the most literal characters wins (so "nodejs*" beats "node*"), then the lowest key.
+//
+// Images pulled through a registry mirror or proxy are matched as if pulled from Docker Hub
+// once the mirror prefix is stripped, see StripMirrorPrefix.
+type ImageMatcher struct {
+	images   map[string]string
+	patterns []imagePattern
+	mirrors  []string
+}
+
+// NewImageMatcher compiles the image mappings and mirror prefixes
+func NewImageMatcher(images map[string]string, mirrors []string) (*ImageMatcher, error) {
+	m := &ImageMatcher{images: images}
+
+	for _, mirror := range mirrors {
+		mirror = strings.Trim(mirror, "/")
+		if _, err := path.Match(mirror, ""); err != nil || mirror == "" {
+			return nil, fmt.Errorf("invalid mirror prefix %q", mirror)
+		}
+		m.mirrors = append(m.mirrors, mirror)
+	}
+
+	for key, target := range images {
+		var p imagePattern
+		switch {
+		case strings.HasPrefix(key, RegexImageKeyPrefix):
+			expr := strings.TrimPrefix(key, RegexImageKeyPrefix)
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid image pattern %q: %w", key, err)
+			}
+			prefix, _ := re.LiteralPrefix()
+			p = imagePattern{key: key, target: target, regex: re, qualified: strings.Contains(expr, "/"), literals: len(prefix)}
+		case strings.ContainsAny(key, "*?["):
+			if _, err := path.Match(key, ""); err != nil {
+				return nil, fmt.Errorf("invalid image pattern %q: %w", key, err)
+			}
+			p = imagePattern{key: key, target: target, qualified: strings.Contains(key, "/"), literals: globLiterals(key)}
+		default:
+			continue
+		}
+		m.patterns = append(m.patterns, p)
+	}
+
+	sort.Slice(m.patterns, func(i, j int) bool {
+		a, b := m.patterns[i], m.patterns[j]
+		if a.qualified != b.qualified {
+			return a.qualified
+		}
+		if (a.regex == nil) != (b.regex == nil) {
+			return a.regex == nil
+		}
+		if a.literals != b.literals {
+			return a.literals > b.literals
+		}
+		return a.key < b.key
+	})
+
+	return m, nil
+}
+
+// globLiterals counts the characters of a glob that are not wildcards or character classes
+func globLiterals(glob string) int {
+	n := 0
+	inClass := false
+	for _, c := range glob {
+		switch {
+		case inClass:
+			inClass = c != ']'
+		case c == '[':
+			inClass = true
+		case c != '*' && c != '?':
+			n++
+		}
+	}
+	return n
+}
+
+// Match returns the mapping for an image base (without tag) and its tag
+func (m *ImageMatcher) Match(base, tag string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	base = StripMirrorPrefix(base, m.mirrors)
+	baseFilename := filepath.Base(base)
+
+	// Exact keys, in order
+	// For example, if the mapping is just node, it should match all of the following:
+	// FROM registry-1.docker.io/library/node
+	// FROM docker.io/node
+	// FROM docker.io/library/node
+	// FROM index.docker.io/node
+	// FROM index.docker.io/library/node
+	//
+	// If the mapping is someorg/somerepo, it should match all of the following:
+	// FROM registry-1.docker.io/someorg/somerepo
+	// FROM docker.io/someorg/somerepo
+	// FROM index.docker.io/someorg/somerepo
+	names := imageNames(base)
+	untagged := []string{base, baseFilename}
+	untagged = append(untagged, generateDockerHubVariants(base)...)
+	untagged = append(untagged, names...)
+
+	// Keys with the tag come first
+	var candidates []string
+	if tag != "" {
+		for _, name := range untagged {
+			candidates = append(candidates, name+":"+tag)
+		}
+	}
+	candidates = append(candidates, untagged...)
+	for _, candidate := range candidates {
+		if target, ok := m.images[candidate]; ok {
+			return target, true
+		}
+	}
+
+	// Patterns, most specific first
+	for _, p := range m.patterns {
+		if !p.qualified {
+			if p.matches(baseFilename) {
+				return p.target, true
+			}
+			continue
+		}
+		for _, name := range names {
+			if p.matches(name) {
+				return p.target, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// StripMirrorPrefix removes the longest matching mirror prefix from an image name, returning
+// the image name as found on Docker Hub. A prefix matches whole path components and may
+// contain wildcards, e.g. "artifactory.*/dockerhub-*" matches
+// "artifactory.corp/dockerhub-remote/library/node".
+func StripMirrorPrefix(base string, mirrors []string) string {
+	components := strings.Split(base, "/")
+	stripped := 0
+	for _, mirror := range mirrors {
+		n := strings.Count(mirror, "/") + 1
+		// The image name itself is never stripped
+		if n >= len(components) || n <= stripped {
+			continue
+		}
+		if ok, _ := path.Match(mirror, strings.Join(components[:n], "/")); ok {
+			stripped = n
+		}
+	}
+	return strings.Join(components[stripped:], "/")
+}
+
+// imageNames returns the names a registry-qualified key may use for an image: as written, without
+// the Docker Hub registry and without the "library/" namespace
+func imageNames(base string) []string {
+	names := []string{base}
+	normalized := normalizeImageName(base)
+	if normalized != base {
+		names = append(names, normalized)
+	}
+	if simple, ok := strings.CutPrefix(normalized, "library/"); ok {
+		names = append(names, simple)
+	}
+	return names
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"testing"
+)
+
+func TestImageMatcher(t *testing.T) {
+	images := map[string]string{
+		"node":                      "node",
+		"node*":                     "node-generic",
+		"nodejs*":                   "node",
+		"python:3.9-slim":           "python:3.9",
+		"bitnami/*":                 "bitnami-generic",
+		"bitnami/*-exporter":        "prometheus-exporter",
+		"gcr.io/distroless/*":       "static",
+		"*-base":                    "chainguard-base",
+		"ubi?":                      "chainguard-base",
+		"ubi[0-9]-minimal":          "chainguard-base:latest",
+		"regex:^openjdk[0-9]*$":     "jdk",
+		"regex:^eclipse-temurin.*$": "jdk",
+		"eclipse-*":                 "eclipse-generic",
+	}
+
+	matcher, err := NewImageMatcher(images, nil)
+	if err != nil {
+		t.Fatalf("NewImageMatcher() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		base     string
+		tag      string
+		expected string
+	}{
+		{name: "exact beats pattern", base: "node", expected: "node"},
+		{name: "exact on Docker Hub variant", base: "docker.io/library/node", expected: "node"},
+		{name: "exact with tag", base: "python", tag: "3.9-slim", expected: "python:3.9"},
+		{name: "longest prefix wins", base: "nodejs18", expected: "node"},
+		{name: "shorter prefix", base: "node-red", expected: "node-generic"},
+		{name: "qualified pattern", base: "bitnami/redis", expected: "bitnami-generic"},
+		{name: "qualified pattern on Docker Hub", base: "docker.io/bitnami/redis", expected: "bitnami-generic"},
+		{name: "more specific qualified pattern", base: "bitnami/node-exporter", expected: "prometheus-exporter"},
+		{name: "qualified beats unqualified", base: "gcr.io/distroless/java-base", expected: "static"},
+		{name: "unqualified pattern on file name", base: "registry.example.com/team/app-base", expected: "chainguard-base"},
+		{name: "single character wildcard", base: "registry.access.redhat.com/ubi9", expected: "chainguard-base"},
+		{name: "character class", base: "ubi8-minimal", expected: "chainguard-base:latest"},
+		{name: "regex", base: "openjdk11", expected: "jdk"},
+		{name: "glob beats regex", base: "eclipse-temurin", expected: "eclipse-generic"},
+		{name: "no match", base: "redis", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Map iteration order must not affect the result
+			for range 20 {
+				m, err := NewImageMatcher(images, nil)
+				if err != nil {
+					t.Fatalf("NewImageMatcher() error = %v", err)
+				}
+				got, _ := m.Match(tt.base, tt.tag)
+				if got != tt.expected {
+					t.Fatalf("Match(%q, %q) = %q, want %q", tt.base, tt.tag, got, tt.expected)
+				}
+			}
+			if got, ok := matcher.Match(tt.base, tt.tag); ok != (tt.expected != "") || got != tt.expected {
+				t.Errorf("Match(%q, %q) = %q, %v", tt.base, tt.tag, got, ok)
+			}
+		})
+	}
+}
+
+func TestImageMatcherInvalidPatterns(t *testing.T) {
+	for _, key := range []string{"regex:^node(", "node[0-9"} {
+		if _, err := NewImageMatcher(map[string]string{key: "node"}, nil); err == nil {
+			t.Errorf("NewImageMatcher(%q) expected an error", key)
+		}
+	}
+}
+
+func TestImageMatcherMirrors(t *testing.T) {
+	images := map[string]string{
+		"docker.io/library/node:18": "node:18",
+		"bitnami/*":                 "bitnami-generic",
+		"python":                    "python",
+	}
+	mirrors := []string{
+		"artifactory.*/dockerhub-*",
+		"public.ecr.aws/docker",
+		"mirror.gcr.io",
+	}
+
+	matcher, err := NewImageMatcher(images, mirrors)
+	if err != nil {
+		t.Fatalf("NewImageMatcher() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		base     string
+		tag      string
+		expected string
+	}{
+		{name: "wildcard mirror", base: "artifactory.corp/dockerhub-remote/library/node", tag: "18", expected: "node:18"},
+		{name: "ECR public mirror", base: "public.ecr.aws/docker/library/python", expected: "python"},
+		{name: "mirror without library namespace", base: "mirror.gcr.io/node", tag: "18", expected: "node:18"},
+		{name: "qualified pattern through mirror", base: "artifactory.corp/dockerhub-remote/bitnami/redis", expected: "bitnami-generic"},
+		{name: "partial prefix is not stripped", base: "artifactory.corp/other/bitnami/redis", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := matcher.Match(tt.base, tt.tag); got != tt.expected {
+				t.Errorf("Match(%q, %q) = %q, want %q", tt.base, tt.tag, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestStripMirrorPrefix(t *testing.T) {
+	mirrors := []string{"mirror.example.com", "mirror.example.com/hub", "proxy-*/docker"}
+	tests := []struct {
+		base     string
+		expected string
+	}{
+		{base: "mirror.example.com/library/node", expected: "library/node"},
+		{base: "mirror.example.com/hub/library/node", expected: "library/node"},
+		{base: "proxy-eu/docker/python", expected: "python"},
+		{base: "mirror.example.com", expected: "mirror.example.com"},
+		{base: "docker.io/library/node", expected: "docker.io/library/node"},
+	}
+	for _, tt := range tests {
+		if got := StripMirrorPrefix(tt.base, mirrors); got != tt.expected {
+			t.Errorf("StripMirrorPrefix(%q) = %q, want %q", tt.base, got, tt.expected)
+		}
+	}
+}
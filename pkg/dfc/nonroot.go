@@ -0,0 +1,220 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// privilegedPortOffset moves privileged ports to unprivileged ones, e.g. 80 to 8080
+const privilegedPortOffset = 8000
+
+// checkNonroot reports what breaks in the final stage when it runs as a non-root user: privileged
+// ports, WORKDIR and VOLUME directories owned by root, and files copied without --chown into the
+// WORKDIR or a VOLUME, where the process writes. Files copied elsewhere, such as binaries, are
+// read-only and stay owned by root. With fix, those COPY and ADD lines get --chown and a
+// privileged port is moved using the port variable of the image.
+func checkNonroot(cc *ConversionContext, lines []*DockerfileLine, finalUsers map[int]string, metadata map[string]ImageMetadata, fix bool) {
+	start := -1
+	for i, line := range lines {
+		if line.From != nil {
+			start = i
+		}
+	}
+	if start < 0 {
+		return
+	}
+	fromLine := lines[start]
+	runtimeUser := finalUsers[fromLine.Stage]
+	if runtimeUser == "" || isRootUser(runtimeUser) {
+		return
+	}
+	image := metadata[filepath.Base(stageImage(cc, fromLine))]
+	chown := runtimeUser
+	if !strings.Contains(chown, ":") {
+		chown += ":" + runtimeUser
+	}
+
+	// Directories the process writes: the WORKDIR and the VOLUME directories of the stage, other
+	// than the root directory
+	var writeDirs []string
+	workdir := "/"
+	for _, line := range lines[start+1:] {
+		for _, instruction := range splitInstructions(lineText(line)) {
+			directive, args := splitDirective(instruction)
+			switch directive {
+			case "WORKDIR":
+				workdir = resolveStagePath(workdir, args)
+				writeDirs = append(writeDirs, workdir)
+			case "VOLUME":
+				for _, p := range volumePaths(args) {
+					writeDirs = append(writeDirs, resolveStagePath(workdir, p))
+				}
+			}
+		}
+	}
+	writeDirs = slices.DeleteFunc(writeDirs, func(dir string) bool { return dir == "/" })
+
+	user := stageStartUser(cc, fromLine, finalUsers, metadata)
+	workdir = "/"
+	var writable []string // Directories created or copied as the runtime user
+	portFixed := false
+
+	for _, line := range lines[start+1:] {
+		instructions := splitInstructions(lineText(line))
+		changed := false
+		for j, instruction := range instructions {
+			directive, args := splitDirective(instruction)
+			switch directive {
+			case DirectiveUser:
+				user = args
+			case "WORKDIR":
+				workdir = resolveStagePath(workdir, args)
+				if isRootUser(user) {
+					cc.enterConvertedLine(line)
+					cc.report(SeverityWarning, DiagnosticRootOwnedPath,
+						"WORKDIR %s is created by root and is not writable by %s", workdir, runtimeUser)
+				} else {
+					writable = append(writable, workdir)
+				}
+			case "VOLUME":
+				for _, p := range volumePaths(args) {
+					p = resolveStagePath(workdir, p)
+					if !isUnderPath(p, writable) {
+						cc.enterConvertedLine(line)
+						cc.report(SeverityWarning, DiagnosticRootOwnedPath,
+							"VOLUME %s is owned by root and is not writable by %s", p, runtimeUser)
+					}
+				}
+			case DirectiveCopy, "ADD":
+				dest := copyDestination(args)
+				if dest == "" {
+					continue
+				}
+				dest = resolveStagePath(workdir, dest)
+				if hasChown(args) {
+					writable = append(writable, dest)
+					continue
+				}
+				if !isUnderPath(dest, writeDirs) && !containsPath(dest, writeDirs) {
+					continue
+				}
+				cc.enterConvertedLine(line)
+				if fix {
+					instructions[j] = directive + " --chown=" + chown + " " + args
+					changed = true
+					writable = append(writable, dest)
+					cc.report(SeverityInfo, DiagnosticCopyWithoutChown,
+						"added --chown=%s so that the copied files are writable by %s", chown, runtimeUser)
+				} else {
+					cc.report(SeverityInfo, DiagnosticCopyWithoutChown,
+						"%s without --chown copies files owned by root, which %s cannot write", directive, runtimeUser)
+				}
+			case "EXPOSE":
+				fields := strings.Fields(args)
+				for k, field := range fields {
+					port, protocol, _ := strings.Cut(field, "/")
+					n, err := strconv.Atoi(port)
+					if err != nil || n >= 1024 {
+						continue
+					}
+					cc.enterConvertedLine(line)
+					if fix && image.PortEnv != "" && !portFixed {
+						moved := strconv.Itoa(n + privilegedPortOffset)
+						if protocol != "" {
+							moved += "/" + protocol
+						}
+						fields[k] = moved
+						instructions[j] = fmt.Sprintf("%s %s=%d\n%s %s", DirectiveEnv, image.PortEnv, n+privilegedPortOffset, directive, strings.Join(fields, " "))
+						changed = true
+						portFixed = true
+						cc.report(SeverityInfo, DiagnosticPrivilegedPort,
+							"moved the privileged port %d to %d by setting %s", n, n+privilegedPortOffset, image.PortEnv)
+						continue
+					}
+					cc.report(SeverityWarning, DiagnosticPrivilegedPort,
+						"port %d is privileged and %s cannot bind it, listen on a port above 1023", n, runtimeUser)
+				}
+			}
+		}
+		if changed {
+			line.Converted = strings.Join(instructions, "\n")
+		}
+	}
+}
+
+// stageImage returns the name of the converted image a stage is built on, following the stages
+// it is built on top of
+func stageImage(cc *ConversionContext, fromLine *DockerfileLine) string {
+	stage := cc.StageByIndex(fromLine.Stage)
+	for stage.From != nil && stage.From.Parent > 0 {
+		stage = cc.StageByIndex(stage.From.Parent)
+	}
+	base, _, _ := splitImageReference(stage.ConvertedFrom)
+	return base
+}
+
+// splitInstructions splits the text of a line into the instructions a conversion put on separate
+// lines, keeping continuation lines with their instruction
+func splitInstructions(text string) []string {
+	var instructions []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' && (i == 0 || text[i-1] != '\\') {
+			instructions = append(instructions, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(instructions, text[start:])
+}
+
+// volumePaths returns the paths of the arguments of a VOLUME instruction
+func volumePaths(args string) []string {
+	if exec, ok := parseExecForm(args); ok {
+		return exec
+	}
+	return strings.Fields(args)
+}
+
+// hasChown reports whether the arguments of a COPY or ADD instruction have a --chown flag
+func hasChown(args string) bool {
+	for _, field := range strings.Fields(args) {
+		if !strings.HasPrefix(field, "--") {
+			return false
+		}
+		if strings.HasPrefix(field, "--chown=") {
+			return true
+		}
+	}
+	return false
+}
+
+// containsPath reports whether one of the directories is inside a path, except the root
+func containsPath(p string, dirs []string) bool {
+	if p == "/" {
+		return false
+	}
+	for _, dir := range dirs {
+		if isUnderPath(dir, []string{p}) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnderPath reports whether a path is one of the directories or inside one of them
+func isUnderPath(p string, dirs []string) bool {
+	for _, dir := range dirs {
+		if p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
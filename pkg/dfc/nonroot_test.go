@@ -0,0 +1,156 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCheckNonroot(t *testing.T) {
+	testCases := []struct {
+		name        string
+		raw         string
+		fix         bool
+		extra       MappingsConfig
+		expected    string
+		diagnostics []string
+	}{
+		{
+			name: "privileged port",
+			raw: `FROM nginx:1.27
+EXPOSE 80 8443`,
+			extra: MappingsConfig{ImageMetadata: map[string]ImageMetadata{"nginx": {User: "nginx"}}},
+			expected: `FROM cgr.dev/ORG/nginx:1.27
+EXPOSE 80 8443`,
+			diagnostics: []string{"privileged-port: port 80 is privileged and nginx cannot bind it, listen on a port above 1023"},
+		},
+		{
+			name: "privileged port moved with the port variable of the image",
+			raw: `FROM nginx:1.27
+EXPOSE 80/tcp 443`,
+			fix:   true,
+			extra: MappingsConfig{ImageMetadata: map[string]ImageMetadata{"nginx": {User: "nginx", PortEnv: "NGINX_PORT"}}},
+			expected: `FROM cgr.dev/ORG/nginx:1.27
+ENV NGINX_PORT=8080
+EXPOSE 8080/tcp 443`,
+			diagnostics: []string{
+				"privileged-port: moved the privileged port 80 to 8080 by setting NGINX_PORT",
+				"privileged-port: port 443 is privileged and nginx cannot bind it, listen on a port above 1023",
+			},
+		},
+		{
+			name: "WORKDIR and VOLUME created by root",
+			raw: `FROM python:3.12
+WORKDIR /app
+RUN apt-get install -y curl
+WORKDIR /data
+VOLUME ["/app/cache", "/data"]`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+WORKDIR /app
+USER root
+RUN apk add --no-cache curl
+WORKDIR /data
+VOLUME ["/app/cache", "/data"]
+USER nonroot`,
+			diagnostics: []string{
+				"root-owned-path: WORKDIR /data is created by root and is not writable by nonroot",
+				"root-owned-path: VOLUME /data is owned by root and is not writable by nonroot",
+			},
+		},
+		{
+			name: "COPY without --chown",
+			raw: `FROM python:3.12
+WORKDIR /app
+COPY --chown=nonroot:nonroot requirements.txt .
+COPY . .`,
+			expected: `FROM cgr.dev/ORG/python:3.12
+WORKDIR /app
+COPY --chown=nonroot:nonroot requirements.txt .
+COPY . .`,
+			diagnostics: []string{"copy-without-chown: COPY without --chown copies files owned by root, which nonroot cannot write"},
+		},
+		{
+			name: "COPY gets --chown",
+			raw: `FROM python:3.12 AS build
+FROM python:3.12
+COPY --from=build /app /app
+VOLUME /app/data`,
+			fix: true,
+			expected: `FROM cgr.dev/ORG/python:3.12 AS build
+FROM cgr.dev/ORG/python:3.12
+COPY --chown=nonroot:nonroot --from=build /app /app
+VOLUME /app/data`,
+			diagnostics: []string{"copy-without-chown: added --chown=nonroot:nonroot so that the copied files are writable by nonroot"},
+		},
+		{
+			name: "read-only files keep root as owner",
+			raw: `FROM python:3.12 AS build
+FROM python:3.12
+COPY --from=build /usr/bin/app /usr/bin/app
+WORKDIR /srv
+COPY . .
+COPY config.yaml /etc/app/`,
+			fix: true,
+			expected: `FROM cgr.dev/ORG/python:3.12 AS build
+FROM cgr.dev/ORG/python:3.12
+COPY --from=build /usr/bin/app /usr/bin/app
+WORKDIR /srv
+COPY --chown=nonroot:nonroot . .
+COPY config.yaml /etc/app/`,
+			diagnostics: []string{"copy-without-chown: added --chown=nonroot:nonroot so that the copied files are writable by nonroot"},
+		},
+		{
+			name: "final stage running as root",
+			raw: `FROM python:3.12
+USER root
+EXPOSE 80
+COPY . /app`,
+			expected: `FROM cgr.dev/ORG/python:3.12
+USER root
+EXPOSE 80
+COPY . /app`,
+		},
+		{
+			name: "final stage on a general purpose OS image running as root",
+			raw: `FROM debian:bookworm
+EXPOSE 80`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+EXPOSE 80`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{Organization: "ORG", FixNonroot: tc.fix, ExtraMappings: tc.extra})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var diagnostics []string
+			for _, d := range converted.Diagnostics {
+				switch d.Code {
+				case DiagnosticPrivilegedPort, DiagnosticRootOwnedPath, DiagnosticCopyWithoutChown:
+					diagnostics = append(diagnostics, d.Code+": "+d.Message)
+				}
+			}
+			if diff := cmp.Diff(tc.diagnostics, diagnostics); diff != "" {
+				t.Errorf("diagnostics not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"cmp"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// PathMappings maps filesystem paths of other distributions to their location in Chainguard
+// images. A path mapped to an empty string is known to be missing from Chainguard images.
+type PathMappings struct {
+	Global map[string]string            `yaml:"global,omitempty"` // Paths of every image
+	Images map[string]map[string]string `yaml:"images,omitempty"` // Paths of a Chainguard image, keyed by image name, take precedence over Global
+}
+
+// pathRule is a path mapping that applies to a stage
+type pathRule struct {
+	from, to string
+}
+
+// rewritePaths rewrites the paths of the COPY and ADD destinations, ENV values, WORKDIR and RUN
+// arguments of the stages built on converted images, reporting the paths missing from them
+func rewritePaths(cc *ConversionContext, lines []*DockerfileLine, paths PathMappings) {
+	if len(paths.Global) == 0 && len(paths.Images) == 0 {
+		return
+	}
+	images := make(map[int]string)
+	var rules []pathRule
+
+	for _, line := range lines {
+		if line.From != nil {
+			// Stages built on top of another stage use the image of that stage
+			image := images[line.From.Parent]
+			if line.From.Parent == 0 {
+				image = ""
+				if imageRef := cc.convertedImage(line); imageRef != "" {
+					base, _, _ := splitImageReference(imageRef)
+					image = filepath.Base(base)
+				}
+			}
+			images[line.Stage] = image
+			rules = nil
+			if image != "" {
+				rules = append(sortedPathRules(paths.Images[image]), sortedPathRules(paths.Global)...)
+			}
+			continue
+		}
+		if len(rules) == 0 {
+			continue
+		}
+
+		text := lineText(line)
+		directive, args := splitDirective(text)
+		var rewritten string
+		var missing []string
+		switch directive {
+		case DirectiveCopy, "ADD":
+			dest := copyDestination(args)
+			if dest == "" {
+				continue
+			}
+			// The sources of COPY --from are paths of the stage or image copied from
+			i := strings.LastIndex(text, dest)
+			sources := text[:i]
+			if line.Copy != nil {
+				sourceRules := sourcePathRules(cc, line, images, paths)
+				sources, _ = rewritePathsInText(sources, sourceRules)
+			}
+			var newDest string
+			newDest, missing = rewritePathsInText(dest, rules)
+			rewritten = sources + newDest + text[i+len(dest):]
+		case DirectiveEnv, "WORKDIR", DirectiveRun:
+			rewritten, missing = rewritePathsInText(text, rules)
+		default:
+			continue
+		}
+
+		if len(missing) > 0 || rewritten != text {
+			cc.enterConvertedLine(line)
+		}
+		for _, p := range missing {
+			cc.report(SeverityWarning, DiagnosticMissingPath, "%s does not exist in %s", p, cc.Stage.ConvertedFrom)
+		}
+		if rewritten != text {
+			line.Converted = rewritten
+			cc.report(SeverityInfo, DiagnosticPathRewritten, "rewrote the paths of the %s line for %s", directive, cc.Stage.ConvertedFrom)
+		}
+	}
+}
+
+// sourcePathRules returns the path rules of the stage or converted image a COPY --from line
+// copies from
+func sourcePathRules(cc *ConversionContext, line *DockerfileLine, images map[int]string, paths PathMappings) []pathRule {
+	var image string
+	if stage := cc.LookupStage(line.Copy.From); stage != nil {
+		image = images[stage.Index]
+	} else if line.Converted != "" {
+		_, args := splitDirective(line.Converted)
+		base, _, _ := splitImageReference(parseCopyFrom(args))
+		image = filepath.Base(base)
+	}
+	if image == "" {
+		return nil
+	}
+	return append(sortedPathRules(paths.Images[image]), sortedPathRules(paths.Global)...)
+}
+
+// sortedPathRules returns the path mappings as rules, longest path first
+func sortedPathRules(paths map[string]string) []pathRule {
+	rules := make([]pathRule, 0, len(paths))
+	for _, from := range slices.Sorted(maps.Keys(paths)) {
+		rules = append(rules, pathRule{from: from, to: paths[from]})
+	}
+	slices.SortStableFunc(rules, func(a, b pathRule) int {
+		return cmp.Compare(len(b.from), len(a.from))
+	})
+	return rules
+}
+
+// rewritePathsInText rewrites the paths of the text that start with the path of a rule, and
+// returns the paths of the rules for missing paths that were found
+func rewritePathsInText(text string, rules []pathRule) (string, []string) {
+	var result strings.Builder
+	var missing []string
+	for i := 0; i < len(text); {
+		if i > 0 && isPathChar(text[i-1]) {
+			result.WriteByte(text[i])
+			i++
+			continue
+		}
+		matched := false
+		for _, rule := range rules {
+			end := i + len(rule.from)
+			if !strings.HasPrefix(text[i:], rule.from) || (end < len(text) && isPathChar(text[end]) && text[end] != '/') {
+				continue
+			}
+			matched = true
+			if rule.to == "" {
+				if !slices.Contains(missing, rule.from) {
+					missing = append(missing, rule.from)
+				}
+				result.WriteString(rule.from)
+			} else {
+				result.WriteString(rule.to)
+			}
+			i = end
+			break
+		}
+		if !matched {
+			result.WriteByte(text[i])
+			i++
+		}
+	}
+	return result.String(), missing
+}
+
+// isPathChar reports whether a byte can be part of a path
+func isPathChar(c byte) bool {
+	return c == '/' || c == '.' || c == '-' || c == '_' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
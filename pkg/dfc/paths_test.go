@@ -0,0 +1,122 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRewritePaths(t *testing.T) {
+	testCases := []struct {
+		name        string
+		raw         string
+		expected    string
+		diagnostics []string
+	}{
+		{
+			name: "ENV values",
+			raw: `FROM eclipse-temurin:17
+ENV JAVA_HOME=/usr/lib/jvm/java-17-openjdk-amd64
+ENV LD_LIBRARY_PATH=/usr/lib/x86_64-linux-gnu:/opt/lib PATH=$JAVA_HOME/bin:$PATH`,
+			expected: `FROM cgr.dev/ORG/jdk:openjdk-17
+ENV JAVA_HOME=/usr/lib/jvm/java-17-openjdk
+ENV LD_LIBRARY_PATH=/usr/lib:/opt/lib PATH=$JAVA_HOME/bin:$PATH`,
+			diagnostics: []string{
+				"path-rewritten: rewrote the paths of the ENV line for cgr.dev/ORG/jdk:openjdk-17",
+				"path-rewritten: rewrote the paths of the ENV line for cgr.dev/ORG/jdk:openjdk-17",
+			},
+		},
+		{
+			name: "image specific paths in RUN and WORKDIR",
+			raw: `FROM python:3.12
+WORKDIR /usr/lib/x86_64-linux-gnu/app
+RUN /usr/local/bin/python -m pip install flask`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+WORKDIR /usr/lib/app
+RUN /usr/bin/python -m pip install flask`,
+			diagnostics: []string{
+				"path-rewritten: rewrote the paths of the WORKDIR line for cgr.dev/ORG/python:3.12-dev",
+				"path-rewritten: rewrote the paths of the RUN line for cgr.dev/ORG/python:3.12-dev",
+			},
+		},
+		{
+			name: "image specific paths of other images are kept",
+			raw: `FROM node:20
+RUN ls /usr/local/bin/python /usr/local/bin/node`,
+			expected: `FROM cgr.dev/ORG/node:20-dev
+RUN ls /usr/local/bin/python /usr/bin/node`,
+			diagnostics: []string{"path-rewritten: rewrote the paths of the RUN line for cgr.dev/ORG/node:20-dev"},
+		},
+		{
+			name: "COPY rewrites destinations, and sources of the stage copied from",
+			raw: `FROM python:3.12 AS build
+FROM python:3.12-slim
+COPY lib/x86_64-linux-gnu/ /usr/lib/x86_64-linux-gnu/
+COPY --from=build /usr/local/bin/python3 /usr/local/bin/python3`,
+			expected: `FROM cgr.dev/ORG/python:3.12 AS build
+FROM cgr.dev/ORG/python:3.12
+COPY lib/x86_64-linux-gnu/ /usr/lib/
+COPY --from=build /usr/bin/python3 /usr/bin/python3`,
+			diagnostics: []string{
+				"path-rewritten: rewrote the paths of the COPY line for cgr.dev/ORG/python:3.12",
+				"path-rewritten: rewrote the paths of the COPY line for cgr.dev/ORG/python:3.12",
+			},
+		},
+		{
+			name: "missing paths",
+			raw: `FROM debian:bookworm
+COPY sources.list /etc/apt/sources.list.d/extra.list`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+COPY sources.list /etc/apt/sources.list.d/extra.list`,
+			diagnostics: []string{"missing-path: /etc/apt does not exist in cgr.dev/ORG/chainguard-base:latest"},
+		},
+		{
+			name: "paths inside other paths are kept",
+			raw: `FROM debian:bookworm
+ENV A=/opt/usr/lib/x86_64-linux-gnu B=/usr/lib/x86_64-linux-gnu-extra`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+ENV A=/opt/usr/lib/x86_64-linux-gnu B=/usr/lib/x86_64-linux-gnu-extra`,
+		},
+		{
+			name: "stages on images that are not converted",
+			raw: `FROM scratch
+COPY app /usr/lib/x86_64-linux-gnu/app`,
+			expected: `FROM scratch
+COPY app /usr/lib/x86_64-linux-gnu/app`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{Organization: "ORG"})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var diagnostics []string
+			for _, d := range converted.Diagnostics {
+				if d.Code == DiagnosticPathRewritten || d.Code == DiagnosticMissingPath {
+					diagnostics = append(diagnostics, d.Code+": "+d.Message)
+				}
+			}
+			if diff := cmp.Diff(tc.diagnostics, diagnostics); diff != "" {
+				t.Errorf("diagnostics not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
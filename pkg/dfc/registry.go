@@ -0,0 +1,68 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// remoteOptions returns the options of the requests to registries. Credentials come from the
+// Docker config, ~/.docker/config.json or $DOCKER_CONFIG, and its credential helpers, so private
+// repositories such as cgr.dev/<org>/ can be read; registries without credentials are read
+// anonymously.
+func remoteOptions(ctx context.Context, client *http.Client, userAgent string) []remote.Option {
+	opts := []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(authn.DefaultKeychain),
+	}
+	if client != nil && client.Transport != nil {
+		opts = append(opts, remote.WithTransport(client.Transport))
+	}
+	if userAgent != "" {
+		opts = append(opts, remote.WithUserAgent(userAgent))
+	}
+	return opts
+}
+
+// isNotFound reports whether a registry answered a request with 404 Not Found
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
+
+// RegistryDigestResolver resolves digests from OCI registries, with the credentials of the
+// Docker config
+type RegistryDigestResolver struct {
+	Client    *http.Client // HTTP client whose transport is used, http.DefaultTransport if nil
+	UserAgent string       // User agent of the requests
+}
+
+// ResolveDigest fetches the digest of the image manifest from its registry
+func (r *RegistryDigestResolver) ResolveDigest(ctx context.Context, image string) (string, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", fmt.Errorf("parsing image reference %s: %w", image, err)
+	}
+	opts := remoteOptions(ctx, r.Client, r.UserAgent)
+	if desc, err := remote.Head(ref, opts...); err == nil {
+		return desc.Digest.String(), nil
+	}
+
+	// Some registries only return the digest of a manifest with its content
+	desc, err := remote.Get(ref, opts...)
+	if err != nil {
+		return "", fmt.Errorf("fetching manifest of %s: %w", image, err)
+	}
+	return desc.Digest.String(), nil
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ImageReference is an image reference found in a FROM line, an ARG default used as a base
+// image or a COPY --from flag
+type ImageReference struct {
+	Base     string // Image name without tag or digest, e.g. "docker.io/library/node"
+	Tag      string // Tag of the image, may reference ARGs
+	Digest   string // Digest of the image, if pinned
+	NeedsDev bool   // The image is the base of a stage with RUN lines and needs a -dev variant
+}
+
+// ImageResolver resolves image references of the original Dockerfile to the image references
+// used in the converted Dockerfile. Set Options.ImageResolver to plug in a custom resolver; a
+// custom resolver may wrap the one returned by NewDefaultImageResolver. The ConversionContext
+// gives the state of the conversion, such as the current stage, and receives the diagnostics of
+// the resolution; it is nil when resolving outside a conversion.
+type ImageResolver interface {
+	ResolveImage(ctx context.Context, ref ImageReference, cc *ConversionContext) (string, error)
+}
+
+// DefaultImageResolver resolves image references using the image and tag mappings
+type DefaultImageResolver struct {
+	Mappings     MappingsConfig
+	Registry     string     // Registry and root namespace of the converted images, takes precedence over Organization
+	Organization string     // cgr.dev namespace of the converted images
+	Catalog      TagCatalog // Optional catalog of the published tags, converted tags are checked against it
+	TagPolicy    TagPolicy  // Version used when a converted tag is not published, TagPolicyNextNewer by default
+	FIPS         bool       // Use the FIPS images of Mappings.FIPS, reporting images without one
+
+	// Registries of previously converted images, moved to Registry or Organization like the
+	// images under cgr.dev
+	FromRegistries []string
+
+	matcher         *ImageMatcher
+	variantMatchers map[string]*ImageMatcher
+	tagRules        map[string]compiledTagRule
+}
+
+// NewDefaultImageResolver returns the default resolver for the given mappings, see LoadMappings
+func NewDefaultImageResolver(mappings MappingsConfig, registry, organization string) (*DefaultImageResolver, error) {
+	matcher, err := NewImageMatcher(mappings.Images, mappings.Mirrors)
+	if err != nil {
+		return nil, err
+	}
+	variantMatchers := make(map[string]*ImageMatcher)
+	for variant, rule := range mappings.Variants {
+		if len(rule.Images) == 0 {
+			continue
+		}
+		if variantMatchers[variant], err = NewImageMatcher(rule.Images, mappings.Mirrors); err != nil {
+			return nil, fmt.Errorf("variant %s: %w", variant, err)
+		}
+	}
+	tagRules, err := compileTagRules(mappings.Tags)
+	if err != nil {
+		return nil, err
+	}
+	return &DefaultImageResolver{
+		Mappings:        mappings,
+		Registry:        registry,
+		Organization:    organization,
+		matcher:         matcher,
+		variantMatchers: variantMatchers,
+		tagRules:        tagRules,
+	}, nil
+}
+
+// ResolveImage maps the image to a Chainguard image and derives its tag
+func (r *DefaultImageResolver) ResolveImage(ctx context.Context, ref ImageReference, cc *ConversionContext) (string, error) {
+	// Images that were already converted only move to the registry of this conversion
+	if imageRef, ok := r.retargetImage(ref); ok {
+		return imageRef, nil
+	}
+
+	targetImage := filepath.Base(ref.Base)
+	var convertedTag string
+
+	// Images with an unsupported variant are never converted, and mappings of the variants of
+	// the tag take precedence over the image mappings
+	variants := variantKeys(ref.Tag)
+	for _, variant := range variants {
+		if reason := r.Mappings.Variants[variant].Unsupported; reason != "" {
+			return "", &UnsupportedVariantError{Image: ref.Base + ":" + ref.Tag, Variant: variant, Reason: reason}
+		}
+	}
+	mappedImage, ok := "", false
+	for _, variant := range variants {
+		if matcher := r.variantMatchers[variant]; matcher != nil {
+			if mappedImage, ok = matcher.Match(ref.Base, ref.Tag); ok {
+				break
+			}
+		}
+	}
+	if !ok {
+		mappedImage, ok = r.matcher.Match(ref.Base, ref.Tag)
+	}
+
+	// Resolve the image using the mappings
+	if ok {
+		// Check if the mapped image includes a tag
+		if parts := strings.Split(mappedImage, ":"); len(parts) > 1 {
+			targetImage = parts[0]
+			convertedTag = parts[1]
+		} else {
+			targetImage = mappedImage
+		}
+	}
+
+	// FIPS images follow the tag rules of the image they are the FIPS equivalent of
+	imageName := targetImage
+	if r.FIPS {
+		imageName = fipsImage(cc, r.Mappings.FIPS, targetImage)
+	}
+
+	// Named tags such as node:lts keep the version they stand for
+	tag := ref.Tag
+	resolved, aliased := resolveTagAlias(r.Mappings.TagAliases, ref.Base, tag, r.Mappings.Mirrors)
+	if aliased {
+		tag = resolved
+	}
+
+	// If targetTag is not specified in mapping, calculate it using the tag rules
+	if convertedTag == "" {
+		var available []string
+		if r.Catalog != nil {
+			tags, err := r.Catalog.ListTags(ctx, imageName)
+			if err != nil {
+				cc.report(SeverityWarning, DiagnosticTagCatalogError,
+					"listing the tags of %s failed, the converted tag is not checked: %v", imageName, err)
+			}
+			available = tags
+		}
+
+		var note string
+		convertedTag, note = calculateConvertedTag(targetImage, tag, ref.NeedsDev, r.tagRules, available, r.TagPolicy)
+
+		// Only report aliases that changed the converted tag, not those of images that become
+		// chainguard-base:latest either way
+		if aliased {
+			if unaliased, _ := calculateConvertedTag(targetImage, ref.Tag, ref.NeedsDev, r.tagRules, available, r.TagPolicy); unaliased != convertedTag {
+				cc.report(SeverityInfo, DiagnosticTagAliasResolved,
+					"%s:%s is an alias of %s:%s", filepath.Base(ref.Base), ref.Tag, filepath.Base(ref.Base), resolved)
+			}
+		}
+		if note != "" {
+			cc.report(SeverityWarning, DiagnosticTagNotPublished, "%s", note)
+		}
+	}
+
+	return buildImageReference(imageName, convertedTag, r.Registry, r.Organization), nil
+}
+
+// splitImageReference splits an image reference into its name, tag and digest. A colon is only
+// treated as a tag separator after the last "/", so registry ports are kept in the name.
+func splitImageReference(ref string) (base, tag, digest string) {
+	base = ref
+	if i := strings.Index(base, "@"); i >= 0 {
+		base, digest = base[:i], base[i+1:]
+	}
+	if i := strings.LastIndex(base, ":"); i > strings.LastIndex(base, "/") {
+		base, tag = base[:i], base[i+1:]
+	}
+	return base, tag, digest
+}
+
+// parseCopyFrom returns the value of the --from flag of the arguments of a COPY instruction
+func parseCopyFrom(args string) string {
+	for _, field := range strings.Fields(args) {
+		if !strings.HasPrefix(field, "--") {
+			break
+		}
+		if from, ok := strings.CutPrefix(field, "--from="); ok {
+			return from
+		}
+	}
+	return ""
+}
+
+// parseMountFrom returns the from= values of the --mount flags of a RUN instruction
+func parseMountFrom(flags []string) []string {
+	var froms []string
+	for _, flag := range flags {
+		mount, ok := strings.CutPrefix(flag, "--mount=")
+		if !ok {
+			continue
+		}
+		for _, option := range strings.Split(mount, ",") {
+			if from, ok := strings.CutPrefix(option, "from="); ok && from != "" {
+				froms = append(froms, from)
+			}
+		}
+	}
+	return froms
+}
+
+// splitRunFlags splits the arguments of a RUN instruction into its flags, such as --mount, and
+// the command
+func splitRunFlags(args string) ([]string, string) {
+	var flags []string
+	for {
+		args = strings.TrimLeft(args, " \t")
+		if rest, ok := strings.CutPrefix(args, "\\\n"); ok {
+			args = rest
+			continue
+		}
+		if !strings.HasPrefix(args, "--") {
+			return flags, strings.TrimSpace(args)
+		}
+		end := strings.IndexAny(args, " \t\n")
+		if end < 0 {
+			end = len(args)
+		}
+		flags = append(flags, args[:end])
+		args = args[end:]
+	}
+}
+
+// resolveFlagImage resolves the image given to a --from flag or a from= mount option, returning
+// false for stages, scratch, ARGs and images that do not change
+func resolveFlagImage(ctx context.Context, from, flag string, cc *ConversionContext, resolver ImageResolver) (string, bool) {
+	if from == "scratch" || strings.Contains(from, "$") || cc.LookupStage(from) != nil {
+		return "", false
+	}
+
+	base, tag, digest := splitImageReference(from)
+	imageRef, err := resolver.ResolveImage(ctx, ImageReference{Base: base, Tag: tag, Digest: digest}, cc)
+	if err != nil {
+		reportResolveError(cc, err,
+			"resolving image %s for %s failed, keeping the original image: %v", from, flag, err)
+		return "", false
+	}
+	if imageRef == from {
+		return "", false
+	}
+	return imageRef, true
+}
+
+// convertCopyFromLine resolves the image of a COPY --from line that does not copy from a stage,
+// returning the converted line
+func convertCopyFromLine(ctx context.Context, line *DockerfileLine, cc *ConversionContext, resolver ImageResolver) (string, bool) {
+	from := line.Copy.From
+	imageRef, ok := resolveFlagImage(ctx, from, "COPY --from", cc, resolver)
+	if !ok {
+		return "", false
+	}
+	return strings.Replace(line.Raw, "--from="+from, "--from="+imageRef, 1), true
+}
+
+// convertRunMountLine resolves the images of the --mount flags of a converted RUN line that do
+// not mount a stage, returning the converted line
+func convertRunMountLine(ctx context.Context, line *DockerfileLine, cc *ConversionContext, resolver ImageResolver) (string, bool) {
+	if len(line.Run.MountFrom) == 0 {
+		return "", false
+	}
+	text := lineText(line)
+	flags, _ := splitRunFlags(text[len(DirectiveRun+" "):])
+	changed := false
+	for _, from := range line.Run.MountFrom {
+		imageRef, ok := resolveFlagImage(ctx, from, "RUN --mount", cc, resolver)
+		if !ok {
+			continue
+		}
+		for i, flag := range flags {
+			options := strings.Split(flag, ",")
+			for j, option := range options {
+				if option == "from="+from || option == "--mount=from="+from {
+					options[j] = strings.Replace(option, from, imageRef, 1)
+				}
+			}
+			if converted := strings.Join(options, ","); converted != flag {
+				text = strings.Replace(text, flag, converted, 1)
+				flags[i] = converted
+				changed = true
+			}
+		}
+	}
+	return text, changed
+}
@@ -0,0 +1,349 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// recordingResolver resolves every image to a fixed registry and records the references it saw,
+// and the lines they were on
+type recordingResolver struct {
+	refs  []ImageReference
+	lines []int
+	err   error
+}
+
+func (r *recordingResolver) ResolveImage(_ context.Context, ref ImageReference, cc *ConversionContext) (string, error) {
+	r.refs = append(r.refs, ref)
+	if cc != nil {
+		r.lines = append(r.lines, cc.Line)
+	}
+	if r.err != nil {
+		return "", r.err
+	}
+	image := "registry.example.com/" + path.Base(ref.Base)
+	if ref.NeedsDev {
+		return image + ":dev", nil
+	}
+	return image + ":prod", nil
+}
+
+func TestDefaultImageResolver(t *testing.T) {
+	mappings := MappingsConfig{
+		Images: map[string]string{
+			"docker.io/library/node:18": "node:18",
+			"python":                    "python",
+		},
+	}
+	resolver, err := NewDefaultImageResolver(mappings, "", "ORG")
+	if err != nil {
+		t.Fatalf("NewDefaultImageResolver() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		ref      ImageReference
+		expected string
+	}{
+		{name: "full reference with tag", ref: ImageReference{Base: "docker.io/library/node", Tag: "18"}, expected: "cgr.dev/ORG/node:18"},
+		{name: "docker hub variant", ref: ImageReference{Base: "index.docker.io/library/python", Tag: "3.12.1-slim"}, expected: "cgr.dev/ORG/python:3.12"},
+		{name: "dev variant", ref: ImageReference{Base: "python", Tag: "3.12", NeedsDev: true}, expected: "cgr.dev/ORG/python:3.12-dev"},
+		{name: "dynamic tag", ref: ImageReference{Base: "python", Tag: "${VERSION}"}, expected: "cgr.dev/ORG/python:${VERSION}"},
+		{name: "unmapped image", ref: ImageReference{Base: "localhost:5000/team/redis"}, expected: "cgr.dev/ORG/redis:latest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolver.ResolveImage(context.Background(), tt.ref, nil)
+			if err != nil {
+				t.Fatalf("ResolveImage() error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("ResolveImage() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestImageResolverConversion(t *testing.T) {
+	testCases := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{
+			name: "ARG default converts like FROM",
+			raw: `ARG BASE=docker.io/library/node:18
+FROM ${BASE}
+FROM docker.io/library/node:18`,
+			expected: `ARG BASE=cgr.dev/ORG/node:18
+FROM ${BASE}
+FROM cgr.dev/ORG/node:18`,
+		},
+		{
+			name: "ARG default with a registry port",
+			raw: `ARG BASE=localhost:5000/library/python:3.12
+FROM ${BASE}
+RUN echo hello`,
+			expected: `ARG BASE=cgr.dev/ORG/python:3.12-dev
+FROM ${BASE}
+RUN echo hello`,
+		},
+		{
+			name: "builtin mirror prefix",
+			raw: `ARG BASE=public.ecr.aws/docker/library/python:3.12
+FROM ${BASE}
+FROM mirror.gcr.io/library/python:3.12`,
+			expected: `ARG BASE=cgr.dev/ORG/python:3.12
+FROM ${BASE}
+FROM cgr.dev/ORG/python:3.12`,
+		},
+		{
+			name: "COPY --from an image",
+			raw: `FROM node:18 AS build
+COPY --from=docker.io/library/node:18 /usr/local/bin/node /usr/local/bin/node
+COPY --from=build /app /app`,
+			expected: `FROM cgr.dev/ORG/node:18 AS build
+COPY --from=cgr.dev/ORG/node:18 /usr/bin/node /usr/bin/node
+COPY --from=build /app /app`,
+		},
+		{
+			name: "RUN --mount from an image",
+			raw: `FROM golang:1.22 AS build
+RUN --mount=type=bind,from=golang:1.22,source=/go/pkg,target=/cache \
+    --mount=from=docker.io/library/node:18,target=/node go build ./...
+RUN --mount=type=cache,target=/root/.cache apt-get install -y git`,
+			expected: `FROM cgr.dev/ORG/go:1.22-dev AS build
+USER root
+RUN --mount=type=bind,from=cgr.dev/ORG/go:1.22,source=/go/pkg,target=/cache \
+    --mount=from=cgr.dev/ORG/node:18,target=/node go build ./...
+RUN --mount=type=cache,target=/root/.cache apk add --no-cache git`,
+		},
+		{
+			name: "RUN --mount from an image with packages added by a later line",
+			raw: `FROM debian:bookworm
+RUN --mount=type=bind,from=golang:1.22,source=/usr/local/go,target=/go apt-get install -y curl
+RUN grep -P foo bar`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+RUN --mount=type=bind,from=cgr.dev/ORG/go:1.22,source=/usr/local/go,target=/go apk add --no-cache curl grep
+RUN grep -P foo bar`,
+		},
+		{
+			name: "RUN --mount from a stage or ARG",
+			raw: `FROM node:18 AS deps
+FROM node:18
+RUN --mount=type=bind,from=deps,target=/deps --mount=from=0,target=/zero --mount=from=${IMAGE},target=/arg ls`,
+			expected: `FROM cgr.dev/ORG/node:18 AS deps
+FROM cgr.dev/ORG/node:18-dev
+RUN --mount=type=bind,from=deps,target=/deps --mount=from=0,target=/zero --mount=from=${IMAGE},target=/arg ls`,
+		},
+		{
+			name: "COPY --from and RUN --mount from scratch",
+			raw: `FROM node:18
+COPY --from=scratch / /empty
+RUN --mount=type=bind,from=scratch,target=/empty ls /empty`,
+			expected: `FROM cgr.dev/ORG/node:18-dev
+COPY --from=scratch / /empty
+RUN --mount=type=bind,from=scratch,target=/empty ls /empty`,
+		},
+		{
+			name: "COPY --from a stage number or ARG",
+			raw: `FROM node:18
+FROM python:3.12
+COPY --from=0 /app /app
+COPY --from=${IMAGE} /app /app`,
+			expected: `FROM cgr.dev/ORG/node:18
+FROM cgr.dev/ORG/python:3.12
+COPY --from=0 /app /app
+COPY --from=${IMAGE} /app /app`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{Organization: "ORG"})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCustomImageResolver(t *testing.T) {
+	raw := `ARG BASE=node:18
+FROM ${BASE} AS build
+RUN npm ci
+FROM gcr.io/distroless/nodejs:18
+COPY --from=build /app /app
+COPY --from=alpine:3.19 /etc/ssl /etc/ssl`
+	expected := `ARG BASE=registry.example.com/node:dev
+FROM ${BASE} AS build
+RUN npm ci
+FROM registry.example.com/nodejs:prod
+COPY --from=build /app /app
+COPY --from=registry.example.com/alpine:prod /etc/ssl /etc/ssl`
+
+	ctx := context.Background()
+	parsed, err := ParseDockerfile(ctx, []byte(raw))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	resolver := &recordingResolver{}
+	converted, err := parsed.Convert(ctx, Options{ImageResolver: resolver})
+	if err != nil {
+		t.Fatalf("Failed to convert Dockerfile: %v", err)
+	}
+	if diff := cmp.Diff(expected, strings.TrimSpace(converted.String())); diff != "" {
+		t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+	}
+
+	wantRefs := []ImageReference{
+		{Base: "node", Tag: "18", NeedsDev: true},
+		{Base: "gcr.io/distroless/nodejs", Tag: "18"},
+		{Base: "alpine", Tag: "3.19"},
+	}
+	if diff := cmp.Diff(wantRefs, resolver.refs); diff != "" {
+		t.Errorf("resolved references not as expected (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]int{1, 4, 6}, resolver.lines); diff != "" {
+		t.Errorf("lines of the resolved references not as expected (-want, +got):\n%s", diff)
+	}
+}
+
+func TestImageResolverError(t *testing.T) {
+	raw := `FROM python:3.12
+COPY --from=alpine:3.19 /etc/ssl /etc/ssl`
+
+	ctx := context.Background()
+	parsed, err := ParseDockerfile(ctx, []byte(raw))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	converted, err := parsed.Convert(ctx, Options{ImageResolver: &recordingResolver{err: errors.New("registry unavailable")}})
+	if err != nil {
+		t.Fatalf("Failed to convert Dockerfile: %v", err)
+	}
+	if diff := cmp.Diff(raw, strings.TrimSpace(converted.String())); diff != "" {
+		t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+	}
+	if len(converted.Diagnostics) != 2 {
+		t.Fatalf("got %d diagnostics, want 2: %v", len(converted.Diagnostics), converted.Diagnostics)
+	}
+	for _, d := range converted.Diagnostics {
+		if d.Code != DiagnosticImageResolverError || d.Severity != SeverityWarning {
+			t.Errorf("unexpected diagnostic %v", d)
+		}
+	}
+}
+
+func TestSplitRunFlags(t *testing.T) {
+	tests := []struct {
+		args      string
+		flags     []string
+		command   string
+		mountFrom []string
+	}{
+		{args: "make", command: "make"},
+		{args: "--network=none make", flags: []string{"--network=none"}, command: "make"},
+		{
+			args:      "--mount=type=bind,from=build,target=/b \\\n    --mount=type=cache,target=/c make all",
+			flags:     []string{"--mount=type=bind,from=build,target=/b", "--mount=type=cache,target=/c"},
+			command:   "make all",
+			mountFrom: []string{"build"},
+		},
+		{args: "--mount=from=node:18 ls", flags: []string{"--mount=from=node:18"}, command: "ls", mountFrom: []string{"node:18"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			flags, command := splitRunFlags(tt.args)
+			if diff := cmp.Diff(tt.flags, flags); diff != "" {
+				t.Errorf("splitRunFlags() flags mismatch (-want, +got):\n%s", diff)
+			}
+			if command != tt.command {
+				t.Errorf("splitRunFlags() command = %q, want %q", command, tt.command)
+			}
+			if diff := cmp.Diff(tt.mountFrom, parseMountFrom(flags)); diff != "" {
+				t.Errorf("parseMountFrom() mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSplitImageReference(t *testing.T) {
+	tests := []struct {
+		ref               string
+		base, tag, digest string
+	}{
+		{ref: "node", base: "node"},
+		{ref: "node:18", base: "node", tag: "18"},
+		{ref: "localhost:5000/node", base: "localhost:5000/node"},
+		{ref: "localhost:5000/node:18", base: "localhost:5000/node", tag: "18"},
+		{ref: "node:18@sha256:abc", base: "node", tag: "18", digest: "sha256:abc"},
+		{ref: "node@sha256:abc", base: "node", digest: "sha256:abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			base, tag, digest := splitImageReference(tt.ref)
+			if base != tt.base || tag != tt.tag || digest != tt.digest {
+				t.Errorf("splitImageReference(%q) = (%q, %q, %q), want (%q, %q, %q)",
+					tt.ref, base, tag, digest, tt.base, tt.tag, tt.digest)
+			}
+		})
+	}
+}
+
+func TestMirrorsOption(t *testing.T) {
+	raw := `FROM artifactory.corp/dockerhub-remote/bitnami/redis:7.2`
+	ctx := context.Background()
+	parsed, err := ParseDockerfile(ctx, []byte(raw))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+
+	opts := Options{
+		Organization:  "ORG",
+		NoBuiltIn:     true,
+		ExtraMappings: MappingsConfig{Images: map[string]string{"bitnami/*": "redis-bitnami"}},
+	}
+	converted, err := parsed.Convert(ctx, opts)
+	if err != nil {
+		t.Fatalf("Failed to convert Dockerfile: %v", err)
+	}
+	if got, want := strings.TrimSpace(converted.String()), "FROM cgr.dev/ORG/redis:7.2"; got != want {
+		t.Errorf("without mirrors: got %q, want %q", got, want)
+	}
+
+	opts.Mirrors = []string{"artifactory.*/dockerhub-*"}
+	converted, err = parsed.Convert(ctx, opts)
+	if err != nil {
+		t.Fatalf("Failed to convert Dockerfile: %v", err)
+	}
+	if got, want := strings.TrimSpace(converted.String()), "FROM cgr.dev/ORG/redis-bitnami:7.2"; got != want {
+		t.Errorf("with mirrors: got %q, want %q", got, want)
+	}
+
+	opts.Mirrors = []string{"artifactory[/dockerhub"}
+	if _, err := parsed.Convert(ctx, opts); err == nil {
+		t.Error("Convert() with an invalid mirror prefix expected an error")
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// chainguardImageName returns the name of the Chainguard image a reference points to, e.g.
+// "python" for cgr.dev/ORG/python, if the image is under cgr.dev or one of the registries
+func chainguardImageName(base string, registries []string) (string, bool) {
+	// Longer registries first, so that r.example.com/cg/mirror wins over r.example.com/cg
+	registries = slices.Clone(registries)
+	slices.SortFunc(registries, func(a, b string) int { return cmp.Compare(len(b), len(a)) })
+	for _, registry := range registries {
+		registry = strings.TrimSuffix(registry, "/")
+		if name, ok := strings.CutPrefix(base, registry+"/"); ok && registry != "" && name != "" {
+			return name, true
+		}
+	}
+
+	// cgr.dev/<org>/<image>
+	rest, ok := strings.CutPrefix(base, DefaultRegistryDomain+"/")
+	if !ok {
+		return "", false
+	}
+	_, name, ok := strings.Cut(rest, "/")
+	if !ok || name == "" {
+		return "", false
+	}
+	return name, true
+}
+
+// retargetImage moves a reference to a Chainguard image to the registry and organization of
+// the conversion, keeping its tag and digest. It returns false for other images.
+func (r *DefaultImageResolver) retargetImage(ref ImageReference) (string, bool) {
+	name, ok := chainguardImageName(ref.Base, append([]string{r.Registry}, r.FromRegistries...))
+	if !ok {
+		return "", false
+	}
+	imageRef := buildImageReference(name, ref.Tag, r.Registry, r.Organization)
+	if ref.Digest != "" {
+		imageRef += "@" + ref.Digest
+	}
+	return imageRef, true
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestChainguardImageName(t *testing.T) {
+	tests := []struct {
+		base       string
+		registries []string
+		name       string
+		ok         bool
+	}{
+		{base: "cgr.dev/ORG/python", name: "python", ok: true},
+		{base: "cgr.dev/chainguard-private/jdk", name: "jdk", ok: true},
+		{base: "cgr.dev/python"},
+		{base: "python"},
+		{base: "docker.io/library/python"},
+		{base: "r.example.com/cg/node", registries: []string{"r.example.com/cg/"}, name: "node", ok: true},
+		{base: "r.example.com/cg/mirror/node", registries: []string{"r.example.com/cg", "r.example.com/cg/mirror"}, name: "node", ok: true},
+		{base: "r.example.com/other/node", registries: []string{"r.example.com/cg", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.base, func(t *testing.T) {
+			name, ok := chainguardImageName(tt.base, tt.registries)
+			if name != tt.name || ok != tt.ok {
+				t.Errorf("chainguardImageName() = %q, %v, want %q, %v", name, ok, tt.name, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRetargetConvertedImages(t *testing.T) {
+	raw := `ARG BASE=cgr.dev/ORG/node:20-dev
+FROM ${BASE} AS build
+RUN npm ci
+FROM cgr.dev/chainguard/python:3.12@sha256:abc AS app
+COPY --from=r.example.com/cg/static:latest /etc/passwd /etc/passwd
+FROM r.example.com/cg/wolfi-base`
+
+	testCases := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			name: "cgr.dev images move to the organization",
+			opts: Options{Organization: "example.com"},
+			expected: `ARG BASE=cgr.dev/example.com/node:20-dev
+FROM ${BASE} AS build
+RUN npm ci
+FROM cgr.dev/example.com/python:3.12@sha256:abc AS app
+COPY --from=cgr.dev/example.com/static:latest /etc/passwd /etc/passwd
+FROM cgr.dev/example.com/wolfi-base:latest`,
+		},
+		{
+			name: "images of the registry given by --from-registry move too",
+			opts: Options{Registry: "m.example.com/chainguard", FromRegistries: []string{"r.example.com/cg"}},
+			expected: `ARG BASE=m.example.com/chainguard/node:20-dev
+FROM ${BASE} AS build
+RUN npm ci
+FROM m.example.com/chainguard/python:3.12@sha256:abc AS app
+COPY --from=m.example.com/chainguard/static:latest /etc/passwd /etc/passwd
+FROM m.example.com/chainguard/wolfi-base`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, tc.opts)
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConvertingTwiceChangesNothing(t *testing.T) {
+	raw := `FROM python:3.12 AS build
+RUN apt-get update && apt-get install -y gcc
+FROM python:3.12-slim
+COPY --from=build /app /app
+CMD ["python", "app.py"]`
+
+	ctx := context.Background()
+	convert := func(raw string) string {
+		parsed, err := ParseDockerfile(ctx, []byte(raw))
+		if err != nil {
+			t.Fatalf("Failed to parse Dockerfile: %v", err)
+		}
+		converted, err := parsed.Convert(ctx, Options{Organization: "example.com"})
+		if err != nil {
+			t.Fatalf("Failed to convert Dockerfile: %v", err)
+		}
+		return converted.String()
+	}
+
+	once := convert(raw)
+	if diff := cmp.Diff(once, convert(once)); diff != "" {
+		t.Errorf("second conversion changed the Dockerfile (-first, +second):\n%s", diff)
+	}
+}
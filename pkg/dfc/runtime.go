@@ -0,0 +1,310 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Directives that only describe how the image runs, kept in the runtime stage only
+var runtimeDirectives = []string{"CMD", "ENTRYPOINT", "EXPOSE", "HEALTHCHECK", "LABEL", "ONBUILD", "STOPSIGNAL", "VOLUME"}
+
+// Directives that set up the environment of later lines, kept in both the builder and the runtime stage
+var environmentDirectives = []string{DirectiveArg, DirectiveEnv, "WORKDIR", DirectiveUser}
+
+// stageFromRegexp matches numeric stage references in COPY --from and RUN --mount flags
+var stageFromRegexp = regexp.MustCompile(`(--from=|--mount=\S*\bfrom=)(\d+)\b`)
+
+// splitRuntimeStage splits the final stage of a converted Dockerfile that was given a -dev image
+// into a builder stage, which keeps the -dev image and every build step, and a minimal runtime
+// stage that copies the files the final stage wrote from the builder. It returns the lines of
+// the restructured Dockerfile.
+func splitRuntimeStage(cc *ConversionContext, lines []*DockerfileLine) []*DockerfileLine {
+	fromIndex := -1
+	for i, line := range lines {
+		if line.From != nil {
+			fromIndex = i
+		}
+	}
+	if fromIndex < 0 {
+		return lines
+	}
+	fromLine := lines[fromIndex]
+	stage := cc.StageByIndex(fromLine.Stage)
+	if !stage.HasRun || fromLine.From.Parent > 0 || fromLine.Converted == "" {
+		return lines
+	}
+	base, tag, digest := splitImageReference(stage.ConvertedFrom)
+	runtimeTag, ok := strings.CutSuffix(tag, "-dev")
+	if !ok || digest != "" || strings.Contains(base, "$") {
+		return lines
+	}
+	// Files written by RUN lines, such as the site-packages of pip install, are not known, so only
+	// stages whose RUN lines just install packages are split
+	for _, line := range lines[fromIndex+1:] {
+		if line.Run != nil && !installsPackagesOnly(line) {
+			cc.enterConvertedLine(line)
+			cc.report(SeverityError, DiagnosticRuntimeStageSplit,
+				"the final stage is not split: this RUN line does more than install packages, and the files it writes would be missing from the runtime stage")
+			return lines
+		}
+	}
+	cc.enterLine(fromIndex)
+
+	// The runtime image has no package manager to install the packages of the stage again
+	if len(stage.Packages) > 0 {
+		cc.report(SeverityError, DiagnosticRuntimeStageSplit,
+			"the final stage is not split: the packages it installs (%s) could not be installed in the runtime stage",
+			strings.Join(stage.Packages, ", "))
+		return lines
+	}
+
+	// Collect the files written by the stage, resolved against its working directory
+	var paths []string
+	workdir := "/"
+	for _, line := range lines[fromIndex+1:] {
+		directive, args := splitDirective(lineText(line))
+		switch directive {
+		case "WORKDIR":
+			workdir = resolveStagePath(workdir, args)
+			paths = append(paths, workdir)
+		case DirectiveCopy, "ADD":
+			if dest := copyDestination(args); dest != "" {
+				paths = append(paths, resolveStagePath(workdir, dest))
+			}
+		}
+	}
+	paths = outermostPaths(paths)
+	if len(paths) == 0 {
+		cc.report(SeverityInfo, DiagnosticRuntimeStageSplit,
+			"the final stage is not split: it has no WORKDIR or copied files to move to a runtime stage")
+		return lines
+	}
+
+	builderAlias := uniqueStageAlias(cc, fromLine.From.Alias)
+	runtimeStage := fromLine.Stage + 1
+
+	// The builder keeps the converted FROM line, renamed, and every build step
+	builderFrom := *fromLine
+	builderFrom.From = copyFromDetails(fromLine.From)
+	builderFrom.From.Alias = builderAlias
+	cc.originals[&builderFrom] = cc.originals[fromLine]
+	builderFrom.Converted = renderFromLine(fromLine.From.Platform, stage.ConvertedFrom, builderAlias)
+	if _, rest, ok := strings.Cut(fromLine.Converted, "\n"); ok {
+		builderFrom.Converted += "\n" + rest
+	}
+
+	runtimeRef := base + ":" + runtimeTag
+	runtimeFrom := &DockerfileLine{
+		Extra:     "\n",
+		Converted: renderFromLine(fromLine.From.Platform, runtimeRef, fromLine.From.Alias),
+		Stage:     runtimeStage,
+		From:      copyFromDetails(fromLine.From),
+	}
+	runtimeFrom.From.Tag = runtimeTag
+	runtimeFrom.From.Parent = 0
+	cc.originals[runtimeFrom] = cc.originals[fromLine]
+	cc.StageByIndex(runtimeStage).ConvertedFrom = runtimeRef
+
+	result := slices.Clone(lines[:fromIndex])
+	result = append(result, &builderFrom)
+	var runtimeLines []*DockerfileLine
+	for _, line := range lines[fromIndex+1:] {
+		directive, _ := splitDirective(lineInstruction(line))
+		switch {
+		case slices.Contains(runtimeDirectives, directive):
+			moved := *line
+			moved.Stage = runtimeStage
+			if i, ok := cc.originals[line]; ok {
+				cc.originals[&moved] = i
+			}
+			runtimeLines = append(runtimeLines, &moved)
+		case slices.Contains(environmentDirectives, directive):
+			result = append(result, line)
+			copied := &DockerfileLine{Converted: lineText(line), Stage: runtimeStage}
+			if i, ok := cc.originals[line]; ok {
+				cc.originals[copied] = i
+			}
+			runtimeLines = append(runtimeLines, copied)
+		default:
+			result = append(result, line)
+		}
+	}
+
+	result = append(result, runtimeFrom)
+	for _, p := range paths {
+		copyLine := &DockerfileLine{
+			Converted: fmt.Sprintf("%s --from=%s %s %s", DirectiveCopy, builderAlias, p, p),
+			Stage:     runtimeStage,
+			Copy:      &CopyDetails{From: builderAlias},
+		}
+		cc.originals[copyLine] = cc.originals[fromLine]
+		result = append(result, copyLine)
+	}
+	result = append(result, runtimeLines...)
+
+	// Stages after the builder move up by one
+	renumberStageReferences(result[fromIndex+1:], fromLine.Stage+1)
+
+	cc.report(SeverityInfo, DiagnosticRuntimeStageSplit,
+		"the final stage was split into the builder stage %s and a runtime stage using %s", builderAlias, runtimeRef)
+
+	return result
+}
+
+// installsPackagesOnly reports whether every command of a RUN line is an apk command, or the
+// "true" left behind by package manager commands the conversion removed
+func installsPackagesOnly(line *DockerfileLine) bool {
+	if line.Run.Shell == nil {
+		return false
+	}
+	shell := line.Run.Shell.After
+	if shell == nil {
+		shell = line.Run.Shell.Before
+	}
+	if shell == nil || len(shell.Parts) == 0 {
+		return false
+	}
+	for _, part := range shell.Parts {
+		if command := path.Base(part.Command); command != "apk" && command != "true" {
+			return false
+		}
+	}
+	return true
+}
+
+// renumberStageReferences increments the numeric stage references of the lines that name a
+// stage at or after the given stage number, after a stage was inserted before it
+func renumberStageReferences(lines []*DockerfileLine, from int) {
+	renumber := func(s string) string {
+		return stageFromRegexp.ReplaceAllStringFunc(s, func(m string) string {
+			groups := stageFromRegexp.FindStringSubmatch(m)
+			n, err := strconv.Atoi(groups[2])
+			if err != nil || n < from {
+				return m
+			}
+			return groups[1] + strconv.Itoa(n+1)
+		})
+	}
+	for _, line := range lines {
+		if line.Converted != "" {
+			line.Converted = renumber(line.Converted)
+		} else if stageFromRegexp.MatchString(line.Raw) {
+			line.Converted = renumber(line.Raw)
+		}
+		if line.Copy != nil {
+			if n, err := strconv.Atoi(line.Copy.From); err == nil && n >= from {
+				line.Copy.From = strconv.Itoa(n + 1)
+			}
+		}
+	}
+}
+
+// renderFromLine renders a FROM line
+func renderFromLine(platform, imageRef, alias string) string {
+	fromLine := DirectiveFrom
+	if platform != "" {
+		fromLine += " --platform=" + platform
+	}
+	fromLine += " " + imageRef
+	if alias != "" {
+		fromLine += " " + KeywordAs + " " + alias
+	}
+	return fromLine
+}
+
+// uniqueStageAlias returns an alias for the builder of a stage that no other stage uses
+func uniqueStageAlias(cc *ConversionContext, alias string) string {
+	if alias == "" {
+		alias = "runtime"
+	}
+	candidate := alias + "-dev"
+	for i := 2; cc.LookupStage(candidate) != nil; i++ {
+		candidate = fmt.Sprintf("%s-dev%d", alias, i)
+	}
+	return candidate
+}
+
+// lineText returns the text of a line in the converted Dockerfile
+func lineText(line *DockerfileLine) string {
+	if line.Converted != "" {
+		return line.Converted
+	}
+	return line.Raw
+}
+
+// lineInstruction returns the instruction of a line, without the lines a conversion added to it
+func lineInstruction(line *DockerfileLine) string {
+	if line.Raw != "" {
+		return line.Raw
+	}
+	return line.Converted
+}
+
+// splitDirective returns the upper-cased directive of an instruction and its arguments
+func splitDirective(text string) (string, string) {
+	directive, args, _ := strings.Cut(strings.TrimSpace(text), " ")
+	return strings.ToUpper(directive), strings.TrimSpace(args)
+}
+
+// copyDestination returns the destination of the arguments of a COPY or ADD instruction
+func copyDestination(args string) string {
+	args = strings.ReplaceAll(args, "\\\n", " ")
+	var fields []string
+	for _, field := range strings.Fields(args) {
+		if len(fields) == 0 && strings.HasPrefix(field, "--") {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "[") {
+		var exec []string
+		if err := json.Unmarshal([]byte(strings.Join(fields, " ")), &exec); err != nil || len(exec) < 2 {
+			return ""
+		}
+		return exec[len(exec)-1]
+	}
+	if len(fields) < 2 {
+		return ""
+	}
+	return fields[len(fields)-1]
+}
+
+// resolveStagePath resolves a path of an instruction against the working directory
+func resolveStagePath(workdir, p string) string {
+	p = strings.Trim(p, `"`)
+	if !path.IsAbs(p) && !strings.HasPrefix(p, "$") {
+		p = path.Join(workdir, p)
+	}
+	return path.Clean(p)
+}
+
+// outermostPaths removes duplicates, "/" and the paths inside another path of the list
+func outermostPaths(paths []string) []string {
+	var result []string
+	for _, p := range paths {
+		if p == "/" || slices.Contains(result, p) {
+			continue
+		}
+		nested := false
+		for _, other := range paths {
+			if other != p && other != "/" && strings.HasPrefix(p, strings.TrimSuffix(other, "/")+"/") {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			result = append(result, p)
+		}
+	}
+	return result
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplitRuntimeStage(t *testing.T) {
+	testCases := []struct {
+		name        string
+		raw         string
+		expected    string
+		diagnostics []string
+	}{
+		{
+			name: "final stage with installs",
+			raw: `FROM python:3.12-slim AS app
+WORKDIR /srv
+COPY requirements.txt .
+RUN apt-get update && apt-get install -y libpq-dev
+ENV PORT=8080
+EXPOSE 8080
+CMD ["main.py"]`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev AS app
+WORKDIR /srv
+COPY requirements.txt .
+USER root
+RUN apk add --no-cache postgresql-dev
+ENV PORT=8080
+EXPOSE 8080
+CMD ["main.py"]
+USER nonroot`,
+			diagnostics: []string{"the final stage is not split: the packages it installs (postgresql-dev) could not be installed in the runtime stage"},
+		},
+		{
+			name: "final stage that only updates the package index",
+			raw: `FROM python:3.12-slim AS app
+WORKDIR /srv
+COPY requirements.txt .
+RUN apt-get update
+ENV PORT=8080
+EXPOSE 8080
+CMD ["main.py"]`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev AS app-dev
+WORKDIR /srv
+COPY requirements.txt .
+USER root
+RUN true
+ENV PORT=8080
+USER nonroot
+
+FROM cgr.dev/ORG/python:3.12 AS app
+COPY --from=app-dev /srv /srv
+WORKDIR /srv
+ENV PORT=8080
+EXPOSE 8080
+CMD ["main.py"]`,
+			diagnostics: []string{"the final stage was split into the builder stage app-dev and a runtime stage using cgr.dev/ORG/python:3.12"},
+		},
+		{
+			name: "unnamed final stage copying from an earlier stage",
+			raw: `FROM golang:1.23 AS build
+RUN go build -o /out/app .
+FROM node:20
+COPY --from=0 /out/app /usr/local/bin/app
+COPY ["package.json", "/app/"]
+RUN apt-get update
+ENTRYPOINT ["app"]`,
+			expected: `FROM cgr.dev/ORG/go:1.23-dev AS build
+RUN go build -o /out/app .
+FROM cgr.dev/ORG/node:20-dev AS runtime-dev
+COPY --from=0 /out/app /usr/local/bin/app
+COPY ["package.json", "/app/"]
+USER root
+RUN true
+USER node
+
+FROM cgr.dev/ORG/node:20
+COPY --from=runtime-dev /usr/local/bin/app /usr/local/bin/app
+COPY --from=runtime-dev /app /app
+ENTRYPOINT ["app"]`,
+			diagnostics: []string{"the final stage was split into the builder stage runtime-dev and a runtime stage using cgr.dev/ORG/node:20"},
+		},
+		{
+			name: "builder alias already taken",
+			raw: `FROM node:20 AS app-dev
+FROM node:20 AS app
+WORKDIR /app
+RUN apt-get update`,
+			expected: `FROM cgr.dev/ORG/node:20 AS app-dev
+FROM cgr.dev/ORG/node:20-dev AS app-dev2
+WORKDIR /app
+USER root
+RUN true
+USER node
+
+FROM cgr.dev/ORG/node:20 AS app
+COPY --from=app-dev2 /app /app
+WORKDIR /app`,
+			diagnostics: []string{"the final stage was split into the builder stage app-dev2 and a runtime stage using cgr.dev/ORG/node:20"},
+		},
+		{
+			name: "RUN line writing files",
+			raw: `FROM python:3.12-slim
+WORKDIR /app
+COPY . .
+RUN apt-get install -y libpq-dev
+RUN pip install -r requirements.txt
+CMD ["main.py"]`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+WORKDIR /app
+COPY . .
+USER root
+RUN apk add --no-cache postgresql-dev
+RUN pip install -r requirements.txt
+CMD ["main.py"]
+USER nonroot`,
+			diagnostics: []string{"the final stage is not split: this RUN line does more than install packages, and the files it writes would be missing from the runtime stage"},
+		},
+		{
+			name: "final stage without RUN lines",
+			raw: `FROM node:20
+COPY app.js /app/`,
+			expected: `FROM cgr.dev/ORG/node:20
+COPY app.js /app/`,
+		},
+		{
+			name: "nothing to copy",
+			raw: `FROM node:20
+RUN apt-get update`,
+			expected: `FROM cgr.dev/ORG/node:20-dev
+USER root
+RUN true
+USER node`,
+			diagnostics: []string{"the final stage is not split: it has no WORKDIR or copied files to move to a runtime stage"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{Organization: "ORG", SplitRuntimeStage: true})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var diagnostics []string
+			for _, d := range converted.Diagnostics {
+				if d.Code == DiagnosticRuntimeStageSplit {
+					diagnostics = append(diagnostics, d.Message)
+				}
+			}
+			if diff := cmp.Diff(tc.diagnostics, diagnostics); diff != "" {
+				t.Errorf("diagnostics not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSplitRuntimeStageDiagnosticLines(t *testing.T) {
+	ctx := context.Background()
+	parsed, err := ParseDockerfile(ctx, []byte(`FROM node:20 AS app
+WORKDIR /app
+COPY . .
+RUN apt-get update
+CMD ["app.js"]`))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	converted, err := parsed.Convert(ctx, Options{Organization: "ORG", SplitRuntimeStage: true})
+	if err != nil {
+		t.Fatalf("Failed to convert Dockerfile: %v", err)
+	}
+
+	// The COPY --from lines of the runtime stage are reported at the FROM line of the final stage
+	var lines []int
+	for _, d := range converted.Diagnostics {
+		if d.Code == DiagnosticCopyWithoutChown {
+			lines = append(lines, d.Line)
+		}
+	}
+	if diff := cmp.Diff([]int{1}, lines); diff != "" {
+		t.Errorf("diagnostic lines not as expected (-want, +got):\n%s", diff)
+	}
+}
+
+func TestRenumberStageReferences(t *testing.T) {
+	lines := []*DockerfileLine{
+		{Raw: "COPY --from=0 /a /a", Copy: &CopyDetails{From: "0"}},
+		{Raw: "COPY --from=2 /b /b", Copy: &CopyDetails{From: "2"}},
+		{Raw: "RUN --mount=type=cache,from=3,target=/c make", Converted: "RUN --mount=type=cache,from=3,target=/c make"},
+		{Raw: "COPY --from=build /d /d", Copy: &CopyDetails{From: "build"}},
+	}
+	renumberStageReferences(lines, 1)
+
+	var got []string
+	for _, line := range lines {
+		got = append(got, lineText(line))
+	}
+	expected := []string{
+		"COPY --from=0 /a /a",
+		"COPY --from=3 /b /b",
+		"RUN --mount=type=cache,from=4,target=/c make",
+		"COPY --from=build /d /d",
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("renumberStageReferences() mismatch (-want, +got):\n%s", diff)
+	}
+	if lines[1].Copy.From != "3" {
+		t.Errorf("Copy.From = %q, want %q", lines[1].Copy.From, "3")
+	}
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Minimal Chainguard runtime images
+const (
+	RuntimeImageStatic       = "static"        // For statically linked binaries
+	RuntimeImageGlibcDynamic = "glibc-dynamic" // For binaries dynamically linked against glibc
+)
+
+// genericRuntimeImages are the Chainguard images that general purpose OS images map to, which
+// are replaced by a minimal runtime image when the final stage only copies binaries
+var genericRuntimeImages = []string{"chainguard-base", "wolfi-base"}
+
+// RuntimeAnalysis explains the runtime image picked for a final stage that only copies files
+// built in other stages
+type RuntimeAnalysis struct {
+	Stage   int      `json:"stage"`             // The final stage
+	Image   string   `json:"image,omitempty"`   // Smallest suitable Chainguard image, empty if none could be picked
+	Applied bool     `json:"applied,omitempty"` // Whether the FROM line of the final stage was changed to Image
+	Reasons []string `json:"reasons"`           // How the builder stages were analyzed
+}
+
+// Toolchains recognized from the base image of a builder stage
+var toolchainImages = map[string]string{
+	"golang":         "go",
+	"go":             "go",
+	"rust":           "rust",
+	"gcc":            "c",
+	"buildpack-deps": "c",
+}
+
+// Language runtimes recognized from the base image of a builder stage, whose output needs the
+// runtime in the final image
+var runtimeImages = map[string]string{
+	"python":          "Python",
+	"node":            "Node.js",
+	"ruby":            "Ruby",
+	"php":             "PHP",
+	"openjdk":         "Java",
+	"eclipse-temurin": "Java",
+	"amazoncorretto":  "Java",
+	"maven":           "Java",
+	"gradle":          "Java",
+}
+
+// Linkage of the files a builder stage produces
+const (
+	linkageStatic  = "static"
+	linkageGlibc   = "glibc"
+	linkageMusl    = "musl"
+	linkageRuntime = "runtime"
+	linkageUnknown = "unknown"
+)
+
+// analyzeRuntimeStage picks the smallest Chainguard image for a final stage that has no RUN
+// lines and copies files from other stages, by looking at how those stages build them. It
+// returns nil if the final stage is not such a stage.
+func analyzeRuntimeStage(cc *ConversionContext, lines []*DockerfileLine) *RuntimeAnalysis {
+	if len(cc.Stages) == 0 {
+		return nil
+	}
+	final := cc.Stages[len(cc.Stages)-1]
+	if final.HasRun || final.From == nil || final.From.Parent > 0 || final.From.BaseDynamic || final.From.Base == "scratch" {
+		return nil
+	}
+
+	var sources []*StageContext
+	for _, line := range lines {
+		if line.Stage != final.Index || line.Copy == nil {
+			continue
+		}
+		source := cc.LookupStage(line.Copy.From)
+		if source == nil {
+			return nil // Files copied from an image or an ARG cannot be analyzed
+		}
+		if !slices.Contains(sources, source) {
+			sources = append(sources, source)
+		}
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+
+	analysis := &RuntimeAnalysis{Stage: final.Index}
+	linkages := make(map[string]bool)
+	for _, source := range sources {
+		linkage, reason := analyzeBuilderStage(cc, lines, source)
+		linkages[linkage] = true
+		analysis.Reasons = append(analysis.Reasons, reason)
+	}
+
+	switch {
+	case linkages[linkageUnknown]:
+		analysis.Reasons = append(analysis.Reasons, "no runtime image is picked as the linkage of some copied files is unknown")
+	case linkages[linkageRuntime]:
+		analysis.Reasons = append(analysis.Reasons, "no minimal runtime image is picked as the copied files need a language runtime")
+	case linkages[linkageMusl]:
+		analysis.Reasons = append(analysis.Reasons, "no runtime image is picked as there is no minimal image for binaries dynamically linked against musl")
+	case linkages[linkageGlibc]:
+		analysis.Image = RuntimeImageGlibcDynamic
+		analysis.Reasons = append(analysis.Reasons, "glibc-dynamic provides glibc for the dynamically linked binaries")
+	default:
+		analysis.Image = RuntimeImageStatic
+		analysis.Reasons = append(analysis.Reasons, "static is enough as every copied binary is statically linked")
+	}
+	return analysis
+}
+
+// analyzeBuilderStage returns the linkage of the files built by a stage and the reasoning
+func analyzeBuilderStage(cc *ConversionContext, lines []*DockerfileLine, stage *StageContext) (string, string) {
+	// Stages built on top of other stages inherit their base image and build steps
+	chain := []*StageContext{stage}
+	root := stage
+	for root.From != nil && root.From.Parent > 0 {
+		root = cc.StageByIndex(root.From.Parent)
+		chain = append(chain, root)
+	}
+	name := stageName(stage)
+	if root.From == nil || root.From.BaseDynamic {
+		return linkageUnknown, fmt.Sprintf("stage %s has a dynamic base image", name)
+	}
+
+	image := filepath.Base(root.From.Base)
+	musl := image == "alpine" || strings.HasPrefix(root.From.OS, "alpine")
+	if runtime, ok := runtimeImages[image]; ok {
+		return linkageRuntime, fmt.Sprintf("stage %s builds on %s and its output needs the %s runtime", name, image, runtime)
+	}
+	toolchain, ok := toolchainImages[image]
+	if !ok {
+		return linkageUnknown, fmt.Sprintf("stage %s builds on %s, which is not a known toolchain image", name, image)
+	}
+
+	var text strings.Builder
+	for _, line := range lines {
+		for _, s := range chain {
+			if line.Stage == s.Index {
+				text.WriteString(line.Raw)
+				text.WriteString("\n")
+			}
+		}
+	}
+	steps := text.String()
+
+	switch toolchain {
+	case "go":
+		switch {
+		case strings.Contains(steps, "CGO_ENABLED=1"):
+		case strings.Contains(steps, "CGO_ENABLED=0"):
+			return linkageStatic, fmt.Sprintf("stage %s builds Go with CGO_ENABLED=0, so its binaries are statically linked", name)
+		case musl:
+			return linkageStatic, fmt.Sprintf("stage %s builds Go on %s, which has no C toolchain, so its binaries are statically linked", name, image)
+		default:
+			return linkageGlibc, fmt.Sprintf("stage %s builds Go with cgo, enabled by default on %s, so its binaries are dynamically linked against glibc", name, image)
+		}
+	case "rust":
+		if musl || strings.Contains(steps, "musl") {
+			return linkageStatic, fmt.Sprintf("stage %s builds Rust for musl, so its binaries are statically linked", name)
+		}
+	case "c":
+		if strings.Contains(steps, "-static") {
+			return linkageStatic, fmt.Sprintf("stage %s links with -static", name)
+		}
+	}
+	if musl {
+		return linkageMusl, fmt.Sprintf("stage %s builds on %s, so its binaries are dynamically linked against musl", name, image)
+	}
+	return linkageGlibc, fmt.Sprintf("stage %s builds on %s with cgo or a C toolchain, so its binaries are dynamically linked against glibc", name, image)
+}
+
+// stageName returns the alias of a stage, or its number
+func stageName(stage *StageContext) string {
+	if stage.Alias != "" {
+		return stage.Alias
+	}
+	return fmt.Sprint(stage.Index - 1)
+}
+
+// applyRuntimeAnalysis changes the FROM line of the final stage to the picked runtime image when
+// the stage uses a general purpose OS image
+func applyRuntimeAnalysis(cc *ConversionContext, analysis *RuntimeAnalysis, lines []*DockerfileLine, registry, org string) {
+	if analysis.Image == "" {
+		return
+	}
+	for i, line := range lines {
+		if line.From == nil || line.Stage != analysis.Stage || line.Converted == "" {
+			continue
+		}
+		stage := cc.StageByIndex(line.Stage)
+		base, _, _ := splitImageReference(stage.ConvertedFrom)
+		current := filepath.Base(base)
+		if !slices.Contains(genericRuntimeImages, current) {
+			analysis.Reasons = append(analysis.Reasons, fmt.Sprintf("the final stage keeps %s, which is not a general purpose OS image", current))
+			return
+		}
+
+		// The minimal runtime images have no shell
+		var stageLines []*DockerfileLine
+		for _, l := range lines[i+1:] {
+			if l.From != nil {
+				break
+			}
+			stageLines = append(stageLines, l)
+		}
+		if directive, ok := stageCommandNeedingShell(stageLines); ok {
+			analysis.Reasons = append(analysis.Reasons, fmt.Sprintf("the final stage keeps %s as its %s needs a shell, which %s does not have", current, directive, analysis.Image))
+			return
+		}
+
+		imageRef := buildImageReference(analysis.Image, "latest", registry, org)
+		line.Converted = renderFromLine(line.From.Platform, imageRef, line.From.Alias)
+		stage.ConvertedFrom = imageRef
+		analysis.Applied = true
+		cc.enterLine(i)
+		cc.report(SeverityInfo, DiagnosticRuntimeImage, "using %s instead of %s for the final stage, which only copies binaries built in other stages", analysis.Image, current)
+		return
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRuntimeImageAnalysis(t *testing.T) {
+	testCases := []struct {
+		name     string
+		raw      string
+		expected string
+		analysis *RuntimeAnalysis
+	}{
+		{
+			name: "static Go binary",
+			raw: `FROM golang:1.23 AS builder
+RUN CGO_ENABLED=0 go build -o /app .
+FROM debian:bookworm-slim
+COPY --from=builder /app /app
+ENTRYPOINT ["/app"]`,
+			expected: `FROM cgr.dev/ORG/go:1.23-dev AS builder
+RUN CGO_ENABLED=0 go build -o /app .
+FROM cgr.dev/ORG/static:latest
+COPY --from=builder /app /app
+ENTRYPOINT ["/app"]`,
+			analysis: &RuntimeAnalysis{Stage: 2, Image: RuntimeImageStatic, Applied: true, Reasons: []string{
+				"stage builder builds Go with CGO_ENABLED=0, so its binaries are statically linked",
+				"static is enough as every copied binary is statically linked",
+			}},
+		},
+		{
+			name: "Go with cgo",
+			raw: `FROM golang:1.23
+ENV CGO_ENABLED=1
+RUN go build -o /app .
+FROM ubuntu:24.04 AS final
+COPY --from=0 /app /app`,
+			expected: `FROM cgr.dev/ORG/go:1.23-dev
+ENV CGO_ENABLED=1
+RUN go build -o /app .
+FROM cgr.dev/ORG/glibc-dynamic:latest AS final
+COPY --from=0 /app /app`,
+			analysis: &RuntimeAnalysis{Stage: 2, Image: RuntimeImageGlibcDynamic, Applied: true, Reasons: []string{
+				"stage 0 builds on golang with cgo or a C toolchain, so its binaries are dynamically linked against glibc",
+				"glibc-dynamic provides glibc for the dynamically linked binaries",
+			}},
+		},
+		{
+			name: "Rust on alpine through a parent stage",
+			raw: `FROM rust:1.80-alpine AS deps
+RUN cargo fetch
+FROM deps AS build
+RUN cargo build --release
+FROM alpine:3.20
+COPY --from=build /target/release/app /usr/local/bin/app`,
+			expected: `FROM cgr.dev/ORG/rust:1.80-dev AS deps
+RUN cargo fetch
+FROM deps AS build
+RUN cargo build --release
+FROM cgr.dev/ORG/static:latest
+COPY --from=build /target/release/app /usr/local/bin/app`,
+			analysis: &RuntimeAnalysis{Stage: 3, Image: RuntimeImageStatic, Applied: true, Reasons: []string{
+				"stage build builds Rust for musl, so its binaries are statically linked",
+				"static is enough as every copied binary is statically linked",
+			}},
+		},
+		{
+			name: "entrypoint needing a shell",
+			raw: `FROM golang:1.23 AS builder
+RUN CGO_ENABLED=0 go build -o /usr/local/bin/app .
+FROM debian:bookworm-slim
+COPY --from=builder /usr/local/bin/app /usr/local/bin/app
+ENTRYPOINT /usr/local/bin/app --port $PORT 2>&1 | tee /tmp/log`,
+			expected: `FROM cgr.dev/ORG/go:1.23-dev AS builder
+RUN CGO_ENABLED=0 go build -o /usr/local/bin/app .
+FROM cgr.dev/ORG/chainguard-base:latest
+COPY --from=builder /usr/local/bin/app /usr/local/bin/app
+ENTRYPOINT /usr/local/bin/app --port $PORT 2>&1 | tee /tmp/log`,
+			analysis: &RuntimeAnalysis{Stage: 2, Image: RuntimeImageStatic, Reasons: []string{
+				"stage builder builds Go with CGO_ENABLED=0, so its binaries are statically linked",
+				"static is enough as every copied binary is statically linked",
+				"the final stage keeps chainguard-base as its ENTRYPOINT needs a shell, which static does not have",
+			}},
+		},
+		{
+			name: "language runtime",
+			raw: `FROM node:20 AS build
+RUN npm ci
+FROM debian:bookworm-slim
+COPY --from=build /app /app`,
+			expected: `FROM cgr.dev/ORG/node:20-dev AS build
+RUN npm ci
+FROM cgr.dev/ORG/chainguard-base:latest
+COPY --from=build /app /app`,
+			analysis: &RuntimeAnalysis{Stage: 2, Reasons: []string{
+				"stage build builds on node and its output needs the Node.js runtime",
+				"no minimal runtime image is picked as the copied files need a language runtime",
+			}},
+		},
+		{
+			name: "final stage that is not a general purpose OS image",
+			raw: `FROM golang:1.23 AS build
+RUN go build -o /app .
+FROM gcr.io/distroless/static-debian12
+COPY --from=build /app /app`,
+			expected: `FROM cgr.dev/ORG/go:1.23-dev AS build
+RUN go build -o /app .
+FROM cgr.dev/ORG/static:latest
+COPY --from=build /app /app`,
+			analysis: &RuntimeAnalysis{Stage: 2, Image: RuntimeImageGlibcDynamic, Reasons: []string{
+				"stage build builds Go with cgo, enabled by default on golang, so its binaries are dynamically linked against glibc",
+				"glibc-dynamic provides glibc for the dynamically linked binaries",
+				"the final stage keeps static, which is not a general purpose OS image",
+			}},
+		},
+		{
+			name: "final stage with RUN lines",
+			raw: `FROM golang:1.23 AS build
+RUN CGO_ENABLED=0 go build -o /app .
+FROM alpine:3.20
+COPY --from=build /app /app
+RUN apk add curl`,
+			expected: `FROM cgr.dev/ORG/go:1.23-dev AS build
+RUN CGO_ENABLED=0 go build -o /app .
+FROM cgr.dev/ORG/chainguard-base:latest
+COPY --from=build /app /app
+RUN apk add --no-cache curl`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{Organization: "ORG"})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.analysis, converted.Runtime); diff != "" {
+				t.Errorf("runtime analysis not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
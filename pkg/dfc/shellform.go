@@ -0,0 +1,166 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DirectiveHealthcheck is the directive that sets the health check of a container
+const DirectiveHealthcheck = "HEALTHCHECK"
+
+// shellMetacharacters are the characters that make a shell-form command need a shell
+const shellMetacharacters = "$`\\\"'&|;<>()*?~[]{}#=\n"
+
+// healthcheckTools are the tools health checks commonly run, which images without a shell lack
+var healthcheckTools = []string{"curl", "wget"}
+
+// convertShellForms handles the shell-form CMD, ENTRYPOINT and HEALTHCHECK lines of stages built
+// on converted images without a shell, where they would fail at runtime. Simple commands are
+// converted to exec form, others are reported, as are health checks that run curl or wget.
+func convertShellForms(cc *ConversionContext, lines []*DockerfileLine, metadata map[string]ImageMetadata) {
+	shells := make(map[int]bool)
+
+	for start := 0; start < len(lines); {
+		end := start + 1
+		for end < len(lines) && lines[end].From == nil {
+			end++
+		}
+		if from := lines[start].From; from != nil {
+			// The shell of stages on images that are not converted is unknown, assume there is one
+			shell := true
+			if from.Parent > 0 {
+				shell = shells[from.Parent]
+			} else if imageRef := cc.convertedImage(lines[start]); imageRef != "" {
+				shell = imageHasShell(imageRef, metadata)
+			}
+			if !shell {
+				convertStageShellForms(cc, lines, start, end)
+			}
+			shells[lines[start].Stage] = shell
+		}
+		start = end
+	}
+}
+
+// imageHasShell reports whether a Chainguard image has a shell: -dev images have one, others only
+// if their metadata says so
+func imageHasShell(imageRef string, metadata map[string]ImageMetadata) bool {
+	base, tag, _ := splitImageReference(imageRef)
+	if strings.HasSuffix(tag, "-dev") {
+		return true
+	}
+	shell := metadata[filepath.Base(base)].Shell
+	return shell != nil && *shell
+}
+
+// stageHasCmd reports whether the lines of a stage set its CMD
+func stageHasCmd(lines []*DockerfileLine) bool {
+	return slices.ContainsFunc(lines, func(line *DockerfileLine) bool {
+		directive, _ := splitDirective(lineText(line))
+		return directive == DirectiveCmd
+	})
+}
+
+// stageCommandNeedingShell returns the CMD, ENTRYPOINT or HEALTHCHECK of the lines of a stage
+// that only runs with a shell, as it uses shell syntax or is a shell-form ENTRYPOINT that ignores
+// the CMD. It returns false if every command runs without a shell.
+func stageCommandNeedingShell(lines []*DockerfileLine) (string, bool) {
+	hasCmd := stageHasCmd(lines)
+	for _, line := range lines {
+		directive, args := splitDirective(lineText(line))
+		switch directive {
+		case DirectiveCmd, DirectiveEntrypoint:
+		case DirectiveHealthcheck:
+			var ok bool
+			if _, args, ok = splitHealthcheck(args); !ok {
+				continue
+			}
+		default:
+			continue
+		}
+		if _, isExec := parseExecForm(args); isExec {
+			continue
+		}
+		if strings.ContainsAny(args, shellMetacharacters) || args == "" || (directive == DirectiveEntrypoint && hasCmd) {
+			return directive, true
+		}
+	}
+	return "", false
+}
+
+// convertStageShellForms converts the shell-form commands of the stage made of lines[start:end]
+func convertStageShellForms(cc *ConversionContext, lines []*DockerfileLine, start, end int) {
+	hasCmd := stageHasCmd(lines[start+1 : end])
+
+	for _, line := range lines[start+1 : end] {
+		directive, args := splitDirective(lineText(line))
+		prefix := directive + " "
+		switch directive {
+		case DirectiveCmd, DirectiveEntrypoint:
+		case DirectiveHealthcheck:
+			var ok bool
+			if prefix, args, ok = splitHealthcheck(args); !ok {
+				continue
+			}
+		default:
+			continue
+		}
+		cc.enterConvertedLine(line)
+
+		exec, isExec := parseExecForm(args)
+		if !isExec {
+			switch {
+			case strings.ContainsAny(args, shellMetacharacters) || args == "":
+				cc.report(SeverityWarning, DiagnosticShellForm,
+					"%s needs a shell, which %s does not have: use the exec form or a -dev image", directive, cc.Stage.ConvertedFrom)
+			case directive == DirectiveEntrypoint && hasCmd:
+				// The exec form would pass CMD to the entrypoint, which the shell form ignores
+				cc.report(SeverityWarning, DiagnosticShellForm,
+					"ENTRYPOINT needs a shell, which %s does not have: use the exec form", cc.Stage.ConvertedFrom)
+			default:
+				exec = strings.Fields(args)
+				line.Converted = prefix + renderExecForm(exec)
+				cc.report(SeverityInfo, DiagnosticShellFormConverted,
+					"converted %s to exec form as %s has no shell", directive, cc.Stage.ConvertedFrom)
+			}
+		}
+
+		if directive == DirectiveHealthcheck {
+			if len(exec) == 0 {
+				exec = strings.Fields(args)
+			}
+			if len(exec) > 0 {
+				tool := path.Base(exec[0])
+				if slices.Contains(healthcheckTools, tool) && !slices.Contains(cc.Stage.Packages, tool) {
+					cc.report(SeverityWarning, DiagnosticHealthcheckTool,
+						"HEALTHCHECK runs %s, which %s does not have", tool, cc.Stage.ConvertedFrom)
+				}
+			}
+		}
+	}
+}
+
+// splitHealthcheck splits the arguments of a HEALTHCHECK instruction into the instruction up to
+// and including CMD, and the command. It returns false for HEALTHCHECK NONE.
+func splitHealthcheck(args string) (string, string, bool) {
+	prefix := DirectiveHealthcheck + " "
+	for rest := args; ; {
+		field, after, _ := strings.Cut(rest, " ")
+		switch {
+		case strings.HasPrefix(field, "--"):
+			prefix += field + " "
+			rest = strings.TrimSpace(after)
+		case strings.EqualFold(field, DirectiveCmd):
+			return prefix + DirectiveCmd + " ", strings.TrimSpace(after), true
+		default:
+			return "", "", false
+		}
+	}
+}
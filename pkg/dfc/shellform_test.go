@@ -0,0 +1,125 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConvertShellForms(t *testing.T) {
+	testCases := []struct {
+		name        string
+		raw         string
+		expected    string
+		diagnostics []string
+	}{
+		{
+			name: "simple CMD is converted to exec form",
+			raw: `FROM node:20
+WORKDIR /app
+CMD node server.js`,
+			expected: `FROM cgr.dev/ORG/node:20
+WORKDIR /app
+CMD ["server.js"]`,
+			diagnostics: []string{"shell-form-converted: converted CMD to exec form as cgr.dev/ORG/node:20 has no shell"},
+		},
+		{
+			name: "simple ENTRYPOINT without CMD is converted to exec form",
+			raw: `FROM static
+ENTRYPOINT ./start.sh --serve`,
+			expected: `FROM cgr.dev/ORG/static:latest
+ENTRYPOINT ["./start.sh", "--serve"]`,
+			diagnostics: []string{"shell-form-converted: converted ENTRYPOINT to exec form as cgr.dev/ORG/static:latest has no shell"},
+		},
+		{
+			name: "ENTRYPOINT with a CMD is reported",
+			raw: `FROM static
+ENTRYPOINT ./start.sh
+CMD ["--serve"]`,
+			expected: `FROM cgr.dev/ORG/static:latest
+ENTRYPOINT ./start.sh
+CMD ["--serve"]`,
+			diagnostics: []string{"shell-form: ENTRYPOINT needs a shell, which cgr.dev/ORG/static:latest does not have: use the exec form"},
+		},
+		{
+			name: "commands using the shell are reported",
+			raw: `FROM static
+CMD ./app --port $PORT`,
+			expected: `FROM cgr.dev/ORG/static:latest
+CMD ./app --port $PORT`,
+			diagnostics: []string{"shell-form: CMD needs a shell, which cgr.dev/ORG/static:latest does not have: use the exec form or a -dev image"},
+		},
+		{
+			name: "HEALTHCHECK running curl",
+			raw: `FROM static
+HEALTHCHECK --interval=30s --timeout=3s CMD curl -f localhost`,
+			expected: `FROM cgr.dev/ORG/static:latest
+HEALTHCHECK --interval=30s --timeout=3s CMD ["curl", "-f", "localhost"]`,
+			diagnostics: []string{
+				"shell-form-converted: converted HEALTHCHECK to exec form as cgr.dev/ORG/static:latest has no shell",
+				"healthcheck-tool: HEALTHCHECK runs curl, which cgr.dev/ORG/static:latest does not have",
+			},
+		},
+		{
+			name: "HEALTHCHECK NONE",
+			raw: `FROM static
+HEALTHCHECK NONE`,
+			expected: `FROM cgr.dev/ORG/static:latest
+HEALTHCHECK NONE`,
+		},
+		{
+			name: "-dev images have a shell",
+			raw: `FROM python:3.12
+RUN pip install flask
+HEALTHCHECK CMD wget -q -O- localhost || exit 1
+CMD flask run`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+RUN pip install flask
+HEALTHCHECK CMD wget -q -O- localhost || exit 1
+ENTRYPOINT []
+CMD flask run`,
+		},
+		{
+			name: "images with a shell",
+			raw: `FROM debian:bookworm
+CMD ./app --port $PORT`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+CMD ./app --port $PORT`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{Organization: "ORG"})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var diagnostics []string
+			for _, d := range converted.Diagnostics {
+				switch d.Code {
+				case DiagnosticShellForm, DiagnosticShellFormConverted, DiagnosticHealthcheckTool:
+					diagnostics = append(diagnostics, d.Code+": "+d.Message)
+				}
+			}
+			if diff := cmp.Diff(tc.diagnostics, diagnostics); diff != "" {
+				t.Errorf("diagnostics not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// resolveTagAlias returns the tag with a named tag of the image, such as "lts" or "hydrogen" for
+// node, replaced by the version it stands for. The alias may be followed by variants, so that
+// "iron-alpine" becomes "20-alpine". It returns false if the tag has no alias.
+func resolveTagAlias(aliases map[string]map[string]string, base, tag string, mirrors []string) (string, bool) {
+	if tag == "" || strings.Contains(tag, "$") {
+		return "", false
+	}
+	imageAliases := lookupTagAliases(aliases, base, mirrors)
+	if version, ok := imageAliases[tag]; ok {
+		return version, true
+	}
+	if name, variants, ok := strings.Cut(tag, "-"); ok {
+		if version, ok := imageAliases[name]; ok {
+			return version + "-" + variants, true
+		}
+	}
+	return "", false
+}
+
+// lookupTagAliases returns the tag aliases of an original image, matching the keys like the images
+// section does: the full name, then the name without Docker Hub prefixes, then the last element
+func lookupTagAliases(aliases map[string]map[string]string, base string, mirrors []string) map[string]string {
+	base = StripMirrorPrefix(base, mirrors)
+	name := normalizeImageName(base)
+	for _, key := range []string{base, name, strings.TrimPrefix(name, "library/"), filepath.Base(base)} {
+		if imageAliases, ok := aliases[key]; ok {
+			return imageAliases
+		}
+	}
+	return nil
+}
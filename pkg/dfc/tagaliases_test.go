@@ -0,0 +1,150 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTagAliases(t *testing.T) {
+	extra := MappingsConfig{
+		Tags:    map[string]TagRule{"*": {Match: "-.*$"}},
+		Images:  map[string]string{"ubuntu": "chainguard-base:latest"},
+		Mirrors: []string{"mirror.gcr.io"},
+		TagAliases: map[string]map[string]string{
+			"node":         {"lts": "20", "iron": "20", "hydrogen": "18"},
+			"acme/service": {"stable": "2.4.1", "edge": "latest"},
+			"ubuntu":       {"jammy": "22.04"},
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		raw         string
+		expected    string
+		diagnostics []string
+	}{
+		{
+			name:        "alias",
+			raw:         `FROM node:lts`,
+			expected:    `FROM cgr.dev/ORG/node:20`,
+			diagnostics: []string{"tag-alias-resolved: node:lts is an alias of node:20"},
+		},
+		{
+			name: "alias followed by variants",
+			raw: `FROM node:hydrogen-bookworm-slim
+RUN npm ci`,
+			expected: `FROM cgr.dev/ORG/node:18-dev
+RUN npm ci`,
+			diagnostics: []string{"tag-alias-resolved: node:hydrogen-bookworm-slim is an alias of node:18-bookworm-slim"},
+		},
+		{
+			name:        "alias of a Docker Hub image behind a mirror",
+			raw:         `FROM mirror.gcr.io/library/node:iron`,
+			expected:    `FROM cgr.dev/ORG/node:20`,
+			diagnostics: []string{"tag-alias-resolved: node:iron is an alias of node:20"},
+		},
+		{
+			name:        "alias version is truncated",
+			raw:         `FROM docker.io/acme/service:stable`,
+			expected:    `FROM cgr.dev/ORG/service:2.4`,
+			diagnostics: []string{"tag-alias-resolved: service:stable is an alias of service:2.4.1"},
+		},
+		{
+			name:     "alias of an image mapped to a fixed tag is not reported",
+			raw:      `FROM ubuntu:jammy`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest`,
+		},
+		{
+			name:     "alias that does not change the tag is not reported",
+			raw:      `FROM acme/service:edge`,
+			expected: `FROM cgr.dev/ORG/service:latest`,
+		},
+		{
+			name:     "unknown named tag",
+			raw:      `FROM node:gallium-alpine`,
+			expected: `FROM cgr.dev/ORG/node:latest`,
+		},
+		{
+			name: "ARG default",
+			raw: `ARG BASE=node:lts
+FROM ${BASE}`,
+			expected: `ARG BASE=cgr.dev/ORG/node:20
+FROM ${BASE}`,
+			diagnostics: []string{"tag-alias-resolved: node:lts is an alias of node:20"},
+		},
+		{
+			name:     "versions are not aliases",
+			raw:      `FROM node:20.11-alpine`,
+			expected: `FROM cgr.dev/ORG/node:20.11`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{Organization: "ORG", NoBuiltIn: true, ExtraMappings: extra})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var diagnostics []string
+			for _, d := range converted.Diagnostics {
+				if d.Code == DiagnosticTagAliasResolved {
+					diagnostics = append(diagnostics, d.Code+": "+d.Message)
+				}
+			}
+			if diff := cmp.Diff(tc.diagnostics, diagnostics); diff != "" {
+				t.Errorf("diagnostics not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBuiltinNodeCurrent(t *testing.T) {
+	// node:current has no alias, as the latest tag it converts to follows the newest release line
+	ctx := context.Background()
+	parsed, err := ParseDockerfile(ctx, []byte(`FROM node:current-alpine`))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	converted, err := parsed.Convert(ctx, Options{Organization: "ORG"})
+	if err != nil {
+		t.Fatalf("Failed to convert Dockerfile: %v", err)
+	}
+	if diff := cmp.Diff(`FROM cgr.dev/ORG/node:latest`, strings.TrimSpace(converted.String())); diff != "" {
+		t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+	}
+	for _, d := range converted.Diagnostics {
+		if d.Code == DiagnosticTagAliasResolved {
+			t.Errorf("unexpected diagnostic: %s", d.Message)
+		}
+	}
+}
+
+func TestMergeTagAliases(t *testing.T) {
+	base := MappingsConfig{TagAliases: map[string]map[string]string{"node": {"lts": "22", "iron": "20"}}}
+	overlay := MappingsConfig{TagAliases: map[string]map[string]string{"node": {"lts": "24"}, "debian": {"trixie": "13"}}}
+
+	want := map[string]map[string]string{
+		"node":   {"lts": "24", "iron": "20"},
+		"debian": {"trixie": "13"},
+	}
+	if diff := cmp.Diff(want, MergeMappings(base, overlay).TagAliases); diff != "" {
+		t.Errorf("MergeMappings() tag aliases not as expected (-want, +got):\n%s", diff)
+	}
+}
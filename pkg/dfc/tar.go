@@ -15,146 +15,323 @@ const (
 	CommandBusyBoxTar = "tar"
 )
 
-// ConvertGNUTarToBusyboxTar converts a GNU tar command to the equivalent BusyBox tar command
-// BusyBox tar has fewer options and some different syntax compared to GNU tar
-func ConvertGNUTarToBusyboxTar(part *ShellPart) *ShellPart {
-	if part.Command != CommandGNUTar {
-		return part
-	}
+// PackageTar is the package providing GNU tar
+const PackageTar = "tar"
 
-	// Create a new shell part with the same extra content and delimiter
-	result := &ShellPart{
-		ExtraPre:  part.ExtraPre,
-		Command:   CommandBusyBoxTar,
-		Delimiter: part.Delimiter,
+// tarOption describes a GNU tar option and its busybox equivalent
+type tarOption struct {
+	short       byte     // Short form of the option, 0 if there is none
+	long        []string // Long forms of the option, without the leading "--"
+	hasValue    bool     // The option takes an argument
+	busybox     string   // Equivalent busybox option, empty to drop the option
+	unsupported bool     // busybox has no equivalent, GNU tar is required
+}
+
+// tarOptions is the GNU tar option table
+var tarOptions = []tarOption{
+	// Operation modes
+	{short: 'c', long: []string{"create"}, busybox: "-c"},
+	{short: 'x', long: []string{"extract", "get"}, busybox: "-x"},
+	{short: 't', long: []string{"list"}, busybox: "-t"},
+	{short: 'r', long: []string{"append"}, unsupported: true},
+	{short: 'u', long: []string{"update"}, unsupported: true},
+	{short: 'A', long: []string{"catenate", "concatenate"}, unsupported: true},
+	{short: 'd', long: []string{"diff", "compare"}, unsupported: true},
+	{long: []string{"delete", "test-label"}, unsupported: true},
+
+	// Archive and directory
+	{short: 'f', long: []string{"file"}, hasValue: true, busybox: "-f"},
+	{short: 'C', long: []string{"directory"}, hasValue: true, busybox: "-C"},
+	{short: 'T', long: []string{"files-from"}, hasValue: true, busybox: "-T"},
+	{short: 'X', long: []string{"exclude-from"}, hasValue: true, busybox: "-X"},
+	{long: []string{"exclude"}, hasValue: true, busybox: "--exclude"},
+	{long: []string{"strip-components"}, hasValue: true, busybox: "--strip-components"},
+	{long: []string{"to-command"}, hasValue: true, busybox: "--to-command"},
+
+	// Compression
+	{short: 'z', long: []string{"gzip", "gunzip", "ungzip"}, busybox: "-z"},
+	{short: 'j', long: []string{"bzip2"}, busybox: "-j"},
+	{short: 'J', long: []string{"xz"}, busybox: "-J"},
+	{short: 'Z', long: []string{"compress", "uncompress"}, busybox: "-Z"},
+	{short: 'a', long: []string{"auto-compress"}, busybox: "-a"},
+	{long: []string{"lzma"}, busybox: "--lzma"},
+	{long: []string{"zstd", "lzip", "lzop"}, unsupported: true},
+	{short: 'I', long: []string{"use-compress-program"}, hasValue: true, unsupported: true},
+
+	// Behaviour supported by busybox
+	{short: 'v', long: []string{"verbose"}, busybox: "-v"},
+	{short: 'O', long: []string{"to-stdout"}, busybox: "-O"},
+	{short: 'o', long: []string{"no-same-owner"}, busybox: "-o"}, // -o is --old-archive when creating, see shortO
+	{short: 'm', long: []string{"touch"}, busybox: "-m"},
+	{short: 'k', long: []string{"keep-old-files"}, busybox: "-k"},
+	{short: 'h', long: []string{"dereference"}, busybox: "-h"},
+	{long: []string{"no-recursion"}, busybox: "--no-recursion"},
+	{long: []string{"no-same-permissions"}, busybox: "--no-same-permissions"},
+
+	// Options that are the default in busybox or only affect the output
+	{short: 'p', long: []string{"same-permissions", "preserve-permissions"}},
+	{short: 's', long: []string{"same-order", "preserve-order"}},
+	{long: []string{"same-owner", "preserve", "numeric-owner", "overwrite", "ignore-failed-read", "no-wildcards", "no-overwrite-dir",
+		"totals", "checkpoint", "checkpoint-action", "warning"}},
+	{short: 'B', long: []string{"read-full-records"}},
+
+	// Options that need GNU tar
+	{short: 'P', long: []string{"absolute-names"}, unsupported: true},
+	{short: 'S', long: []string{"sparse"}, unsupported: true},
+	{short: 'W', long: []string{"verify"}, unsupported: true},
+	{short: 'G', long: []string{"incremental"}, unsupported: true},
+	{short: 'M', long: []string{"multi-volume"}, unsupported: true},
+	{short: 'U', long: []string{"unlink-first"}, unsupported: true},
+	{long: []string{"wildcards", "wildcards-match-slash", "anchored", "acls", "xattrs", "selinux",
+		"remove-files", "keep-newer-files", "skip-old-files", "recursive-unlink", "one-file-system",
+		"exclude-vcs", "exclude-caches", "null", "verbatim-files-from", "old-archive", "portability"}, unsupported: true},
+	{short: 'H', long: []string{"format"}, hasValue: true, unsupported: true},
+	{short: 'g', long: []string{"listed-incremental"}, hasValue: true, unsupported: true},
+	{short: 'N', long: []string{"newer", "after-date"}, hasValue: true, unsupported: true},
+	{short: 'b', long: []string{"blocking-factor"}, hasValue: true, unsupported: true},
+	{short: 'K', long: []string{"starting-file"}, hasValue: true, unsupported: true},
+	{short: 'L', long: []string{"tape-length"}, hasValue: true, unsupported: true},
+	{long: []string{"transform", "xform", "owner", "group", "mode", "mtime", "sort", "newer-mtime",
+		"index-file"}, hasValue: true, unsupported: true},
+}
+
+// lookupTarShortOption finds a tar option by its short form
+func lookupTarShortOption(c byte) (tarOption, bool) {
+	for _, opt := range tarOptions {
+		if opt.short == c {
+			return opt, true
+		}
 	}
+	return tarOption{}, false
+}
 
-	// We'll use separate slices for options, files, and file option
-	var options []string
-	var files []string
-	var hasFile bool
-	var filename string
-
-	i := 0
-
-	// First pass to check for common options and gather information
-	for i < len(part.Args) {
-		arg := part.Args[i]
-
-		// Handle the main operation flags (first argument usually)
-		if i == 0 && !strings.HasPrefix(arg, "-") && len(arg) > 0 {
-			// Convert combined options like "xvf" to individual options
-			for _, c := range arg {
-				switch c {
-				case 'x':
-					options = append(options, "-x")
-				case 'c':
-					options = append(options, "-c")
-				case 'v':
-					options = append(options, "-v")
-				case 'f':
-					hasFile = true
-					// Skip adding -f here, we'll add it with the filename
-					if i+1 < len(part.Args) {
-						filename = part.Args[i+1]
-						i++
-					}
-				case 'z':
-					options = append(options, "-z")
-				case 'j':
-					options = append(options, "-j")
-				default:
-					// Pass through other single-letter options
-					options = append(options, "-"+string(c))
-				}
+// lookupTarLongOption finds a tar option by its long form
+func lookupTarLongOption(name string) (tarOption, bool) {
+	for _, opt := range tarOptions {
+		for _, long := range opt.long {
+			if long == name {
+				return opt, true
 			}
-			i++
-			continue
 		}
+	}
+	return tarOption{}, false
+}
 
-		// Handle --file=value format
-		if strings.HasPrefix(arg, "--file=") {
-			hasFile = true
-			filename = arg[7:] // Extract part after --file=
-			i++
-			continue
+// tarInvocation is the parsed form of a tar command line
+type tarInvocation struct {
+	options     []string // busybox options, in order
+	files       []string // files and directories to operate on
+	archive     string   // value of -f, if any
+	create      bool
+	directories int  // number of -C options
+	dirAfter    bool // a -C option follows some of the files
+	operands    bool // a file or the separate value of an option was seen
+	moveDir     bool // a -C option follows a file or the separate value of an option
+	shortO      bool // -o was given, which means --old-archive in create mode
+	unsupported bool // an option requires GNU tar
+	changed     bool // an option is spelled differently or dropped in busybox tar
+}
+
+// apply records a parsed option and its value
+func (t *tarInvocation) apply(opt tarOption, value string) {
+	switch {
+	case opt.unsupported:
+		t.unsupported = true
+	case opt.short == 'f':
+		t.archive = value
+	case opt.short == 'C':
+		t.directories++
+		if len(t.files) > 0 {
+			t.dirAfter = true
+		}
+		if t.operands {
+			t.moveDir = true
+		}
+		t.options = append(t.options, opt.busybox, value)
+	case opt.busybox == "":
+		// Not needed in busybox tar
+	case opt.hasValue:
+		t.options = append(t.options, opt.busybox, value)
+	default:
+		if opt.short == 'c' {
+			t.create = true
 		}
+		t.options = append(t.options, opt.busybox)
+	}
+}
 
-		// Handle long options and their short equivalents
-		switch arg {
-		// Extract operations
-		case "--extract", "-x":
-			options = append(options, "-x")
-
-		// Create operations
-		case "--create", "-c":
-			options = append(options, "-c")
-
-		// Verbose output
-		case "--verbose", "-v":
-			options = append(options, "-v")
-
-		// File specification
-		case "--file", "-f":
-			hasFile = true
-			if i+1 < len(part.Args) {
-				filename = part.Args[i+1]
-				i += 2
-				continue
-			}
+// parseTarArgs parses the arguments of a GNU tar command: old-style bundled options as the first
+// argument ("xvf archive.tar"), short options which may be bundled and take their argument either
+// attached or from the next argument ("-xzf archive.tar", "-C/tmp"), and long options with the
+// argument either attached or separate ("--file=archive.tar", "--file archive.tar")
+func parseTarArgs(args []string) *tarInvocation {
+	t := &tarInvocation{}
 
-		// Compress with gzip
-		case "--gzip", "--gunzip", "-z":
-			options = append(options, "-z")
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
 
-		// Compress with bzip2
-		case "--bzip2", "-j":
-			options = append(options, "-j")
+		switch {
+		case arg == "--":
+			// Everything after "--" is a file
+			t.files = append(t.files, args[i+1:]...)
+			return t
 
-		// Change directory
-		case "--directory", "-C":
-			if i+1 < len(part.Args) {
-				options = append(options, "-C", part.Args[i+1])
-				i += 2
+		case strings.HasPrefix(arg, "--"):
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			opt, ok := lookupTarLongOption(name)
+			if !ok {
+				t.unsupported = true
 				continue
 			}
+			if opt.busybox != "--"+name {
+				t.changed = true
+			}
+			separate := opt.hasValue && !hasValue && i+1 < len(args)
+			if separate {
+				value = args[i+1]
+				i++
+			}
+			t.apply(opt, value)
+			t.operands = t.operands || separate
 
-		// Handle unsupported or ignored GNU tar options
-		case "--same-owner", "--preserve-permissions", "--preserve-order",
-			"--preserve", "--same-permissions", "--numeric-owner",
-			"--overwrite", "--remove-files", "--ignore-failed-read":
-			// These options are either default or not needed in BusyBox tar
-			i++
-			continue
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			for j := 1; j < len(arg); j++ {
+				opt, ok := lookupTarShortOption(arg[j])
+				if !ok {
+					t.unsupported = true
+					continue
+				}
+				t.shortO = t.shortO || arg[j] == 'o'
+				if opt.busybox != "-"+string(arg[j]) {
+					t.changed = true
+				}
+				if !opt.hasValue {
+					t.apply(opt, "")
+					continue
+				}
+				// The argument is the rest of the bundle or the next argument
+				var value string
+				separate := false
+				if j+1 < len(arg) {
+					value = arg[j+1:]
+				} else if i+1 < len(args) {
+					value = args[i+1]
+					separate = true
+					i++
+				}
+				t.apply(opt, value)
+				t.operands = t.operands || separate
+				break
+			}
 
-		default:
-			// Check if it's a long option we need to skip
-			if strings.HasPrefix(arg, "--") {
-				// Skip unknown long options
-				i++
-				continue
+		case i == 0:
+			// Old-style options: options taking an argument consume the following arguments in order
+			t.changed = true
+			next := i + 1
+			for j := 0; j < len(arg); j++ {
+				opt, ok := lookupTarShortOption(arg[j])
+				if !ok {
+					t.unsupported = true
+					continue
+				}
+				t.shortO = t.shortO || arg[j] == 'o'
+
+				var value string
+				if opt.hasValue && next < len(args) {
+					value = args[next]
+					next++
+				}
+				t.apply(opt, value)
 			}
+			i = next - 1
 
-			// If it doesn't start with -, it's probably a file or directory
-			files = append(files, arg)
+		default:
+			t.files = append(t.files, arg)
+			t.operands = true
 		}
-		i++
 	}
 
-	// Build the final args in the correct order
-	var resultArgs []string
+	return t
+}
 
-	// First add all the options
-	resultArgs = append(resultArgs, options...)
+// requiresGNU reports whether the invocation cannot be expressed with busybox tar
+func (t *tarInvocation) requiresGNU() bool {
+	// GNU tar applies -C to the files that follow it, busybox only supports a single -C
+	// applying to the whole archive
+	return t.unsupported || t.directories > 1 || (t.create && (t.dirAfter || t.shortO))
+}
 
-	// Then add the files list
-	resultArgs = append(resultArgs, files...)
+// RequiresGNUTar reports whether a tar command uses options that busybox tar does not support,
+// in which case the tar package is needed
+func RequiresGNUTar(part *ShellPart) bool {
+	if part.Command != CommandGNUTar {
+		return false
+	}
+	return parseTarArgs(part.Args).requiresGNU()
+}
+
+// ConvertGNUTarToBusyboxTar converts a GNU tar command to the equivalent BusyBox tar command
+// BusyBox tar has fewer options and some different syntax compared to GNU tar. Commands that
+// cannot be expressed with busybox tar (see RequiresGNUTar) are returned unchanged.
+func ConvertGNUTarToBusyboxTar(part *ShellPart) *ShellPart {
+	if part.Command != CommandGNUTar {
+		return part
+	}
 
-	// Finally add the file option at the end if present
-	if hasFile && filename != "" {
-		resultArgs = append(resultArgs, "-f", filename)
+	t := parseTarArgs(part.Args)
+	if t.requiresGNU() {
+		return part
+	}
+
+	// Options busybox understands as written are kept as is, only moving a -C that follows the
+	// files or the archive to the front
+	if !t.changed {
+		if !t.moveDir {
+			return part
+		}
+		return &ShellPart{
+			ExtraPre:  part.ExtraPre,
+			Command:   CommandBusyBoxTar,
+			Args:      moveTarDirectoryFirst(part.Args),
+			Delimiter: part.Delimiter,
+		}
+	}
+
+	// Create a new shell part with the same extra content and delimiter
+	result := &ShellPart{
+		ExtraPre:  part.ExtraPre,
+		Command:   CommandBusyBoxTar,
+		Delimiter: part.Delimiter,
+	}
+
+	// Build the final args in the correct order: options, files, and the archive at the end
+	var resultArgs []string
+	resultArgs = append(resultArgs, t.options...)
+	resultArgs = append(resultArgs, t.files...)
+	if t.archive != "" {
+		resultArgs = append(resultArgs, "-f", t.archive)
 	}
 
 	result.Args = resultArgs
 	return result
 }
+
+// moveTarDirectoryFirst moves the -C options of the arguments of a tar command to the front
+func moveTarDirectoryFirst(args []string) []string {
+	var directory, rest []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--":
+			rest = append(rest, args[i:]...)
+			return append(directory, rest...)
+		case (args[i] == "-C" || args[i] == "--directory") && i+1 < len(args):
+			directory = append(directory, args[i], args[i+1])
+			i++
+		case strings.HasPrefix(args[i], "-C") || strings.HasPrefix(args[i], "--directory="):
+			directory = append(directory, args[i])
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return append(directory, rest...)
+}
@@ -162,6 +162,83 @@ func TestConvertGNUTarToBusyboxTar(t *testing.T) {
 				Args:    []string{"-x", "-z", "-f", "archive.tar.gz"},
 			},
 		},
+		{
+			name: "long options with xz compression",
+			input: &ShellPart{
+				Command: CommandGNUTar,
+				Args:    []string{"--extract", "--xz", "--file=archive.tar.xz"},
+			},
+			expected: &ShellPart{
+				Command: CommandBusyBoxTar,
+				Args:    []string{"-x", "-J", "-f", "archive.tar.xz"},
+			},
+		},
+		{
+			name: "bundled short options with attached archive",
+			input: &ShellPart{
+				Command: CommandGNUTar,
+				Args:    []string{"-xJfarchive.tar.xz", "-C/opt"},
+			},
+			expected: &ShellPart{
+				Command: CommandBusyBoxTar,
+				Args:    []string{"-xJfarchive.tar.xz", "-C/opt"},
+			},
+		},
+		{
+			name: "old-style options consume arguments in order",
+			input: &ShellPart{
+				Command: CommandGNUTar,
+				Args:    []string{"xzfC", "archive.tar.gz", "/opt", "dir1"},
+			},
+			expected: &ShellPart{
+				Command: CommandBusyBoxTar,
+				Args:    []string{"-x", "-z", "-C", "/opt", "dir1", "-f", "archive.tar.gz"},
+			},
+		},
+		{
+			name: "strip components, exclude and no same owner",
+			input: &ShellPart{
+				Command: CommandGNUTar,
+				Args:    []string{"-xzf", "node.tar.gz", "--strip-components=1", "--exclude", "*.md", "--no-same-owner", "-C", "/usr/local"},
+			},
+			expected: &ShellPart{
+				Command: CommandBusyBoxTar,
+				Args:    []string{"-x", "-z", "--strip-components", "1", "--exclude", "*.md", "-o", "-C", "/usr/local", "-f", "node.tar.gz"},
+			},
+		},
+		{
+			name: "directory after files when extracting",
+			input: &ShellPart{
+				Command: CommandGNUTar,
+				Args:    []string{"-xf", "archive.tar", "bin/app", "-C", "/usr/local"},
+			},
+			expected: &ShellPart{
+				Command: CommandBusyBoxTar,
+				Args:    []string{"-C", "/usr/local", "-xf", "archive.tar", "bin/app"},
+			},
+		},
+		{
+			name: "bundled options busybox understands are kept",
+			input: &ShellPart{
+				Command: CommandGNUTar,
+				Args:    []string{"-C", "/src", "-czvf", "out.tar.gz", "."},
+			},
+			expected: &ShellPart{
+				Command: CommandBusyBoxTar,
+				Args:    []string{"-C", "/src", "-czvf", "out.tar.gz", "."},
+			},
+		},
+		{
+			name: "left unchanged when GNU tar is required",
+			input: &ShellPart{
+				Command: CommandGNUTar,
+				Args:    []string{"--zstd", "-xf", "archive.tar.zst"},
+			},
+			expected: &ShellPart{
+				Command: CommandGNUTar,
+				Args:    []string{"--zstd", "-xf", "archive.tar.zst"},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -196,3 +273,35 @@ func TestConvertGNUTarToBusyboxTar(t *testing.T) {
 		})
 	}
 }
+
+func TestRequiresGNUTar(t *testing.T) {
+	testCases := []struct {
+		name     string
+		args     []string
+		expected bool
+	}{
+		{name: "old-style extract", args: []string{"xvf", "archive.tar"}, expected: false},
+		{name: "xz compression", args: []string{"-xJf", "archive.tar.xz"}, expected: false},
+		{name: "zstd compression", args: []string{"--zstd", "-xf", "archive.tar.zst"}, expected: true},
+		{name: "compress program", args: []string{"-I", "zstd", "-xf", "archive.tar.zst"}, expected: true},
+		{name: "wildcards", args: []string{"-xf", "archive.tar", "--wildcards", "*/bin/*"}, expected: true},
+		{name: "transform", args: []string{"-xf", "archive.tar", "--transform=s/^a/b/"}, expected: true},
+		{name: "unknown long option", args: []string{"-xf", "archive.tar", "--something-new"}, expected: true},
+		{name: "unknown short option", args: []string{"-xQf", "archive.tar"}, expected: true},
+		{name: "multiple directories", args: []string{"-cf", "out.tar", "-C", "a", "x", "-C", "b", "y"}, expected: true},
+		{name: "create with directory after files", args: []string{"-cf", "out.tar", "x", "-C", "b", "y"}, expected: true},
+		{name: "create with directory before files", args: []string{"-czf", "out.tar.gz", "-C", "/src", "."}, expected: false},
+		{name: "no same owner when extracting", args: []string{"-xof", "archive.tar"}, expected: false},
+		{name: "old archive format when creating", args: []string{"-cof", "out.tar", "dir"}, expected: true},
+		{name: "old-style old archive format when creating", args: []string{"cfo", "out.tar", "dir"}, expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			part := &ShellPart{Command: CommandGNUTar, Args: tc.args}
+			if got := RequiresGNUTar(part); got != tc.expected {
+				t.Errorf("RequiresGNUTar(%v) = %v, want %v", tc.args, got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// addUserDirectives switches each stage that installs packages to root just before its first RUN
+// line, so the RUN lines before the package installs keep running as root like they did on the
+// original image, and switches back at the end of the stage to the last explicit USER of the
+// stage or, failing that, to the user the stage started with: the default user of its image,
+// from the image metadata, or the final user of its parent stage. It returns the user each stage
+// ends with, keyed by stage number, empty if unknown.
+func addUserDirectives(cc *ConversionContext, lines []*DockerfileLine, metadata map[string]ImageMetadata) map[int]string {
+	finalUsers := make(map[int]string)
+
+	for start := 0; start < len(lines); {
+		end := start + 1
+		for end < len(lines) && lines[end].From == nil {
+			end++
+		}
+		if lines[start].From != nil {
+			user := stageStartUser(cc, lines[start], finalUsers, metadata)
+			finalUsers[lines[start].Stage] = addStageUserDirectives(cc, lines, start, end, user)
+		}
+		start = end
+	}
+	return finalUsers
+}
+
+// stageStartUser returns the user a stage starts with, empty if unknown
+func stageStartUser(cc *ConversionContext, fromLine *DockerfileLine, finalUsers map[int]string, metadata map[string]ImageMetadata) string {
+	if fromLine.From.Parent > 0 {
+		return finalUsers[fromLine.From.Parent]
+	}
+	if imageRef := cc.convertedImage(fromLine); imageRef != "" {
+		base, _, _ := splitImageReference(imageRef)
+		return metadata[filepath.Base(base)].User
+	}
+	return ""
+}
+
+// addStageUserDirectives adds the USER directives of the stage made of lines[start:end], which
+// starts as the given user, and returns the user the stage ends with
+func addStageUserDirectives(cc *ConversionContext, lines []*DockerfileLine, start, end int, user string) string {
+	// Only stages that install packages need root
+	needsRoot := false
+	for _, line := range lines[start:end] {
+		if line.Run != nil && line.Converted != "" && line.Run.Manager != "" {
+			needsRoot = true
+			break
+		}
+	}
+
+	restore := user
+	switched, seenRun := false, false
+	for _, line := range lines[start:end] {
+		if explicit, ok := parseUserDirective(line.Raw); ok {
+			user = explicit
+			if switched {
+				// The Dockerfile chose the user from here on
+				return user
+			}
+			restore = explicit
+			continue
+		}
+		if line.Run == nil || seenRun {
+			continue
+		}
+		seenRun = true
+		if needsRoot && !isRootUser(user) {
+			if line.Converted == "" {
+				line.Converted = line.Raw
+			}
+			line.Converted = DirectiveUser + " " + DefaultUser + "\n" + line.Converted
+			user = DefaultUser
+			switched = true
+		}
+	}
+	if !switched {
+		return user
+	}
+
+	if restore == "" {
+		cc.enterConvertedLine(lines[start])
+		cc.report(SeverityInfo, DiagnosticUserNotRestored,
+			"the default user of %s is unknown, the stage keeps running as %s after its package installs", cc.Stage.ConvertedFrom, DefaultUser)
+		return user
+	}
+	if isRootUser(restore) {
+		return restore
+	}
+	// Restore the user after the last instruction of the stage, before any trailing comments
+	last := lines[start]
+	for _, line := range lines[start:end] {
+		if raw := strings.TrimSpace(line.Raw); (raw != "" && !strings.HasPrefix(raw, "#")) || (raw == "" && line.Converted != "") {
+			last = line
+		}
+	}
+	if last.Converted == "" {
+		last.Converted = last.Raw
+	}
+	last.Converted += "\n" + DirectiveUser + " " + restore
+	return restore
+}
+
+// isRootUser reports whether a USER value is the root user
+func isRootUser(user string) bool {
+	name, _, _ := strings.Cut(strings.ToLower(user), ":")
+	return name == DefaultUser || name == "0"
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUserDirectives(t *testing.T) {
+	testCases := []struct {
+		name        string
+		raw         string
+		extra       MappingsConfig
+		expected    string
+		diagnostics []string
+	}{
+		{
+			name: "default user of the image is restored",
+			raw: `FROM python:3.12
+WORKDIR /app
+RUN apt-get update && apt-get install -y curl
+COPY . .
+CMD ["python", "app.py"]`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+WORKDIR /app
+USER root
+RUN apk add --no-cache curl
+COPY . .
+CMD ["app.py"]
+USER nonroot`,
+		},
+		{
+			name: "last explicit user is restored",
+			raw: `FROM python:3.12
+USER app
+RUN apt-get install -y curl
+RUN pip install flask`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+USER app
+USER root
+RUN apk add --no-cache curl
+RUN pip install flask
+USER app`,
+		},
+		{
+			name: "explicit user after the installs is kept",
+			raw: `FROM python:3.12
+RUN apt-get install -y curl
+USER app
+RUN apt-get install -y git`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+USER root
+RUN apk add --no-cache curl
+USER app
+RUN apk add --no-cache git`,
+		},
+		{
+			name: "explicit root user",
+			raw: `FROM python:3.12
+USER root
+RUN apt-get install -y curl`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+USER root
+RUN apk add --no-cache curl`,
+		},
+		{
+			name: "RUN lines after an explicit user run as that user",
+			raw: `FROM python:3.12
+USER root
+RUN apt-get install -y curl
+USER app
+RUN pip install flask`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+USER root
+RUN apk add --no-cache curl
+USER app
+RUN pip install flask`,
+		},
+		{
+			name: "stage built on another stage starts with its final user",
+			raw: `FROM node:20 AS base
+RUN apt-get install -y curl
+FROM base AS build
+RUN apt-get install -y git`,
+			expected: `FROM cgr.dev/ORG/node:20-dev AS base
+USER root
+RUN apk add --no-cache curl
+USER node
+FROM base AS build
+USER root
+RUN apk add --no-cache git
+USER node`,
+		},
+		{
+			name: "general purpose OS image running as root",
+			raw: `FROM debian:bookworm
+RUN apt-get install -y curl`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+RUN apk add --no-cache curl`,
+		},
+		{
+			name: "default user from custom image metadata",
+			raw: `FROM debian:bookworm
+RUN apt-get install -y curl`,
+			extra: MappingsConfig{ImageMetadata: map[string]ImageMetadata{"chainguard-base": {User: "65532"}}},
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache curl
+USER 65532`,
+		},
+		{
+			name: "unknown default user",
+			raw: `FROM acme/base:1
+RUN apt-get install -y curl`,
+			extra: MappingsConfig{Images: map[string]string{"acme/base": "acme-base:latest"}},
+			expected: `FROM cgr.dev/ORG/acme-base:latest
+USER root
+RUN apk add --no-cache curl`,
+			diagnostics: []string{"the default user of cgr.dev/ORG/acme-base:latest is unknown, the stage keeps running as root after its package installs"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{Organization: "ORG", ExtraMappings: tc.extra})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var diagnostics []string
+			for _, d := range converted.Diagnostics {
+				if d.Code == DiagnosticUserNotRestored {
+					diagnostics = append(diagnostics, d.Message)
+				}
+			}
+			if diff := cmp.Diff(tc.diagnostics, diagnostics); diff != "" {
+				t.Errorf("diagnostics not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// VariantRule describes how images with a tag variant are converted. Rules are keyed by the
+// variant, e.g. "jre", or by the OS codename of the tag, e.g. "windowsservercore".
+type VariantRule struct {
+	Images      map[string]string `yaml:"images,omitempty"`      // Image mappings for tags with the variant, take precedence over the images section
+	Unsupported string            `yaml:"unsupported,omitempty"` // Reason images with the variant cannot be converted, they are kept as is
+}
+
+// UnsupportedVariantError is returned when an image has a tag variant that cannot be converted
+type UnsupportedVariantError struct {
+	Image   string // Original image, e.g. "mcr.microsoft.com/dotnet/sdk:8.0-windowsservercore-ltsc2022"
+	Variant string // Unsupported variant, e.g. "windowsservercore"
+	Reason  string // Reason given by the variant rule
+}
+
+func (e *UnsupportedVariantError) Error() string {
+	return fmt.Sprintf("%s has the unsupported variant %s: %s", e.Image, e.Variant, e.Reason)
+}
+
+// tagVersionRegexp matches the leading version of a tag, such as "3.12", "v1.2.3" or "17.0.9_9"
+var tagVersionRegexp = regexp.MustCompile(`^v?\d+(\.\d+)*(_\d+)?$`)
+
+// osVariantRegexp matches the OS and distribution codenames found in upstream tags
+var osVariantRegexp = regexp.MustCompile(`^(alpine(\d+(\.\d+)*)?|bookworm|bullseye|buster|stretch|trixie|noble|jammy|focal|bionic|ubi\d*|windowsservercore|nanoserver)$`)
+
+// parseTagVariants splits a tag into its leading version, its variants and its OS codename, e.g.
+// "3.12-slim-bookworm" into "3.12", ["slim"] and "bookworm". Tags using ARGs are not split.
+func parseTagVariants(tag string) (version string, variants []string, osVariant string) {
+	if tag == "" || tag == "latest" || strings.Contains(tag, "$") {
+		return "", nil, ""
+	}
+	parts := strings.Split(tag, "-")
+	if tagVersionRegexp.MatchString(parts[0]) {
+		version = parts[0]
+		parts = parts[1:]
+	}
+	for _, part := range parts {
+		switch {
+		case part == "":
+		case osVariant == "" && osVariantRegexp.MatchString(part):
+			osVariant = part
+		default:
+			variants = append(variants, part)
+		}
+	}
+	return version, variants, osVariant
+}
+
+// variantKeys returns the keys of the variant rules that may apply to a tag, in tag order. An
+// OS codename with a version, e.g. "alpine3.20", also matches its name alone.
+func variantKeys(tag string) []string {
+	_, variants, osVariant := parseTagVariants(tag)
+	keys := variants
+	if osVariant != "" {
+		keys = append(keys, osVariant)
+		if name := strings.TrimRight(osVariant, "0123456789."); name != osVariant {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+// reportResolveError reports an image that could not be resolved and is kept as is. Unsupported
+// variants are reported as errors, other failures as warnings using the format.
+func reportResolveError(cc *ConversionContext, err error, format string, args ...any) {
+	var unsupported *UnsupportedVariantError
+	if errors.As(err, &unsupported) {
+		cc.report(SeverityError, DiagnosticUnsupportedVariant, "%v, keeping the original image", err)
+		return
+	}
+	cc.report(SeverityWarning, DiagnosticImageResolverError, format, args...)
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseTagVariants(t *testing.T) {
+	tests := []struct {
+		tag      string
+		version  string
+		variants []string
+		os       string
+	}{
+		{tag: "3.12-slim-bookworm", version: "3.12", variants: []string{"slim"}, os: "bookworm"},
+		{tag: "20-alpine", version: "20", os: "alpine"},
+		{tag: "20-alpine3.20", version: "20", os: "alpine3.20"},
+		{tag: "17-jre-jammy", version: "17", variants: []string{"jre"}, os: "jammy"},
+		{tag: "17.0.9_9-jdk", version: "17.0.9_9", variants: []string{"jdk"}},
+		{tag: "8.0-windowsservercore-ltsc2022", version: "8.0", variants: []string{"ltsc2022"}, os: "windowsservercore"},
+		{tag: "bookworm", os: "bookworm"},
+		{tag: "slim", variants: []string{"slim"}},
+		{tag: "v1.2.3", version: "v1.2.3"},
+		{tag: "latest"},
+		{tag: "${VERSION}-slim"},
+		{tag: ""},
+	}
+	for _, tt := range tests {
+		version, variants, osVariant := parseTagVariants(tt.tag)
+		if version != tt.version || !cmp.Equal(variants, tt.variants) || osVariant != tt.os {
+			t.Errorf("parseTagVariants(%q) = (%q, %q, %q), want (%q, %q, %q)",
+				tt.tag, version, variants, osVariant, tt.version, tt.variants, tt.os)
+		}
+	}
+}
+
+func TestVariantConversion(t *testing.T) {
+	testCases := []struct {
+		name        string
+		raw         string
+		extra       MappingsConfig
+		expected    string
+		diagnostics []string
+	}{
+		{
+			name:     "jre variant maps to the jre image",
+			raw:      `FROM eclipse-temurin:17-jre-jammy`,
+			expected: `FROM cgr.dev/ORG/jre:openjdk-17`,
+		},
+		{
+			name:     "jdk variant keeps the jdk image",
+			raw:      `FROM eclipse-temurin:17-jdk-jammy`,
+			expected: `FROM cgr.dev/ORG/jdk:openjdk-17`,
+		},
+		{
+			name:     "slim and OS variants are dropped from the tag",
+			raw:      `FROM python:3.12-slim-bookworm`,
+			expected: `FROM cgr.dev/ORG/python:3.12`,
+		},
+		{
+			name: "windowsservercore is not converted",
+			raw: `FROM mcr.microsoft.com/dotnet/sdk:8.0-windowsservercore-ltsc2022 AS build
+FROM node:20-alpine`,
+			expected: `FROM mcr.microsoft.com/dotnet/sdk:8.0-windowsservercore-ltsc2022 AS build
+FROM cgr.dev/ORG/node:20`,
+			diagnostics: []string{"mcr.microsoft.com/dotnet/sdk:8.0-windowsservercore-ltsc2022 has the unsupported variant windowsservercore: Windows images have no Chainguard equivalent, keeping the original image"},
+		},
+		{
+			name: "custom variant rule",
+			raw:  `FROM node:20-alpine3.20`,
+			extra: MappingsConfig{Variants: map[string]VariantRule{
+				"alpine": {Images: map[string]string{"node": "node-alpine"}},
+			}},
+			expected: `FROM cgr.dev/ORG/node-alpine:20`,
+		},
+		{
+			name: "custom unsupported variant",
+			raw:  `FROM node:20-buster`,
+			extra: MappingsConfig{Variants: map[string]VariantRule{
+				"buster": {Unsupported: "Debian 10 is end of life"},
+			}},
+			expected:    `FROM node:20-buster`,
+			diagnostics: []string{"node:20-buster has the unsupported variant buster: Debian 10 is end of life, keeping the original image"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{Organization: "ORG", ExtraMappings: tc.extra})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, strings.TrimSpace(converted.String())); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var diagnostics []string
+			for _, d := range converted.Diagnostics {
+				if d.Code == DiagnosticUnsupportedVariant {
+					if d.Severity != SeverityError {
+						t.Errorf("severity = %s, want %s", d.Severity, SeverityError)
+					}
+					diagnostics = append(diagnostics, d.Message)
+				}
+			}
+			if diff := cmp.Diff(tc.diagnostics, diagnostics); diff != "" {
+				t.Errorf("diagnostics not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}